@@ -3,44 +3,49 @@ package api
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
-type JSONError struct {
-	Err string `json:"error"`
-}
-
-func (err JSONError) Error() string {
-	return err.Err
-}
-
+// Client talks to a Valar API endpoint. It is safe for concurrent use by
+// multiple goroutines.
 type Client struct {
 	Endpoint string
 	Token    string
 
-	http *http.Client
+	http     *http.Client
+	timeouts Timeouts
+	retry    RetryPolicy
+	debug    io.Writer
 }
 
 func (client *Client) UserInfo() (*UserInfo, error) {
+	return client.UserInfoContext(context.Background())
+}
+
+// UserInfoContext is like UserInfo but carries a context.
+func (client *Client) UserInfoContext(ctx context.Context) (*UserInfo, error) {
 	var userInfo UserInfo
-	if err := client.request(http.MethodGet, "/users/info", &userInfo, nil); err != nil {
+	if err := client.request(ctx, http.MethodGet, "/users/info", &userInfo, nil); err != nil {
 		return nil, err
 	}
 	return &userInfo, nil
 }
 
-func (client *Client) check() error {
+func (client *Client) check(ctx context.Context) error {
 	var resp struct {
 		Version string `json:"version"`
 	}
-	if err := client.request(http.MethodGet, "/", &resp, nil); err != nil {
+	if err := client.request(ctx, http.MethodGet, "/", &resp, nil); err != nil {
 		return err
 	}
 	if resp.Version != "v2" {
@@ -49,74 +54,144 @@ func (client *Client) check() error {
 	return nil
 }
 
-type Error struct {
-	StatusCode  int
-	ServerError error
+// EndpointStatus describes an endpoint as seen by Probe.
+type EndpointStatus struct {
+	// Version is the API version reported by the endpoint.
+	Version string
+	// Latency is the round-trip time of the probe.
+	Latency time.Duration
+	// Date is the time of the endpoint when it responded, or the zero time
+	// if it did not tell.
+	Date time.Time
 }
 
-func (err Error) Error() string {
-	return fmt.Sprintf("%s: %v", http.StatusText(err.StatusCode), err.ServerError)
+// Probe requests the version of an endpoint once, without requiring it to be
+// supported, to diagnose connection problems. The status is also returned if
+// the endpoint responded with an error.
+func Probe(endpoint, token string, opts ...ClientOption) (*EndpointStatus, error) {
+	return ProbeContext(context.Background(), endpoint, token, opts...)
 }
 
-func parseErrorResponse(body []byte) error {
-	serverErr := JSONError{}
-	if err := json.Unmarshal(body, &serverErr); err != nil {
-		return fmt.Errorf("unmarshalling error response '%s': %w", string(body), err)
+// ProbeContext is like Probe but carries a context.
+func ProbeContext(ctx context.Context, endpoint, token string, opts ...ClientOption) (*EndpointStatus, error) {
+	client := newClient(endpoint, token, opts...)
+	if client.timeouts.Request > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.timeouts.Request)
+		defer cancel()
 	}
-	return serverErr
-}
-
-func (client *Client) streamRequest(method, path string, w io.Writer) error {
-	client.http.Timeout = 0
-	req, err := http.NewRequest(method, client.Endpoint+path, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.Endpoint+"/", nil)
 	if err != nil {
-		return fmt.Errorf("client request: %w", err)
+		return nil, fmt.Errorf("client request: %w", err)
 	}
 	req.Header.Add("Authorization", "Bearer "+client.Token)
-
+	start := time.Now()
 	resp, err := client.http.Do(req)
 	if err != nil {
-		return fmt.Errorf("submitting request: %w", err)
+		return nil, fmt.Errorf("submitting request: %w", err)
 	}
 	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	status := &EndpointStatus{Latency: time.Since(start)}
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		status.Date = date
+	}
+	if err != nil {
+		return status, fmt.Errorf("fetching response: %w", err)
+	}
 	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("fetching response: %w", err)
-		}
-		return Error{
-			StatusCode:  resp.StatusCode,
-			ServerError: parseErrorResponse(body),
-		}
+		return status, newError(resp, body)
+	}
+	var version struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(body, &version); err != nil {
+		return status, fmt.Errorf("unmarshalling response: %w", err)
+	}
+	status.Version = version.Version
+	return status, nil
+}
+
+func (client *Client) streamRequest(ctx context.Context, method, path string, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	resp, err := client.send(ctx, method, path, nil, method == http.MethodGet)
+	if err != nil {
+		return err
 	}
-	if _, err := io.Copy(w, resp.Body); err != nil && err != io.EOF {
+	defer resp.Body.Close()
+	var body io.Reader = resp.Body
+	if client.timeouts.Read > 0 {
+		idle := newIdleReader(resp.Body, client.timeouts.Read, cancel)
+		defer idle.Stop()
+		body = idle
+	}
+	if _, err := io.Copy(w, body); err != nil && err != io.EOF {
+		if idle, ok := body.(*idleReader); ok && idle.Expired() {
+			return fmt.Errorf("copying request: no data received for %v: %w", client.timeouts.Read, context.DeadlineExceeded)
+		}
 		return fmt.Errorf("copying request: %w", err)
 	}
 	return nil
 }
 
-func (client *Client) request(method, path string, obj interface{}, post io.Reader) error {
-	client.http.Timeout = time.Minute
-	req, err := http.NewRequest(method, client.Endpoint+path, post)
-	if err != nil {
-		return fmt.Errorf("client request: %w", err)
+// idleReader cancels a stream once no data has been read from it for a while.
+type idleReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func newIdleReader(r io.Reader, timeout time.Duration, cancel context.CancelFunc) *idleReader {
+	idle := &idleReader{r: r, timeout: timeout}
+	idle.timer = time.AfterFunc(timeout, func() {
+		idle.expired.Store(true)
+		cancel()
+	})
+	return idle
+}
+
+func (idle *idleReader) Read(p []byte) (int, error) {
+	n, err := idle.r.Read(p)
+	if n > 0 {
+		idle.timer.Reset(idle.timeout)
 	}
-	req.Header.Add("Authorization", "Bearer "+client.Token)
-	resp, err := client.http.Do(req)
+	return n, err
+}
+
+func (idle *idleReader) Expired() bool {
+	return idle.expired.Load()
+}
+
+func (idle *idleReader) Stop() {
+	idle.timer.Stop()
+}
+
+func (client *Client) request(ctx context.Context, method, path string, obj interface{}, post io.Reader) error {
+	return client.do(ctx, method, path, obj, post, method == http.MethodGet)
+}
+
+// idempotentRequest is like request, but marks a write as safe to retry.
+func (client *Client) idempotentRequest(ctx context.Context, method, path string, obj interface{}, post io.Reader) error {
+	return client.do(ctx, method, path, obj, post, true)
+}
+
+func (client *Client) do(ctx context.Context, method, path string, obj interface{}, post io.Reader, idempotent bool) error {
+	if client.timeouts.Request > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.timeouts.Request)
+		defer cancel()
+	}
+	resp, err := client.send(ctx, method, path, post, idempotent)
 	if err != nil {
-		return fmt.Errorf("submitting request: %w", err)
+		return err
 	}
 	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return fmt.Errorf("fetching request: %w", err)
 	}
-	if resp.StatusCode != http.StatusOK {
-		return Error{
-			StatusCode:  resp.StatusCode,
-			ServerError: parseErrorResponse(body),
-		}
-	}
 	if obj != nil {
 		if err := json.Unmarshal(body, obj); err != nil {
 			return fmt.Errorf("unmarshalling response: %w", err)
@@ -125,13 +200,88 @@ func (client *Client) request(method, path string, obj interface{}, post io.Read
 	return nil
 }
 
+// send submits a request, retrying it according to the retry policy if it is
+// idempotent. The returned response always has status 200 and has to be
+// closed by the caller.
+// Functions passed as prepare adjust every attempt before it is sent.
+func (client *Client) send(ctx context.Context, method, path string, post io.Reader, idempotent bool, prepare ...func(req *http.Request)) (*http.Response, error) {
+	// Bodies that cannot be rewound cannot be sent again.
+	if _, ok := post.(io.Seeker); post != nil && !ok {
+		idempotent = false
+	}
+	for attempt := 1; ; attempt++ {
+		retryable := idempotent && attempt < client.retry.MaxAttempts
+		req, err := http.NewRequestWithContext(ctx, method, client.Endpoint+path, post)
+		if err != nil {
+			return nil, fmt.Errorf("client request: %w", err)
+		}
+		req.Header.Add("Authorization", "Bearer "+client.Token)
+		for _, f := range prepare {
+			f(req)
+		}
+		resp, err := client.http.Do(req)
+		var (
+			reason  string
+			lastErr error
+			wait    = client.retry.backoff(attempt)
+		)
+		switch {
+		case err != nil:
+			lastErr = fmt.Errorf("submitting request: %w", err)
+			if !retryable || ctx.Err() != nil {
+				return nil, lastErr
+			}
+			reason = err.Error()
+		case resp.StatusCode != http.StatusOK:
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return nil, fmt.Errorf("fetching response: %w", err)
+			}
+			lastErr = newError(resp, body)
+			if !retryable || !retryableStatus(resp.StatusCode) {
+				return nil, lastErr
+			}
+			reason = resp.Status
+			// The server may ask for a longer delay, but not for longer
+			// than the policy allows.
+			wait = retryAfter(resp.Header, wait, time.Now())
+			if client.retry.MaxBackoff > 0 {
+				wait = min(wait, client.retry.MaxBackoff)
+			}
+		default:
+			return resp, nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			client.debugf("%s %s: %s, not retrying in %v as it would exceed the deadline", method, path, reason, wait.Round(time.Millisecond))
+			return nil, lastErr
+		}
+		if err := rewind(post); err != nil {
+			return nil, fmt.Errorf("rewinding request: %w", err)
+		}
+		client.debugf("%s %s: %s, retrying in %v (attempt %d of %d)", method, path, reason, wait.Round(time.Millisecond), attempt+1, client.retry.MaxAttempts)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("submitting request: %w", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
 // ListServices returns a list of all services in the project with the given prefix.
 func (client *Client) ListServices(project, service string) ([]Service, error) {
+	return client.ListServicesContext(context.Background(), project, service)
+}
+
+// ListServicesContext is like ListServices but carries a context.
+func (client *Client) ListServicesContext(ctx context.Context, project, service string) ([]Service, error) {
 	var (
 		services []Service
 		path     = fmt.Sprintf("/projects/%s/services/%s", project, service)
 	)
-	if err := client.request(http.MethodGet, path, &services, nil); err != nil {
+	if err := client.request(ctx, http.MethodGet, path, &services, nil); err != nil {
 		return nil, err
 	}
 	return services, nil
@@ -139,6 +289,11 @@ func (client *Client) ListServices(project, service string) ([]Service, error) {
 
 // StreamServiceLogs streams the logs of the latest service endpoint.
 func (client *Client) StreamServiceLogs(project, service string, w io.Writer, follow, tail bool, skip int) error {
+	return client.StreamServiceLogsContext(context.Background(), project, service, w, follow, tail, skip)
+}
+
+// StreamServiceLogsContext is like StreamServiceLogs but carries a context.
+func (client *Client) StreamServiceLogsContext(ctx context.Context, project, service string, w io.Writer, follow, tail bool, skip int) error {
 	params := url.Values{}
 	if follow {
 		params.Set("follow", "true")
@@ -152,7 +307,7 @@ func (client *Client) StreamServiceLogs(project, service string, w io.Writer, fo
 	var (
 		path = fmt.Sprintf("/projects/%s/services/%s/logs?%s", project, service, params.Encode())
 	)
-	if err := client.streamRequest(http.MethodGet, path, w); err != nil {
+	if err := client.streamRequest(ctx, http.MethodGet, path, w); err != nil {
 		return err
 	}
 	return nil
@@ -160,24 +315,140 @@ func (client *Client) StreamServiceLogs(project, service string, w io.Writer, fo
 
 // SubmitArtifact submits a new build input artifact to the server.
 func (client *Client) SubmitArtifact(project, service string, archive io.ReadCloser) (*Artifact, error) {
+	return client.SubmitArtifactContext(context.Background(), project, service, archive)
+}
+
+// SubmitArtifactContext is like SubmitArtifact but carries a context.
+func (client *Client) SubmitArtifactContext(ctx context.Context, project, service string, archive io.ReadCloser) (*Artifact, error) {
 	var (
 		artifact Artifact
 		path     = fmt.Sprintf("/projects/%s/services/%s/artifacts", project, service)
 	)
-	if err := client.request(http.MethodPost, path, &artifact, archive); err != nil {
+	if err := client.request(ctx, http.MethodPost, path, &artifact, archive); err != nil {
 		return nil, err
 	}
 	return &artifact, nil
 }
 
+// ArtifactUpload is an archive to submit as a build input artifact.
+type ArtifactUpload struct {
+	// Archive is the tar archive compressed with Encoding.
+	Archive io.Reader
+	// Encoding is the content encoding of the archive, gzip if empty.
+	Encoding string
+	// Size is the length of the archive. If it is -1, the archive is sent
+	// in chunks as it is read.
+	Size int64
+}
+
+// UploadArtifact submits a build input artifact to the server. Endpoints
+// not accepting chunked uploads fail with ErrLengthRequired, endpoints not
+// accepting the encoding with ErrUnsupportedEncoding.
+func (client *Client) UploadArtifact(project, service string, upload *ArtifactUpload) (*Artifact, error) {
+	return client.UploadArtifactContext(context.Background(), project, service, upload)
+}
+
+// UploadArtifactContext is like UploadArtifact but carries a context.
+//
+// Like streams, uploads are not bounded in total, as large archives take
+// longer to send than regular calls. Instead, the read timeout, or the
+// request timeout if there is none, bounds how long the upload may make no
+// progress.
+func (client *Client) UploadArtifactContext(ctx context.Context, project, service string, upload *ArtifactUpload) (*Artifact, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		artifact Artifact
+		path     = fmt.Sprintf("/projects/%s/services/%s/artifacts", project, service)
+		encoding = upload.Encoding
+		archive  = upload.Archive
+		idle     *idleReader
+	)
+	if encoding == "" {
+		encoding = "gzip"
+	}
+	timeout := client.timeouts.Read
+	if timeout <= 0 {
+		timeout = client.timeouts.Request
+	}
+	if timeout > 0 && archive != nil {
+		idle = newIdleReader(archive, timeout, cancel)
+		defer idle.Stop()
+		archive = idle
+	}
+	stalled := func(err error) error {
+		if idle != nil && idle.Expired() {
+			return fmt.Errorf("submitting request: no progress for %v: %w", timeout, context.DeadlineExceeded)
+		}
+		return err
+	}
+	resp, err := client.send(ctx, http.MethodPost, path, archive, false, func(req *http.Request) {
+		req.ContentLength = upload.Size
+		req.Header.Set("Content-Type", "application/x-tar")
+		req.Header.Set("Content-Encoding", encoding)
+	})
+	if err != nil {
+		return nil, stalled(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&artifact); err != nil {
+		return nil, stalled(fmt.Errorf("unmarshalling response: %w", err))
+	}
+	return &artifact, nil
+}
+
+// ArtifactEncodings returns the content encodings the server accepts for
+// artifacts, as advertised in the Accept-Encoding header of an OPTIONS
+// request. Servers not advertising any are assumed to accept gzip.
+func (client *Client) ArtifactEncodings(project, service string) ([]string, error) {
+	return client.ArtifactEncodingsContext(context.Background(), project, service)
+}
+
+// ArtifactEncodingsContext is like ArtifactEncodings but carries a context.
+func (client *Client) ArtifactEncodingsContext(ctx context.Context, project, service string) ([]string, error) {
+	if client.timeouts.Request > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.timeouts.Request)
+		defer cancel()
+	}
+	path := fmt.Sprintf("/projects/%s/services/%s/artifacts", project, service)
+	resp, err := client.send(ctx, http.MethodOptions, path, nil, true)
+	var apiErr Error
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusMethodNotAllowed) {
+		return []string{"gzip"}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	var encodings []string
+	for _, value := range resp.Header.Values("Accept-Encoding") {
+		for _, encoding := range strings.Split(value, ",") {
+			// Drop quality values, which do not apply to uploads.
+			encoding, _, _ = strings.Cut(encoding, ";")
+			if encoding = strings.TrimSpace(encoding); encoding != "" {
+				encodings = append(encodings, encoding)
+			}
+		}
+	}
+	if len(encodings) == 0 {
+		return []string{"gzip"}, nil
+	}
+	return encodings, nil
+}
+
 // SubmitBuild submits a new build task to the server.
 func (client *Client) SubmitBuild(project, service string, buildRequest *BuildRequest) (*Build, error) {
+	return client.SubmitBuildContext(context.Background(), project, service, buildRequest)
+}
+
+// SubmitBuildContext is like SubmitBuild but carries a context.
+func (client *Client) SubmitBuildContext(ctx context.Context, project, service string, buildRequest *BuildRequest) (*Build, error) {
 	var (
 		payload, _ = json.Marshal(buildRequest)
 		build      Build
 		path       = fmt.Sprintf("/projects/%s/services/%s/builds", project, service)
 	)
-	if err := client.request(http.MethodPost, path, &build, bytes.NewReader(payload)); err != nil {
+	if err := client.request(ctx, http.MethodPost, path, &build, bytes.NewReader(payload)); err != nil {
 		return nil, err
 	}
 	return &build, nil
@@ -185,11 +456,16 @@ func (client *Client) SubmitBuild(project, service string, buildRequest *BuildRe
 
 // AbortBuild aborts a scheduled or running build.
 func (client *Client) AbortBuild(project, service, id string) error {
+	return client.AbortBuildContext(context.Background(), project, service, id)
+}
+
+// AbortBuildContext is like AbortBuild but carries a context.
+func (client *Client) AbortBuildContext(ctx context.Context, project, service, id string) error {
 	var (
 		path = fmt.Sprintf("/projects/%s/services/%s/builds/%s/abort", project, service, id)
 	)
 	var resp struct{}
-	if err := client.request("POST", path, &resp, nil); err != nil {
+	if err := client.idempotentRequest(ctx, "POST", path, &resp, nil); err != nil {
 		return err
 	}
 	return nil
@@ -197,10 +473,15 @@ func (client *Client) AbortBuild(project, service, id string) error {
 
 // Sets a service' status. A disabled service cannot receive any requests.
 func (client *Client) ChangeServiceStatus(project, service string, disabled bool) error {
+	return client.ChangeServiceStatusContext(context.Background(), project, service, disabled)
+}
+
+// ChangeServiceStatusContext is like ChangeServiceStatus but carries a context.
+func (client *Client) ChangeServiceStatusContext(ctx context.Context, project, service string, disabled bool) error {
 	var (
 		path = fmt.Sprintf("/projects/%s/services/%s/status?disabled=%v", project, service, disabled)
 	)
-	if err := client.request("POST", path, nil, nil); err != nil {
+	if err := client.idempotentRequest(ctx, "POST", path, nil, nil); err != nil {
 		return err
 	}
 	return nil
@@ -269,43 +550,52 @@ func (decoder *logEntryDecoder) Wait() {
 
 // ShowBuildLogs retrieves a specific build task logs.
 func (client *Client) ShowBuildLogs(project, service, id string, consumer func(LogEntry)) error {
+	return client.ShowBuildLogsContext(context.Background(), project, service, id, consumer)
+}
+
+// ShowBuildLogsContext is like ShowBuildLogs but carries a context.
+func (client *Client) ShowBuildLogsContext(ctx context.Context, project, service, id string, consumer func(LogEntry)) error {
 	var (
 		path = fmt.Sprintf("/projects/%s/services/%s/builds/%s/logs", project, service, id)
 	)
 	decoder := newLogEntryDecoder(consumer)
-	if err := client.streamRequest(http.MethodGet, path, decoder); err != nil {
-		return err
-	}
-	if err := decoder.Close(); err != nil {
-		return err
-	}
+	err := client.streamRequest(ctx, http.MethodGet, path, decoder)
+	// Drain the entries received so far, even if the stream was cut short.
+	decoder.Close()
 	decoder.Wait()
-	return nil
+	return err
 }
 
 // StreamBuildLogs streams the active build logs to stdout.
 func (client *Client) StreamBuildLogs(project, service, id string, consumer func(LogEntry)) error {
+	return client.StreamBuildLogsContext(context.Background(), project, service, id, consumer)
+}
+
+// StreamBuildLogsContext is like StreamBuildLogs but carries a context.
+func (client *Client) StreamBuildLogsContext(ctx context.Context, project, service, id string, consumer func(LogEntry)) error {
 	var (
 		path = fmt.Sprintf("/projects/%s/services/%s/builds/%s/logs?follow=true", project, service, id)
 	)
 	decoder := newLogEntryDecoder(consumer)
-	if err := client.streamRequest(http.MethodGet, path, decoder); err != nil {
-		return err
-	}
-	if err := decoder.Close(); err != nil {
-		return err
-	}
+	err := client.streamRequest(ctx, http.MethodGet, path, decoder)
+	// Drain the entries received so far, even if the stream was cut short.
+	decoder.Close()
 	decoder.Wait()
-	return nil
+	return err
 }
 
 // InspectBuild retrieves a specific build task.
 func (client *Client) InspectBuild(project, service, id string) (*Build, error) {
+	return client.InspectBuildContext(context.Background(), project, service, id)
+}
+
+// InspectBuildContext is like InspectBuild but carries a context.
+func (client *Client) InspectBuildContext(ctx context.Context, project, service, id string) (*Build, error) {
 	var (
 		task Build
 		path = fmt.Sprintf("/projects/%s/services/%s/builds/%s/inspect", project, service, id)
 	)
-	if err := client.request(http.MethodGet, path, &task, nil); err != nil {
+	if err := client.request(ctx, http.MethodGet, path, &task, nil); err != nil {
 		return nil, err
 	}
 	return &task, nil
@@ -313,11 +603,16 @@ func (client *Client) InspectBuild(project, service, id string) (*Build, error)
 
 // ListBuilds retrieves all builds for a specific service.
 func (client *Client) ListBuilds(project, service, id string) ([]Build, error) {
+	return client.ListBuildsContext(context.Background(), project, service, id)
+}
+
+// ListBuildsContext is like ListBuilds but carries a context.
+func (client *Client) ListBuildsContext(ctx context.Context, project, service, id string) ([]Build, error) {
 	var (
 		tasks []Build
 		path  = fmt.Sprintf("/projects/%s/services/%s/builds/%s", project, service, id)
 	)
-	if err := client.request(http.MethodGet, path, &tasks, nil); err != nil {
+	if err := client.request(ctx, http.MethodGet, path, &tasks, nil); err != nil {
 		return nil, err
 	}
 	return tasks, nil
@@ -325,6 +620,11 @@ func (client *Client) ListBuilds(project, service, id string) ([]Build, error) {
 
 // ListPermissions retrieves all permissions set for a specific project.
 func (client *Client) ListPermissions(project, namespace, prefix string) ([]Permission, error) {
+	return client.ListPermissionsContext(context.Background(), project, namespace, prefix)
+}
+
+// ListPermissionsContext is like ListPermissions but carries a context.
+func (client *Client) ListPermissionsContext(ctx context.Context, project, namespace, prefix string) ([]Permission, error) {
 	params := url.Values{}
 	params.Add("namespace", namespace)
 	params.Add("prefix", prefix)
@@ -332,7 +632,7 @@ func (client *Client) ListPermissions(project, namespace, prefix string) ([]Perm
 		set  = []Permission{}
 		path = fmt.Sprintf("/projects/%s/permissions?%s", project, params.Encode())
 	)
-	if err := client.request(http.MethodGet, path, &set, nil); err != nil {
+	if err := client.request(ctx, http.MethodGet, path, &set, nil); err != nil {
 		return nil, err
 	}
 	return set, nil
@@ -340,6 +640,11 @@ func (client *Client) ListPermissions(project, namespace, prefix string) ([]Perm
 
 // ModifyPermission modifies the permission set of a project.
 func (client *Client) ModifyPermission(project string, permission Permission) (bool, error) {
+	return client.ModifyPermissionContext(context.Background(), project, permission)
+}
+
+// ModifyPermissionContext is like ModifyPermission but carries a context.
+func (client *Client) ModifyPermissionContext(ctx context.Context, project string, permission Permission) (bool, error) {
 	var (
 		resp struct {
 			Modified bool `json:"modified"`
@@ -348,7 +653,7 @@ func (client *Client) ModifyPermission(project string, permission Permission) (b
 		method = http.MethodPost
 	)
 	body, _ := json.Marshal(&permission)
-	if err := client.request(method, path, &resp, bytes.NewReader(body)); err != nil {
+	if err := client.idempotentRequest(ctx, method, path, &resp, bytes.NewReader(body)); err != nil {
 		return false, err
 	}
 	return resp.Modified, nil
@@ -356,6 +661,11 @@ func (client *Client) ModifyPermission(project string, permission Permission) (b
 
 // CheckPermission checks if a user has a specific permission.
 func (client *Client) CheckPermission(project string, permission Permission) (bool, error) {
+	return client.CheckPermissionContext(context.Background(), project, permission)
+}
+
+// CheckPermissionContext is like CheckPermission but carries a context.
+func (client *Client) CheckPermissionContext(ctx context.Context, project string, permission Permission) (bool, error) {
 	var (
 		resp struct {
 			Allowed bool `json:"allowed"`
@@ -364,7 +674,7 @@ func (client *Client) CheckPermission(project string, permission Permission) (bo
 		method = http.MethodPost
 	)
 	body, _ := json.Marshal(&permission)
-	if err := client.request(method, path, &resp, bytes.NewReader(body)); err != nil {
+	if err := client.idempotentRequest(ctx, method, path, &resp, bytes.NewReader(body)); err != nil {
 		return false, err
 	}
 	return resp.Allowed, nil
@@ -372,11 +682,16 @@ func (client *Client) CheckPermission(project string, permission Permission) (bo
 
 // ListDeployments shows all deployments of a specific service.
 func (client *Client) ListDeployments(project, service string) ([]Deployment, error) {
+	return client.ListDeploymentsContext(context.Background(), project, service)
+}
+
+// ListDeploymentsContext is like ListDeployments but carries a context.
+func (client *Client) ListDeploymentsContext(ctx context.Context, project, service string) ([]Deployment, error) {
 	var (
 		depls []Deployment
 		path  = fmt.Sprintf("/projects/%s/services/%s/deploys", project, service)
 	)
-	if err := client.request(http.MethodGet, path, &depls, nil); err != nil {
+	if err := client.request(ctx, http.MethodGet, path, &depls, nil); err != nil {
 		return nil, err
 	}
 	return depls, nil
@@ -384,12 +699,17 @@ func (client *Client) ListDeployments(project, service string) ([]Deployment, er
 
 // SubmitDeploy submits a new deployment of the given build.
 func (client *Client) SubmitDeploy(project, service string, deploy *DeployRequest) (*Deployment, error) {
+	return client.SubmitDeployContext(context.Background(), project, service, deploy)
+}
+
+// SubmitDeployContext is like SubmitDeploy but carries a context.
+func (client *Client) SubmitDeployContext(ctx context.Context, project, service string, deploy *DeployRequest) (*Deployment, error) {
 	var (
 		payload, _ = json.Marshal(deploy)
 		depl       Deployment
 		path       = fmt.Sprintf("/projects/%s/services/%s/deploys", project, service)
 	)
-	if err := client.request(http.MethodPost, path, &depl, bytes.NewReader(payload)); err != nil {
+	if err := client.request(ctx, http.MethodPost, path, &depl, bytes.NewReader(payload)); err != nil {
 		return nil, err
 	}
 	return &depl, nil
@@ -397,41 +717,61 @@ func (client *Client) SubmitDeploy(project, service string, deploy *DeployReques
 
 // RollbackDeploy rolls back to a previous deployment.
 func (client *Client) RollbackDeploy(project, service string, rollback *RollbackRequest) (*Deployment, error) {
+	return client.RollbackDeployContext(context.Background(), project, service, rollback)
+}
+
+// RollbackDeployContext is like RollbackDeploy but carries a context.
+func (client *Client) RollbackDeployContext(ctx context.Context, project, service string, rollback *RollbackRequest) (*Deployment, error) {
 	var (
 		payload, _ = json.Marshal(rollback)
 		depl       Deployment
 		path       = fmt.Sprintf("/projects/%s/services/%s/deploys/rollback", project, service)
 	)
-	if err := client.request(http.MethodPost, path, &depl, bytes.NewReader(payload)); err != nil {
+	if err := client.request(ctx, http.MethodPost, path, &depl, bytes.NewReader(payload)); err != nil {
 		return nil, err
 	}
 	return &depl, nil
 }
 
 func (client *Client) EncryptEnvironment(project, service string, kvpair *KVPair) (*KVPair, error) {
+	return client.EncryptEnvironmentContext(context.Background(), project, service, kvpair)
+}
+
+// EncryptEnvironmentContext is like EncryptEnvironment but carries a context.
+func (client *Client) EncryptEnvironmentContext(ctx context.Context, project, service string, kvpair *KVPair) (*KVPair, error) {
 	var (
 		payload, _ = json.Marshal(kvpair)
 		encrypted  KVPair
 		path       = fmt.Sprintf("/projects/%s/environment/encrypt", project)
 	)
-	if err := client.request(http.MethodPost, path, &encrypted, bytes.NewReader(payload)); err != nil {
+	if err := client.idempotentRequest(ctx, http.MethodPost, path, &encrypted, bytes.NewReader(payload)); err != nil {
 		return nil, err
 	}
 	return &encrypted, nil
 }
 
 func (client *Client) ListDomains(project string) ([]Domain, error) {
+	return client.ListDomainsContext(context.Background(), project)
+}
+
+// ListDomainsContext is like ListDomains but carries a context.
+func (client *Client) ListDomainsContext(ctx context.Context, project string) ([]Domain, error) {
 	var (
 		path    = fmt.Sprintf("/projects/%s/domains", project)
 		domains []Domain
 	)
-	if err := client.request(http.MethodGet, path, &domains, nil); err != nil {
+	if err := client.request(ctx, http.MethodGet, path, &domains, nil); err != nil {
 		return nil, err
 	}
 	return domains, nil
 }
 
 func (client *Client) AddDomain(project, domain string) (map[string]string, error) {
+	return client.AddDomainContext(context.Background(), project, domain)
+}
+
+// AddDomainContext is like AddDomain but carries a context.
+func (client *Client) AddDomainContext(ctx context.Context, project, domain string) (map[string]string, error) {
 	var (
 		records    = make(map[string]string)
 		path       = fmt.Sprintf("/projects/%s/domains", project)
@@ -439,17 +779,22 @@ func (client *Client) AddDomain(project, domain string) (map[string]string, erro
 			Domain string `json:"domain"`
 		}{domain})
 	)
-	if err := client.request(http.MethodPost, path, &records, bytes.NewReader(payload)); err != nil {
+	if err := client.request(ctx, http.MethodPost, path, &records, bytes.NewReader(payload)); err != nil {
 		return nil, err
 	}
 	return records, nil
 }
 
 func (client *Client) DeleteDomain(project, domain string) error {
+	return client.DeleteDomainContext(context.Background(), project, domain)
+}
+
+// DeleteDomainContext is like DeleteDomain but carries a context.
+func (client *Client) DeleteDomainContext(ctx context.Context, project, domain string) error {
 	var (
 		path = fmt.Sprintf("/projects/%s/domains/%s", project, domain)
 	)
-	if err := client.request(http.MethodDelete, path, nil, nil); err != nil {
+	if err := client.request(ctx, http.MethodDelete, path, nil, nil); err != nil {
 		return err
 	}
 	return nil
@@ -463,6 +808,11 @@ type LinkDomainArgs struct {
 }
 
 func (client *Client) LinkDomain(args LinkDomainArgs) error {
+	return client.LinkDomainContext(context.Background(), args)
+}
+
+// LinkDomainContext is like LinkDomain but carries a context.
+func (client *Client) LinkDomainContext(ctx context.Context, args LinkDomainArgs) error {
 	var (
 		path       = fmt.Sprintf("/projects/%s/domains/%s/link", args.Project, args.Domain)
 		payload, _ = json.Marshal(struct {
@@ -473,7 +823,7 @@ func (client *Client) LinkDomain(args LinkDomainArgs) error {
 			AllowInsecureTraffic: args.AllowInsecureTraffic,
 		})
 	)
-	if err := client.request(http.MethodPost, path, nil, bytes.NewReader(payload)); err != nil {
+	if err := client.idempotentRequest(ctx, http.MethodPost, path, nil, bytes.NewReader(payload)); err != nil {
 		return err
 	}
 	return nil
@@ -486,6 +836,11 @@ type UnlinkDomainArgs struct {
 }
 
 func (client *Client) UnlinkDomain(args UnlinkDomainArgs) error {
+	return client.UnlinkDomainContext(context.Background(), args)
+}
+
+// UnlinkDomainContext is like UnlinkDomain but carries a context.
+func (client *Client) UnlinkDomainContext(ctx context.Context, args UnlinkDomainArgs) error {
 	var (
 		path       = fmt.Sprintf("/projects/%s/domains/%s/link", args.Project, args.Domain)
 		payload, _ = json.Marshal(struct {
@@ -494,62 +849,92 @@ func (client *Client) UnlinkDomain(args UnlinkDomainArgs) error {
 			Service: args.Service,
 		})
 	)
-	if err := client.request(http.MethodDelete, path, nil, bytes.NewReader(payload)); err != nil {
+	if err := client.request(ctx, http.MethodDelete, path, nil, bytes.NewReader(payload)); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (client *Client) VerifyDomain(project, domain string) (*Domain, error) {
+	return client.VerifyDomainContext(context.Background(), project, domain)
+}
+
+// VerifyDomainContext is like VerifyDomain but carries a context.
+func (client *Client) VerifyDomainContext(ctx context.Context, project, domain string) (*Domain, error) {
 	var (
 		path = fmt.Sprintf("/projects/%s/domains/%s/verify", project, domain)
 		dom  Domain
 	)
-	if err := client.request(http.MethodPost, path, &dom, nil); err != nil {
+	if err := client.idempotentRequest(ctx, http.MethodPost, path, &dom, nil); err != nil {
 		return nil, err
 	}
 	return &dom, nil
 }
 
 func (client *Client) ListSchedules(project, service string) ([]Schedule, error) {
+	return client.ListSchedulesContext(context.Background(), project, service)
+}
+
+// ListSchedulesContext is like ListSchedules but carries a context.
+func (client *Client) ListSchedulesContext(ctx context.Context, project, service string) ([]Schedule, error) {
 	var (
 		path      = fmt.Sprintf("/projects/%s/services/%s/schedules", project, service)
 		schedules []Schedule
 	)
-	if err := client.request(http.MethodGet, path, &schedules, nil); err != nil {
+	if err := client.request(ctx, http.MethodGet, path, &schedules, nil); err != nil {
 		return nil, err
 	}
 	return schedules, nil
 }
 
 func (client *Client) SetSchedule(project, service string, sched Schedule) error {
+	return client.SetScheduleContext(context.Background(), project, service, sched)
+}
+
+// SetScheduleContext is like SetSchedule but carries a context.
+func (client *Client) SetScheduleContext(ctx context.Context, project, service string, sched Schedule) error {
 	var (
 		path       = fmt.Sprintf("/projects/%s/services/%s/schedules", project, service)
 		payload, _ = json.Marshal(sched)
 	)
-	return client.request(http.MethodPost, path, nil, bytes.NewReader(payload))
+	return client.idempotentRequest(ctx, http.MethodPost, path, nil, bytes.NewReader(payload))
 }
 
 func (client *Client) DeleteSchedule(project, service, schedule string) error {
+	return client.DeleteScheduleContext(context.Background(), project, service, schedule)
+}
+
+// DeleteScheduleContext is like DeleteSchedule but carries a context.
+func (client *Client) DeleteScheduleContext(ctx context.Context, project, service, schedule string) error {
 	var (
 		path = fmt.Sprintf("/projects/%s/services/%s/schedules/%s", project, service, schedule)
 	)
-	return client.request(http.MethodDelete, path, nil, nil)
+	return client.request(ctx, http.MethodDelete, path, nil, nil)
 }
 
 func (client *Client) TriggerSchedule(project, service, schedule string) error {
+	return client.TriggerScheduleContext(context.Background(), project, service, schedule)
+}
+
+// TriggerScheduleContext is like TriggerSchedule but carries a context.
+func (client *Client) TriggerScheduleContext(ctx context.Context, project, service, schedule string) error {
 	var (
 		path = fmt.Sprintf("/projects/%s/services/%s/schedules/%s/trigger", project, service, schedule)
 	)
-	return client.request(http.MethodPost, path, nil, nil)
+	return client.request(ctx, http.MethodPost, path, nil, nil)
 }
 
 func (client *Client) InspectSchedule(project, service, schedule string) (*ScheduleDetails, error) {
+	return client.InspectScheduleContext(context.Background(), project, service, schedule)
+}
+
+// InspectScheduleContext is like InspectSchedule but carries a context.
+func (client *Client) InspectScheduleContext(ctx context.Context, project, service, schedule string) (*ScheduleDetails, error) {
 	var (
 		path    = fmt.Sprintf("/projects/%s/services/%s/schedules/%s", project, service, schedule)
 		details ScheduleDetails
 	)
-	if err := client.request(http.MethodGet, path, &details, nil); err != nil {
+	if err := client.request(ctx, http.MethodGet, path, &details, nil); err != nil {
 		return nil, err
 	}
 	return &details, nil
@@ -696,16 +1081,30 @@ type Deployment struct {
 }
 
 // NewClient creates a new client instance.
-func NewClient(endpoint, token string) (*Client, error) {
+func NewClient(endpoint, token string, opts ...ClientOption) (*Client, error) {
+	return NewClientContext(context.Background(), endpoint, token, opts...)
+}
+
+// NewClientContext is like NewClient but carries a context for the initial
+// endpoint check.
+func NewClientContext(ctx context.Context, endpoint, token string, opts ...ClientOption) (*Client, error) {
+	client := newClient(endpoint, token, opts...)
+	if err := client.check(ctx); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func newClient(endpoint, token string, opts ...ClientOption) *Client {
 	client := &Client{
 		Endpoint: endpoint,
 		Token:    token,
-		http: &http.Client{
-			Timeout: time.Minute,
-		},
+		timeouts: DefaultTimeouts,
+		retry:    DefaultRetryPolicy,
 	}
-	if err := client.check(); err != nil {
-		return nil, err
+	for _, opt := range opts {
+		opt(client)
 	}
-	return client, nil
+	client.http = newHTTPClient(client.timeouts)
+	return client
 }
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lineWriter collects the lines of a stream and signals the first one.
+type lineWriter struct {
+	mu      sync.Mutex
+	data    []byte
+	started chan struct{}
+	once    sync.Once
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	w.data = append(w.data, p...)
+	w.mu.Unlock()
+	w.once.Do(func() { close(w.started) })
+	return len(p), nil
+}
+
+func TestConcurrentStreamAndRequests(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /projects/acme/services/web/logs", func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; ; i++ {
+			fmt.Fprintf(w, "line %d\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	})
+	mux.HandleFunc("GET /projects/acme/services/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/projects/acme/services/slow" {
+			<-r.Context().Done()
+			return
+		}
+		fmt.Fprint(w, `[{"name":"web"}]`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	client := newClient(srv.URL, "token", WithTimeouts(Timeouts{Request: 5 * time.Second, Read: 5 * time.Second}))
+
+	streamCtx, cancelStream := context.WithCancel(context.Background())
+	defer cancelStream()
+	logs := &lineWriter{started: make(chan struct{})}
+	streamErr := make(chan error, 1)
+	go func() {
+		streamErr <- client.StreamServiceLogsContext(streamCtx, "acme", "web", logs, true, false, 0)
+	}()
+	<-logs.started
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			services, err := client.ListServicesContext(context.Background(), "acme", "")
+			if err != nil || len(services) != 1 {
+				t.Errorf("ListServices during a stream = %v, %v", services, err)
+			}
+		}()
+	}
+	// Cancelling a regular call leaves the stream and other calls alone.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		if _, err := client.ListServicesContext(ctx, "acme", "slow"); !errors.Is(err, context.Canceled) {
+			t.Errorf("cancelled ListServices = %v, want %v", err, context.Canceled)
+		}
+	}()
+	wg.Wait()
+
+	select {
+	case err := <-streamErr:
+		t.Fatalf("stream ended before it was cancelled: %v", err)
+	default:
+	}
+	if _, err := client.ListServicesContext(context.Background(), "acme", ""); err != nil {
+		t.Errorf("ListServices after cancelling a call = %v", err)
+	}
+	cancelStream()
+	select {
+	case err := <-streamErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("cancelled stream = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not end after it was cancelled")
+	}
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+	if len(logs.data) == 0 {
+		t.Error("stream received no data")
+	}
+}
+
+// slowReader yields a chunk of data every interval until it has yielded n.
+type slowReader struct {
+	n, chunk int
+	interval time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.interval)
+	n := min(len(p), r.chunk, r.n)
+	r.n -= n
+	return n, nil
+}
+
+// zeros is an endless source of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestUploadArtifactTimeouts(t *testing.T) {
+	stalled := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/projects/stalled/") {
+			<-stalled
+			return
+		}
+		n, err := io.Copy(io.Discard, r.Body)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, `{"artifact":"a-%d"}`, n)
+	}))
+	defer srv.Close()
+	defer close(stalled)
+	client := newClient(srv.URL, "token", WithTimeouts(Timeouts{Request: 100 * time.Millisecond}))
+
+	// Uploads that keep making progress outlast the request timeout.
+	start := time.Now()
+	archive := &slowReader{n: 16 << 10, chunk: 1 << 10, interval: 25 * time.Millisecond}
+	artifact, err := client.UploadArtifactContext(context.Background(), "acme", "web", &ArtifactUpload{Archive: archive, Size: -1})
+	if err != nil {
+		t.Fatalf("slow upload = %v", err)
+	}
+	if artifact.Artifact != "a-16384" {
+		t.Errorf("slow upload created artifact %s, want a-16384", artifact.Artifact)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("slow upload took %v, which does not exceed the request timeout", elapsed)
+	}
+
+	// Uploads making no progress, here as the server does not read them,
+	// time out.
+	_, err = client.UploadArtifactContext(context.Background(), "stalled", "web", &ArtifactUpload{Archive: zeros{}, Size: -1})
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "no progress for 100ms") {
+		t.Errorf("stalled upload = %v, want no progress for 100ms", err)
+	}
+}
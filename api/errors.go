@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Sentinel errors an Error can be matched against using errors.Is.
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrUnauthorized      = errors.New("unauthorized")
+	ErrForbidden         = errors.New("forbidden")
+	ErrConflict          = errors.New("conflict")
+	ErrRateLimited       = errors.New("rate limited")
+	ErrServerUnavailable = errors.New("server unavailable")
+	// ErrLengthRequired is returned for uploads of unknown length to
+	// endpoints that do not accept chunked requests.
+	ErrLengthRequired = errors.New("length required")
+	// ErrUnsupportedEncoding is returned for uploads compressed with an
+	// encoding the endpoint does not accept.
+	ErrUnsupportedEncoding = errors.New("unsupported encoding")
+)
+
+type JSONError struct {
+	Err string `json:"error"`
+}
+
+func (err JSONError) Error() string {
+	return err.Err
+}
+
+// ErrorBody is the structured description of a failed call as returned by the API.
+type ErrorBody struct {
+	Code      string   `json:"code,omitempty"`
+	Message   string   `json:"message,omitempty"`
+	Details   []string `json:"details,omitempty"`
+	RequestID string   `json:"requestId,omitempty"`
+}
+
+// Error is returned for calls the API responded to with a status other than 200.
+type Error struct {
+	StatusCode  int
+	ServerError error
+	Body        ErrorBody
+}
+
+func (err Error) Error() string {
+	return fmt.Sprintf("%s: %v", http.StatusText(err.StatusCode), err.ServerError)
+}
+
+func (err Error) Unwrap() error {
+	return err.ServerError
+}
+
+// Is reports whether the error belongs to the class of the given sentinel error.
+func (err Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return err.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return err.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return err.StatusCode == http.StatusForbidden
+	case ErrConflict:
+		return err.StatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return err.StatusCode == http.StatusTooManyRequests
+	case ErrLengthRequired:
+		return err.StatusCode == http.StatusLengthRequired
+	case ErrUnsupportedEncoding:
+		return err.StatusCode == http.StatusUnsupportedMediaType
+	case ErrServerUnavailable:
+		switch err.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+	return false
+}
+
+func newError(resp *http.Response, body []byte) Error {
+	parsed, err := parseErrorResponse(body)
+	if parsed.RequestID == "" {
+		parsed.RequestID = resp.Header.Get("X-Request-Id")
+	}
+	if err == nil {
+		err = JSONError{Err: parsed.Message}
+	}
+	return Error{
+		StatusCode:  resp.StatusCode,
+		ServerError: err,
+		Body:        parsed,
+	}
+}
+
+func parseErrorResponse(body []byte) (ErrorBody, error) {
+	var serverErr struct {
+		ErrorBody
+		Err string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &serverErr); err != nil {
+		return ErrorBody{}, fmt.Errorf("unmarshalling error response '%s': %w", string(body), err)
+	}
+	parsed := serverErr.ErrorBody
+	if parsed.Message == "" {
+		parsed.Message = serverErr.Err
+	}
+	return parsed, nil
+}
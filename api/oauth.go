@@ -0,0 +1,267 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OAuthClientID identifies the CLI to the authorization server of an endpoint.
+const OAuthClientID = "valar-cli"
+
+// deviceCodeGrantType is the grant type of the device authorization flow.
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// OAuth obtains tokens from the authorization server of an endpoint, which is
+// served below /oauth.
+type OAuth struct {
+	Endpoint string
+	ClientID string
+
+	http *http.Client
+}
+
+// NewOAuth returns an OAuth client for the given endpoint.
+func NewOAuth(endpoint string) *OAuth {
+	return &OAuth{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		ClientID: OAuthClientID,
+		http:     newHTTPClient(DefaultTimeouts),
+	}
+}
+
+// OAuthToken is a token issued by the authorization server.
+type OAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// ExpiresIn is the lifetime of the access token in seconds, if it expires.
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+}
+
+// Expiry returns when the access token expires, or the zero time if it does not.
+func (token *OAuthToken) Expiry(issued time.Time) time.Time {
+	if token.ExpiresIn <= 0 {
+		return time.Time{}
+	}
+	return issued.Add(time.Duration(token.ExpiresIn) * time.Second)
+}
+
+// OAuthError is returned if the authorization server rejects a request.
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (err OAuthError) Error() string {
+	if err.Description != "" {
+		return fmt.Sprintf("oauth: %s: %s", err.Code, err.Description)
+	}
+	return "oauth: " + err.Code
+}
+
+// PKCE is a proof key binding an authorization code to the client that
+// requested it.
+type PKCE struct {
+	Verifier  string
+	Challenge string
+}
+
+// NewPKCE generates a random proof key using the S256 method.
+func NewPKCE() (PKCE, error) {
+	verifier, err := randomString(32)
+	if err != nil {
+		return PKCE{}, err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return PKCE{Verifier: verifier, Challenge: base64.RawURLEncoding.EncodeToString(sum[:])}, nil
+}
+
+// NewOAuthState generates a random value to match a callback to its request.
+func NewOAuthState() (string, error) {
+	return randomString(16)
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate random string: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthorizeURL returns the URL the user approves the login on. Once approved,
+// the authorization server redirects to redirectURI with the code and state.
+func (o *OAuth) AuthorizeURL(redirectURI, state string, pkce PKCE) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", o.ClientID)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("state", state)
+	params.Set("code_challenge", pkce.Challenge)
+	params.Set("code_challenge_method", "S256")
+	return o.Endpoint + "/oauth/authorize?" + params.Encode()
+}
+
+// ExchangeCode exchanges an authorization code for a token.
+func (o *OAuth) ExchangeCode(code, redirectURI string, pkce PKCE) (*OAuthToken, error) {
+	return o.ExchangeCodeContext(context.Background(), code, redirectURI, pkce)
+}
+
+// ExchangeCodeContext is like ExchangeCode but carries a context.
+func (o *OAuth) ExchangeCodeContext(ctx context.Context, code, redirectURI string, pkce PKCE) (*OAuthToken, error) {
+	var token OAuthToken
+	if err := o.post(ctx, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {pkce.Verifier},
+	}, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Refresh obtains a new token using a refresh token.
+func (o *OAuth) Refresh(refreshToken string) (*OAuthToken, error) {
+	return o.RefreshContext(context.Background(), refreshToken)
+}
+
+// RefreshContext is like Refresh but carries a context.
+func (o *OAuth) RefreshContext(ctx context.Context, refreshToken string) (*OAuthToken, error) {
+	var token OAuthToken
+	if err := o.post(ctx, "/oauth/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	}, &token); err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+	return &token, nil
+}
+
+// DeviceAuthorization describes a pending login on another device.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	// ExpiresIn and Interval are given in seconds.
+	ExpiresIn int64 `json:"expires_in"`
+	Interval  int64 `json:"interval,omitempty"`
+}
+
+// AuthorizeDevice starts a login to be approved on another device, for
+// machines without a browser.
+func (o *OAuth) AuthorizeDevice() (*DeviceAuthorization, error) {
+	return o.AuthorizeDeviceContext(context.Background())
+}
+
+// AuthorizeDeviceContext is like AuthorizeDevice but carries a context.
+func (o *OAuth) AuthorizeDeviceContext(ctx context.Context) (*DeviceAuthorization, error) {
+	var auth DeviceAuthorization
+	if err := o.post(ctx, "/oauth/device/code", url.Values{}, &auth); err != nil {
+		return nil, err
+	}
+	return &auth, nil
+}
+
+// PollDeviceToken waits until the device login has been approved and
+// returns the token.
+func (o *OAuth) PollDeviceToken(auth *DeviceAuthorization) (*OAuthToken, error) {
+	return o.PollDeviceTokenContext(context.Background(), auth)
+}
+
+// PollDeviceTokenContext is like PollDeviceToken but carries a context.
+func (o *OAuth) PollDeviceTokenContext(ctx context.Context, auth *DeviceAuthorization) (*OAuthToken, error) {
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if auth.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(auth.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+	for {
+		var token OAuthToken
+		err := o.post(ctx, "/oauth/token", url.Values{
+			"grant_type":  {deviceCodeGrantType},
+			"device_code": {auth.DeviceCode},
+		}, &token)
+		if err == nil {
+			return &token, nil
+		}
+		var oauthErr OAuthError
+		ok := errors.As(err, &oauthErr)
+		switch {
+		case ok && oauthErr.Code == "authorization_pending":
+		case ok && oauthErr.Code == "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, err
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("waiting for device approval: %w", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// Revoke invalidates an access or refresh token.
+func (o *OAuth) Revoke(token string) error {
+	return o.RevokeContext(context.Background(), token)
+}
+
+// RevokeContext is like Revoke but carries a context.
+func (o *OAuth) RevokeContext(ctx context.Context, token string) error {
+	return o.post(ctx, "/oauth/revoke", url.Values{"token": {token}}, nil)
+}
+
+// post submits a form to the authorization server and decodes the response.
+func (o *OAuth) post(ctx context.Context, path string, form url.Values, obj interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeouts.Request)
+	defer cancel()
+	form.Set("client_id", o.ClientID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.Endpoint+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("oauth request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := o.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("submitting oauth request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("fetching oauth response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var oauthErr OAuthError
+		if err := json.Unmarshal(body, &oauthErr); err == nil && oauthErr.Code != "" {
+			return oauthErr
+		}
+		return newError(resp, body)
+	}
+	if obj != nil {
+		if err := json.Unmarshal(body, obj); err != nil {
+			return fmt.Errorf("unmarshalling oauth response: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestOAuthTokenExpiry(t *testing.T) {
+	issued := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	if got := (&OAuthToken{ExpiresIn: 3600}).Expiry(issued); !got.Equal(issued.Add(time.Hour)) {
+		t.Errorf("Expiry of a token lasting an hour = %v", got)
+	}
+	if got := (&OAuthToken{}).Expiry(issued); !got.IsZero() {
+		t.Errorf("Expiry of a token without lifetime = %v, want none", got)
+	}
+}
+
+func TestNewPKCE(t *testing.T) {
+	first, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Verifier == second.Verifier {
+		t.Error("NewPKCE returned the same verifier twice")
+	}
+	sum := sha256.Sum256([]byte(first.Verifier))
+	if want := base64.RawURLEncoding.EncodeToString(sum[:]); first.Challenge != want {
+		t.Errorf("challenge = %s, want %s", first.Challenge, want)
+	}
+	if n := len(first.Verifier); n < 43 || n > 128 {
+		t.Errorf("verifier has %d characters, want between 43 and 128", n)
+	}
+}
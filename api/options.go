@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// Timeouts bounds the individual phases of an API call. A zero duration
+// disables the respective timeout.
+type Timeouts struct {
+	// Request bounds a regular call from dialing until the response has been
+	// read, including all retries. It does not apply to streamed responses
+	// or uploads.
+	Request time.Duration
+	// Connect bounds dialing and the TLS handshake of a new connection.
+	Connect time.Duration
+	// Idle is how long an unused keep-alive connection is kept open.
+	Idle time.Duration
+	// Read bounds the silence between two reads of a streamed response. For
+	// uploads, it bounds the time between two reads of the uploaded body and
+	// between the last one and the response, falling back to Request if
+	// unset.
+	Read time.Duration
+}
+
+// DefaultTimeouts are the timeouts used if no others are given.
+var DefaultTimeouts = Timeouts{
+	Request: time.Minute,
+	Connect: 10 * time.Second,
+	Idle:    90 * time.Second,
+}
+
+// ClientOption configures a client on creation.
+type ClientOption func(*Client)
+
+// WithTimeouts replaces the default timeouts of the client.
+func WithTimeouts(timeouts Timeouts) ClientOption {
+	return func(client *Client) {
+		client.timeouts = timeouts
+	}
+}
+
+func newHTTPClient(timeouts Timeouts) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   timeouts.Connect,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = timeouts.Connect
+	transport.IdleConnTimeout = timeouts.Idle
+	// Deadlines are set per request, the client itself must never time out
+	// as it is shared between regular calls and streams.
+	return &http.Client{Transport: transport}
+}
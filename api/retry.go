@@ -0,0 +1,108 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy describes how failed calls are repeated. Only calls that are
+// safe to repeat are retried, that is reads and idempotent writes. They are
+// retried on network errors, rate limiting and server errors.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	// Values below two disable retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. It doubles with
+	// every further attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts, including delays
+	// asked for by the server with Retry-After.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy used if no other is given.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+}
+
+// WithRetryPolicy replaces the default retry policy of the client.
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(client *Client) {
+		client.retry = policy
+	}
+}
+
+// WithDebugLog makes the client report retries and other diagnostics to w.
+func WithDebugLog(w io.Writer) ClientOption {
+	return func(client *Client) {
+		client.debug = w
+	}
+}
+
+// backoff returns the jittered delay after the given failed attempt.
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.InitialBackoff
+	for i := 1; i < attempt && (policy.MaxBackoff <= 0 || delay < policy.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// Wait at least half of the delay and spread the rest to avoid
+	// retrying in lockstep with other clients.
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func retryableStatus(code int) bool {
+	switch {
+	case code == http.StatusTooManyRequests:
+		return true
+	case code == http.StatusNotImplemented, code == http.StatusHTTPVersionNotSupported:
+		return false
+	default:
+		return code >= 500
+	}
+}
+
+// retryAfter parses the Retry-After header, which is given either in seconds
+// or as an HTTP date relative to now. It returns fallback if the header is
+// missing or invalid.
+func retryAfter(header http.Header, fallback time.Duration, now time.Time) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return fallback
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, date.Sub(now))
+	}
+	return fallback
+}
+
+// rewind resets a request body for another attempt.
+func rewind(body io.Reader) error {
+	seeker, ok := body.(io.Seeker)
+	if !ok {
+		return nil
+	}
+	_, err := seeker.Seek(0, io.SeekStart)
+	return err
+}
+
+func (client *Client) debugf(format string, args ...interface{}) {
+	if client.debug == nil {
+		return
+	}
+	fmt.Fprintf(client.debug, "debug: "+format+"\n", args...)
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	for _, tt := range []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 150 * time.Millisecond, 300 * time.Millisecond},
+		{10, 150 * time.Millisecond, 300 * time.Millisecond},
+	} {
+		for i := 0; i < 100; i++ {
+			if got := policy.backoff(tt.attempt); got < tt.min || got > tt.max {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+	if got := (RetryPolicy{}).backoff(3); got != 0 {
+		t.Errorf("backoff without delay = %v, want 0", got)
+	}
+}
+
+func TestRetryableStatus(t *testing.T) {
+	for code, want := range map[int]bool{
+		http.StatusTooManyRequests:         true,
+		http.StatusInternalServerError:     true,
+		http.StatusBadGateway:              true,
+		http.StatusServiceUnavailable:      true,
+		http.StatusGatewayTimeout:          true,
+		http.StatusNotImplemented:          false,
+		http.StatusHTTPVersionNotSupported: false,
+		http.StatusBadRequest:              false,
+		http.StatusUnauthorized:            false,
+		http.StatusNotFound:                false,
+		http.StatusConflict:                false,
+	} {
+		if got := retryableStatus(code); got != want {
+			t.Errorf("retryableStatus(%d) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+	fallback := 3 * time.Second
+	for _, tt := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", fallback},
+		{"0", 0},
+		{"120", 2 * time.Minute},
+		{"86400", 24 * time.Hour},
+		{"-5", fallback},
+		{"1.5", fallback},
+		{"soon", fallback},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Hour).Format(http.TimeFormat), 0},
+		{"Sat Oct 17 12:00:30 2026", 30 * time.Second},
+		{"Saturday, 17-Oct-26 12:00:30 GMT", 30 * time.Second},
+		{"Sat, 17 Oct 2026", fallback},
+	} {
+		header := http.Header{}
+		if tt.value != "" {
+			header.Set("Retry-After", tt.value)
+		}
+		if got := retryAfter(header, fallback, now); got != tt.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+// unavailableServer fails every request with Retry-After set to a day,
+// except for the ones after the first failures.
+func unavailableServer(t *testing.T, failures int32) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			w.Header().Set("Retry-After", "86400")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":"maintenance"}`))
+			return
+		}
+		w.Write([]byte(`{"version":"v2"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestRetryAfterIsCapped(t *testing.T) {
+	srv, calls := unavailableServer(t, 1)
+	client := newClient(srv.URL, "token", WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: 20 * time.Millisecond}))
+	start := time.Now()
+	if err := client.check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("retry took %v despite a maximum backoff of 20ms", elapsed)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("server was called %d times, want 2", got)
+	}
+}
+
+func TestRetryBeyondDeadline(t *testing.T) {
+	srv, calls := unavailableServer(t, 1)
+	client := newClient(srv.URL, "token", WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Hour}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	err := client.check(ctx)
+	if !errors.Is(err, ErrServerUnavailable) {
+		t.Errorf("check = %v, want %v", err, ErrServerUnavailable)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("gave up after %v instead of right away", elapsed)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("server was called %d times, want 1", got)
+	}
+}
@@ -12,9 +12,22 @@ var (
 
 // VerifyNames checks that both project and service names are valid identifiers.
 func VerifyNames(project, service string) error {
+	if err := VerifyProjectName(project); err != nil {
+		return err
+	}
+	return VerifyServiceName(service)
+}
+
+// VerifyProjectName checks that the project name is a valid identifier.
+func VerifyProjectName(project string) error {
 	if !projectExp.Match([]byte(project)) {
 		return fmt.Errorf("invalid project name: may only contains alphanumerics and dashes")
 	}
+	return nil
+}
+
+// VerifyServiceName checks that the service name is a valid identifier.
+func VerifyServiceName(service string) error {
 	if !serviceExp.Match([]byte(service)) {
 		return fmt.Errorf("invalid service name: may only contain alphanumerics")
 	}
@@ -0,0 +1,531 @@
+package apitest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valar/cli/api"
+)
+
+func (s *Server) routes() {
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "no such endpoint: %s %s", r.Method, r.URL.Path)
+	})
+	s.mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"version": "v2"})
+	})
+	s.mux.HandleFunc("GET /users/info", s.handleUserInfo)
+	s.oauthRoutes()
+
+	s.mux.HandleFunc("GET /projects/{project}/services/{prefix...}", s.withProject(s.handleListServices))
+	s.mux.HandleFunc("GET /projects/{project}/services/{service}/logs", s.withService(s.handleServiceLogs))
+	s.mux.HandleFunc("POST /projects/{project}/services/{service}/status", s.withService(s.handleServiceStatus))
+	s.mux.HandleFunc("POST /projects/{project}/services/{service}/artifacts", s.handleSubmitArtifact)
+	s.mux.HandleFunc("OPTIONS /projects/{project}/services/{service}/artifacts", s.handleArtifactOptions)
+
+	s.mux.HandleFunc("POST /projects/{project}/services/{service}/builds", s.withService(s.handleSubmitBuild))
+	s.mux.HandleFunc("GET /projects/{project}/services/{service}/builds/{prefix...}", s.withService(s.handleListBuilds))
+	s.mux.HandleFunc("GET /projects/{project}/services/{service}/builds/{id}/inspect", s.withBuild(s.handleInspectBuild))
+	s.mux.HandleFunc("GET /projects/{project}/services/{service}/builds/{id}/logs", s.withBuild(s.handleBuildLogs))
+	s.mux.HandleFunc("POST /projects/{project}/services/{service}/builds/{id}/abort", s.withBuild(s.handleAbortBuild))
+
+	s.mux.HandleFunc("GET /projects/{project}/services/{service}/deploys", s.withService(s.handleListDeployments))
+	s.mux.HandleFunc("POST /projects/{project}/services/{service}/deploys", s.withService(s.handleSubmitDeploy))
+	s.mux.HandleFunc("POST /projects/{project}/services/{service}/deploys/rollback", s.withService(s.handleRollbackDeploy))
+
+	s.mux.HandleFunc("GET /projects/{project}/services/{service}/schedules", s.withService(s.handleListSchedules))
+	s.mux.HandleFunc("POST /projects/{project}/services/{service}/schedules", s.withService(s.handleSetSchedule))
+	s.mux.HandleFunc("GET /projects/{project}/services/{service}/schedules/{schedule}", s.withSchedule(s.handleInspectSchedule))
+	s.mux.HandleFunc("DELETE /projects/{project}/services/{service}/schedules/{schedule}", s.withSchedule(s.handleDeleteSchedule))
+	s.mux.HandleFunc("POST /projects/{project}/services/{service}/schedules/{schedule}/trigger", s.withSchedule(s.handleTriggerSchedule))
+
+	s.mux.HandleFunc("GET /projects/{project}/permissions", s.withProject(s.handleListPermissions))
+	s.mux.HandleFunc("POST /projects/{project}/permissions", s.withProject(s.handleModifyPermission))
+	s.mux.HandleFunc("POST /projects/{project}/environment/encrypt", s.withProject(s.handleEncryptEnvironment))
+
+	s.mux.HandleFunc("GET /projects/{project}/domains", s.withProject(s.handleListDomains))
+	s.mux.HandleFunc("POST /projects/{project}/domains", s.withProject(s.handleAddDomain))
+	s.mux.HandleFunc("DELETE /projects/{project}/domains/{domain}", s.withDomain(s.handleDeleteDomain))
+	s.mux.HandleFunc("POST /projects/{project}/domains/{domain}/link", s.withDomain(s.handleLinkDomain))
+	s.mux.HandleFunc("DELETE /projects/{project}/domains/{domain}/link", s.withDomain(s.handleUnlinkDomain))
+	s.mux.HandleFunc("POST /projects/{project}/domains/{domain}/verify", s.withDomain(s.handleVerifyDomain))
+}
+
+// The with* wrappers resolve the resources named in the path and hold s.mu
+// while the handler runs, unless noted otherwise.
+
+func (s *Server) withProject(h func(http.ResponseWriter, *http.Request, *project)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		prj, ok := s.projects[r.PathValue("project")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "project %s not found", r.PathValue("project"))
+			return
+		}
+		h(w, r, prj)
+	}
+}
+
+func (s *Server) withService(h func(http.ResponseWriter, *http.Request, *service)) http.HandlerFunc {
+	return s.withProject(func(w http.ResponseWriter, r *http.Request, prj *project) {
+		svc, ok := prj.services[r.PathValue("service")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "service %s not found", r.PathValue("service"))
+			return
+		}
+		h(w, r, svc)
+	})
+}
+
+func (s *Server) withBuild(h func(http.ResponseWriter, *http.Request, *service, *build)) http.HandlerFunc {
+	return s.withService(func(w http.ResponseWriter, r *http.Request, svc *service) {
+		b := svc.build(r.PathValue("id"))
+		if b == nil {
+			writeError(w, http.StatusNotFound, "build %s not found", r.PathValue("id"))
+			return
+		}
+		h(w, r, svc, b)
+	})
+}
+
+func (s *Server) withSchedule(h func(http.ResponseWriter, *http.Request, *service, *schedule)) http.HandlerFunc {
+	return s.withService(func(w http.ResponseWriter, r *http.Request, svc *service) {
+		sched, ok := svc.schedules[r.PathValue("schedule")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "schedule %s not found", r.PathValue("schedule"))
+			return
+		}
+		h(w, r, svc, sched)
+	})
+}
+
+func (s *Server) withDomain(h func(http.ResponseWriter, *http.Request, *project, *api.Domain)) http.HandlerFunc {
+	return s.withProject(func(w http.ResponseWriter, r *http.Request, prj *project) {
+		d, ok := prj.domains[r.PathValue("domain")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "domain %s not found", r.PathValue("domain"))
+			return
+		}
+		h(w, r, prj, d)
+	})
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "decoding request: %v", err)
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := api.UserInfo{Name: s.user, Projects: []string{}}
+	for name := range s.projects {
+		info.Projects = append(info.Projects, name)
+	}
+	sort.Strings(info.Projects)
+	writeJSON(w, info)
+}
+
+func (s *Server) handleListServices(w http.ResponseWriter, r *http.Request, prj *project) {
+	services := []api.Service{}
+	for name, svc := range prj.services {
+		if strings.HasPrefix(name, r.PathValue("prefix")) {
+			services = append(services, svc.info)
+		}
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	writeJSON(w, services)
+}
+
+// handleServiceLogs streams the service logs. It does not hold s.mu while
+// waiting for further lines.
+func (s *Server) handleServiceLogs(w http.ResponseWriter, r *http.Request, svc *service) {
+	query := r.URL.Query()
+	skip, _ := strconv.Atoi(query.Get("skip"))
+	offset := skip
+	if query.Get("seek") == "end" {
+		offset = max(0, len(svc.logs)-skip)
+	}
+	follow := query.Get("follow") == "true"
+	s.stream(w, r, func() bool {
+		for ; offset < len(svc.logs); offset++ {
+			io.WriteString(w, svc.logs[offset]+"\n")
+		}
+		return !follow
+	})
+}
+
+func (s *Server) handleServiceStatus(w http.ResponseWriter, r *http.Request, svc *service) {
+	svc.disabled = r.URL.Query().Get("disabled") == "true"
+	writeJSON(w, struct{}{})
+}
+
+// handleArtifactOptions advertises the encodings accepted for artifacts.
+func (s *Server) handleArtifactOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept-Encoding", strings.Join(s.ArtifactEncodings, ", "))
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleSubmitArtifact receives the upload before acquiring s.mu.
+func (s *Server) handleSubmitArtifact(w http.ResponseWriter, r *http.Request) {
+	if s.RequireLength && r.ContentLength < 0 {
+		writeError(w, http.StatusLengthRequired, "artifacts have to be uploaded with a Content-Length")
+		return
+	}
+	if encoding := r.Header.Get("Content-Encoding"); encoding != "" && !slices.Contains(s.ArtifactEncodings, encoding) {
+		w.Header().Set("Accept-Encoding", strings.Join(s.ArtifactEncodings, ", "))
+		writeError(w, http.StatusUnsupportedMediaType, "unsupported content encoding %s", encoding)
+		return
+	}
+	if s.AnswerEarly {
+		rc := http.NewResponseController(w)
+		rc.EnableFullDuplex()
+		s.withProject(func(w http.ResponseWriter, r *http.Request, prj *project) {
+			writeJSON(w, api.Artifact{Artifact: s.newID()})
+		})(w, r)
+		rc.Flush()
+		io.Copy(io.Discard, r.Body)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "reading artifact: %v", err)
+		return
+	}
+	s.withProject(func(w http.ResponseWriter, r *http.Request, prj *project) {
+		// Pushing an artifact creates the service if it does not exist yet.
+		svc := s.service(r.PathValue("project"), r.PathValue("service"))
+		id := s.newID()
+		svc.artifacts[id] = data
+		writeJSON(w, api.Artifact{Artifact: id})
+	})(w, r)
+}
+
+// handleSubmitBuild completes builds instantly and deploys them unless
+// asked not to.
+func (s *Server) handleSubmitBuild(w http.ResponseWriter, r *http.Request, svc *service) {
+	var req api.BuildRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if _, ok := svc.artifacts[req.Artifact]; !ok {
+		writeError(w, http.StatusNotFound, "artifact %s not found", req.Artifact)
+		return
+	}
+	now := s.Now()
+	b := &build{
+		Build: api.Build{
+			ID:          s.newID(),
+			Constructor: req.Build.Constructor,
+			Status:      "done",
+			CreatedAt:   now,
+			Owner:       s.user,
+		},
+		logs: []api.LogEntry{
+			{Timestamp: now, Source: api.LogEntrySourceWrapper, Stage: api.LogEntryStageSetup, Content: "Preparing build environment"},
+			{Timestamp: now, Source: api.LogEntrySourceProcess, Content: "Building with constructor " + req.Build.Constructor},
+			{Timestamp: now, Source: api.LogEntrySourceWrapper, Stage: api.LogEntryStageTurndown, Content: "Build completed"},
+		},
+		request: req,
+	}
+	svc.builds = append(svc.builds, b)
+	if !req.Deployment.Skip {
+		s.deploy(svc, b.ID)
+	}
+	s.notify()
+	writeJSON(w, b.Build)
+}
+
+func (s *Server) handleListBuilds(w http.ResponseWriter, r *http.Request, svc *service) {
+	builds := []api.Build{}
+	for _, b := range svc.builds {
+		if strings.HasPrefix(b.ID, r.PathValue("prefix")) {
+			builds = append(builds, b.Build)
+		}
+	}
+	writeJSON(w, builds)
+}
+
+func (s *Server) handleInspectBuild(w http.ResponseWriter, r *http.Request, svc *service, b *build) {
+	writeJSON(w, b.Build)
+}
+
+func (s *Server) handleAbortBuild(w http.ResponseWriter, r *http.Request, svc *service, b *build) {
+	if b.Status == "scheduled" || b.Status == "building" {
+		b.Status = "failed"
+		b.Err = "aborted"
+		s.notify()
+	}
+	writeJSON(w, struct{}{})
+}
+
+// handleBuildLogs streams JSON encoded log entries. Followed streams end once
+// the build has finished.
+func (s *Server) handleBuildLogs(w http.ResponseWriter, r *http.Request, svc *service, b *build) {
+	follow := r.URL.Query().Get("follow") == "true"
+	offset := 0
+	encoder := json.NewEncoder(w)
+	s.stream(w, r, func() bool {
+		for ; offset < len(b.logs); offset++ {
+			encoder.Encode(b.logs[offset])
+		}
+		return !follow || (b.Status != "scheduled" && b.Status != "building")
+	})
+}
+
+// stream calls flush with s.mu held until it reports to be done, waiting for
+// changes in between. It has to be called with s.mu held.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, flush func() bool) {
+	w.WriteHeader(http.StatusOK)
+	for {
+		done := flush()
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		if done {
+			return
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+			s.mu.Lock()
+		case <-r.Context().Done():
+			s.mu.Lock()
+			return
+		}
+	}
+}
+
+func (s *Server) handleListDeployments(w http.ResponseWriter, r *http.Request, svc *service) {
+	writeJSON(w, append([]api.Deployment{}, svc.deployments...))
+}
+
+func (s *Server) handleSubmitDeploy(w http.ResponseWriter, r *http.Request, svc *service) {
+	var req api.DeployRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	b := svc.build(req.Build)
+	if b == nil || b.ID != req.Build {
+		writeError(w, http.StatusNotFound, "build %s not found", req.Build)
+		return
+	}
+	writeJSON(w, s.deploy(svc, b.ID))
+}
+
+func (s *Server) handleRollbackDeploy(w http.ResponseWriter, r *http.Request, svc *service) {
+	var req api.RollbackRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	for _, d := range svc.deployments {
+		if d.Version == req.Version {
+			writeJSON(w, s.deploy(svc, d.Build))
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "deployment %d not found", req.Version)
+}
+
+// deploy rolls out a build as a new version of the service. The caller must hold s.mu.
+func (s *Server) deploy(svc *service, buildID string) api.Deployment {
+	d := api.Deployment{
+		Version:   svc.info.Deployment + 1,
+		CreatedAt: s.Now(),
+		Status:    "done",
+		Build:     buildID,
+	}
+	svc.deployments = append(svc.deployments, d)
+	svc.info.Deployment = d.Version
+	svc.info.DeployedAt = d.CreatedAt
+	return d
+}
+
+func (s *Server) handleListSchedules(w http.ResponseWriter, r *http.Request, svc *service) {
+	writeJSON(w, s.schedules(r.PathValue("project"), r.PathValue("service")))
+}
+
+func (s *Server) handleSetSchedule(w http.ResponseWriter, r *http.Request, svc *service) {
+	var sched api.Schedule
+	if !decodeBody(w, r, &sched) {
+		return
+	}
+	if existing, ok := svc.schedules[sched.Name]; ok {
+		existing.Schedule = sched
+	} else {
+		svc.schedules[sched.Name] = &schedule{Schedule: sched}
+	}
+	writeJSON(w, struct{}{})
+}
+
+func (s *Server) handleInspectSchedule(w http.ResponseWriter, r *http.Request, svc *service, sched *schedule) {
+	schedCopy := sched.Schedule
+	writeJSON(w, api.ScheduleDetails{LastRun: sched.lastRun, Schedule: &schedCopy})
+}
+
+func (s *Server) handleDeleteSchedule(w http.ResponseWriter, r *http.Request, svc *service, sched *schedule) {
+	delete(svc.schedules, sched.Name)
+	writeJSON(w, struct{}{})
+}
+
+func (s *Server) handleTriggerSchedule(w http.ResponseWriter, r *http.Request, svc *service, sched *schedule) {
+	now := s.Now()
+	sched.lastRun = &api.ServiceInvocation{
+		ID:            s.newID(),
+		StartTime:     now,
+		EndTime:       now,
+		Status:        "succeeded",
+		TriggerSource: s.user,
+	}
+	writeJSON(w, struct{}{})
+}
+
+func (s *Server) handleListPermissions(w http.ResponseWriter, r *http.Request, prj *project) {
+	var (
+		namespace = r.URL.Query().Get("namespace")
+		prefix    = r.URL.Query().Get("prefix")
+		set       = []api.Permission{}
+	)
+	for _, p := range prj.permissions {
+		if p.Path.Namespace == namespace && strings.HasPrefix(strings.Join(p.Path.Items, "/"), prefix) {
+			set = append(set, p)
+		}
+	}
+	writeJSON(w, set)
+}
+
+// handleModifyPermission modifies or checks permissions. A permission is only
+// granted if it has explicitly been allowed.
+func (s *Server) handleModifyPermission(w http.ResponseWriter, r *http.Request, prj *project) {
+	var req api.Permission
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	index := -1
+	for i, p := range prj.permissions {
+		if p.Path.String() == req.Path.String() && p.User.String() == req.User.String() && p.Action == req.Action {
+			index = i
+			break
+		}
+	}
+	if r.URL.Query().Get("mode") == "check" {
+		writeJSON(w, map[string]bool{"allowed": index >= 0 && prj.permissions[index].State == "allow"})
+		return
+	}
+	modified := false
+	switch {
+	case req.State == "unset" && index >= 0:
+		prj.permissions = append(prj.permissions[:index], prj.permissions[index+1:]...)
+		modified = true
+	case req.State == "unset":
+	case index < 0:
+		prj.permissions = append(prj.permissions, req)
+		modified = true
+	case prj.permissions[index].State != req.State:
+		prj.permissions[index].State = req.State
+		modified = true
+	}
+	writeJSON(w, map[string]bool{"modified": modified})
+}
+
+// handleEncryptEnvironment "encrypts" values by encoding them, so tests can
+// tell them apart from plain values.
+func (s *Server) handleEncryptEnvironment(w http.ResponseWriter, r *http.Request, prj *project) {
+	var kv api.KVPair
+	if !decodeBody(w, r, &kv) {
+		return
+	}
+	kv.Value = "encrypted:" + base64.StdEncoding.EncodeToString([]byte(kv.Value))
+	kv.Secret = true
+	writeJSON(w, kv)
+}
+
+func (s *Server) handleListDomains(w http.ResponseWriter, r *http.Request, prj *project) {
+	writeJSON(w, s.domains(r.PathValue("project")))
+}
+
+func (s *Server) handleAddDomain(w http.ResponseWriter, r *http.Request, prj *project) {
+	var req struct {
+		Domain string `json:"domain"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if _, ok := prj.domains[req.Domain]; ok {
+		writeError(w, http.StatusConflict, "domain %s already exists", req.Domain)
+		return
+	}
+	d := &api.Domain{
+		Project: r.PathValue("project"),
+		Domain:  req.Domain,
+		Token:   s.newID(),
+	}
+	prj.domains[req.Domain] = d
+	writeJSON(w, map[string]string{
+		"A":     "192.0.2.1",
+		"AAAA":  "2001:db8::1",
+		"CNAME": "edge.valar.test",
+		"TXT":   "valar-verification=" + d.Token,
+	})
+}
+
+func (s *Server) handleDeleteDomain(w http.ResponseWriter, r *http.Request, prj *project, d *api.Domain) {
+	delete(prj.domains, d.Domain)
+	writeJSON(w, struct{}{})
+}
+
+func (s *Server) handleLinkDomain(w http.ResponseWriter, r *http.Request, prj *project, d *api.Domain) {
+	var req struct {
+		Service string `json:"service"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if !d.Verified {
+		writeError(w, http.StatusConflict, "domain %s has not been verified", d.Domain)
+		return
+	}
+	svc, ok := prj.services[req.Service]
+	if !ok {
+		writeError(w, http.StatusNotFound, "service %s not found", req.Service)
+		return
+	}
+	d.Service = &req.Service
+	svc.info.Domains = append(svc.info.Domains, d.Domain)
+	writeJSON(w, struct{}{})
+}
+
+func (s *Server) handleUnlinkDomain(w http.ResponseWriter, r *http.Request, prj *project, d *api.Domain) {
+	if d.Service != nil {
+		if svc, ok := prj.services[*d.Service]; ok {
+			domains := svc.info.Domains[:0]
+			for _, name := range svc.info.Domains {
+				if name != d.Domain {
+					domains = append(domains, name)
+				}
+			}
+			svc.info.Domains = domains
+		}
+	}
+	d.Service = nil
+	writeJSON(w, struct{}{})
+}
+
+func (s *Server) handleVerifyDomain(w http.ResponseWriter, r *http.Request, prj *project, d *api.Domain) {
+	d.Verified = true
+	d.Expiration = s.Now().Add(90 * 24 * time.Hour)
+	writeJSON(w, d)
+}
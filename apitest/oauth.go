@@ -0,0 +1,128 @@
+package apitest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// oauthState holds the grants of the fake authorization server, which
+// approves every login right away. The caller must hold s.mu.
+type oauthState struct {
+	// codes maps authorization codes to the code challenge and redirect URI.
+	codes         map[string][2]string
+	deviceCodes   map[string]bool
+	accessTokens  map[string]bool
+	refreshTokens map[string]bool
+}
+
+func newOAuthState() oauthState {
+	return oauthState{
+		codes:         map[string][2]string{},
+		deviceCodes:   map[string]bool{},
+		accessTokens:  map[string]bool{},
+		refreshTokens: map[string]bool{},
+	}
+}
+
+func (s *Server) oauthRoutes() {
+	s.mux.HandleFunc("GET /oauth/authorize", s.handleOAuthAuthorize)
+	s.mux.HandleFunc("POST /oauth/token", s.handleOAuthToken)
+	s.mux.HandleFunc("POST /oauth/device/code", s.handleOAuthDeviceCode)
+	s.mux.HandleFunc("POST /oauth/revoke", s.handleOAuthRevoke)
+}
+
+// TokenValid reports whether an access or refresh token issued by logging in
+// is valid, i.e. has neither been refreshed nor revoked.
+func (s *Server) TokenValid(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.oauth.accessTokens[token] || s.oauth.refreshTokens[token]
+}
+
+func writeOAuthError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func (s *Server) handleOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("code_challenge_method") != "S256" {
+		writeOAuthError(w, "invalid_request")
+		return
+	}
+	s.mu.Lock()
+	code := s.newID()
+	s.oauth.codes[code] = [2]string{query.Get("code_challenge"), redirect.String()}
+	s.mu.Unlock()
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) handleOAuthToken(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		grant, ok := s.oauth.codes[r.PostFormValue("code")]
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || grant[0] != base64.RawURLEncoding.EncodeToString(sum[:]) || grant[1] != r.PostFormValue("redirect_uri") {
+			writeOAuthError(w, "invalid_grant")
+			return
+		}
+		delete(s.oauth.codes, r.PostFormValue("code"))
+	case "refresh_token":
+		if !s.oauth.refreshTokens[r.PostFormValue("refresh_token")] {
+			writeOAuthError(w, "invalid_grant")
+			return
+		}
+		delete(s.oauth.refreshTokens, r.PostFormValue("refresh_token"))
+	case "urn:ietf:params:oauth:grant-type:device_code":
+		if !s.oauth.deviceCodes[r.PostFormValue("device_code")] {
+			writeOAuthError(w, "expired_token")
+			return
+		}
+		delete(s.oauth.deviceCodes, r.PostFormValue("device_code"))
+	default:
+		writeOAuthError(w, "unsupported_grant_type")
+		return
+	}
+	accessToken, refreshToken := s.newID(), s.newID()
+	s.oauth.accessTokens[accessToken] = true
+	s.oauth.refreshTokens[refreshToken] = true
+	writeJSON(w, map[string]interface{}{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int64(s.TokenLifetime.Seconds()),
+	})
+}
+
+func (s *Server) handleOAuthDeviceCode(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deviceCode := s.newID()
+	s.oauth.deviceCodes[deviceCode] = true
+	writeJSON(w, map[string]interface{}{
+		"device_code":      deviceCode,
+		"user_code":        "WDJB-MJHT",
+		"verification_uri": "http://" + r.Host + "/oauth/device",
+		"expires_in":       600,
+		"interval":         1,
+	})
+}
+
+func (s *Server) handleOAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.oauth.accessTokens, r.PostFormValue("token"))
+	delete(s.oauth.refreshTokens, r.PostFormValue("token"))
+	writeJSON(w, struct{}{})
+}
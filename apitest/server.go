@@ -0,0 +1,342 @@
+// Package apitest provides an in-memory fake of the Valar v2 API, to exercise
+// the API client and the CLI in tests and during offline development.
+package apitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valar/cli/api"
+)
+
+// Server is a fake Valar API endpoint backed by in-memory state. It is safe
+// for concurrent use.
+type Server struct {
+	// Token is the bearer token requests have to carry. If empty, any token is accepted.
+	Token string
+	// Now returns the current time of the fake. It defaults to time.Now.
+	Now func() time.Time
+	// TokenLifetime is the lifetime of tokens issued by logging in.
+	TokenLifetime time.Duration
+	// ArtifactEncodings are the content encodings accepted for artifacts.
+	// It defaults to gzip.
+	ArtifactEncodings []string
+	// RequireLength rejects artifacts uploaded in chunks, like endpoints
+	// behind proxies requiring a Content-Length do.
+	RequireLength bool
+	// AnswerEarly answers artifact uploads before reading them, discarding
+	// what arrives afterwards.
+	AnswerEarly bool
+
+	mu       sync.Mutex
+	user     string
+	projects map[string]*project
+	rand     *rand.Rand
+	changed  chan struct{}
+	mux      *http.ServeMux
+	oauth    oauthState
+}
+
+type project struct {
+	services    map[string]*service
+	domains     map[string]*api.Domain
+	permissions []api.Permission
+}
+
+type service struct {
+	info        api.Service
+	disabled    bool
+	logs        []string
+	artifacts   map[string][]byte
+	builds      []*build
+	deployments []api.Deployment
+	schedules   map[string]*schedule
+}
+
+type build struct {
+	api.Build
+	logs []api.LogEntry
+	// request is the request the build has been submitted with.
+	request api.BuildRequest
+}
+
+type schedule struct {
+	api.Schedule
+	lastRun *api.ServiceInvocation
+}
+
+// NewServer creates a fake endpoint for the given user. The user is a member
+// of every project added to the server.
+func NewServer(user string) *Server {
+	s := &Server{
+		Now:               time.Now,
+		TokenLifetime:     time.Hour,
+		ArtifactEncodings: []string{"gzip"},
+		user:              user,
+		projects:          map[string]*project{},
+		rand:              rand.New(rand.NewPCG(1, 2)),
+		changed:           make(chan struct{}),
+		oauth:             newOAuthState(),
+	}
+	s.routes()
+	return s
+}
+
+// Start serves the fake on a random local port. The caller has to close the
+// returned server.
+func (s *Server) Start() *httptest.Server {
+	return httptest.NewServer(s)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Date", s.Now().UTC().Format(http.TimeFormat))
+	// The authorization server is used to obtain a token in the first place.
+	if !strings.HasPrefix(r.URL.Path, "/oauth/") && !s.authorized(r.Header.Get("Authorization")) {
+		writeError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// authorized reports whether the header carries the configured token or a
+// token issued by logging in.
+func (s *Server) authorized(header string) bool {
+	if s.Token == "" || header == "Bearer "+s.Token {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.oauth.accessTokens[strings.TrimPrefix(header, "Bearer ")]
+}
+
+// AddProject creates an empty project.
+func (s *Server) AddProject(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.project(name)
+}
+
+// AddService creates a service. The project is created if necessary.
+func (s *Server) AddService(projectName string, svc api.Service) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.service(projectName, svc.Name).info = svc
+}
+
+// AddServiceLogs appends lines to the logs of the latest deployment of a service.
+func (s *Server) AddServiceLogs(projectName, serviceName string, lines ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	svc := s.service(projectName, serviceName)
+	svc.logs = append(svc.logs, lines...)
+	s.notify()
+}
+
+// AddBuild adds a build with the given log entries to a service.
+func (s *Server) AddBuild(projectName, serviceName string, b api.Build, logs ...api.LogEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	svc := s.service(projectName, serviceName)
+	svc.builds = append(svc.builds, &build{Build: b, logs: logs})
+	s.notify()
+}
+
+// AppendBuildLogs appends log entries to a build, e.g. to feed a followed log stream.
+func (s *Server) AppendBuildLogs(projectName, serviceName, id string, logs ...api.LogEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b := s.service(projectName, serviceName).build(id); b != nil {
+		b.logs = append(b.logs, logs...)
+		s.notify()
+	}
+}
+
+// SetBuildStatus changes the status of a build. Followed log streams end once
+// a build is neither scheduled nor building.
+func (s *Server) SetBuildStatus(projectName, serviceName, id, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b := s.service(projectName, serviceName).build(id); b != nil {
+		b.Status = status
+		s.notify()
+	}
+}
+
+// AddDeployment adds a deployment to a service.
+func (s *Server) AddDeployment(projectName, serviceName string, d api.Deployment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	svc := s.service(projectName, serviceName)
+	svc.deployments = append(svc.deployments, d)
+	if d.Version > svc.info.Deployment {
+		svc.info.Deployment = d.Version
+		svc.info.DeployedAt = d.CreatedAt
+	}
+}
+
+// AddDomain adds a domain to a project.
+func (s *Server) AddDomain(projectName string, d api.Domain) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d.Project = projectName
+	s.project(projectName).domains[d.Domain] = &d
+}
+
+// AddSchedule adds a schedule to a service. The last run may be nil.
+func (s *Server) AddSchedule(projectName, serviceName string, sched api.Schedule, lastRun *api.ServiceInvocation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.service(projectName, serviceName).schedules[sched.Name] = &schedule{Schedule: sched, lastRun: lastRun}
+}
+
+// AddPermission adds a permission to a project.
+func (s *Server) AddPermission(projectName string, p api.Permission) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prj := s.project(projectName)
+	prj.permissions = append(prj.permissions, p)
+}
+
+// Builds returns the builds of a service in order of creation.
+func (s *Server) Builds(projectName, serviceName string) []api.Build {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	builds := []api.Build{}
+	for _, b := range s.service(projectName, serviceName).builds {
+		builds = append(builds, b.Build)
+	}
+	return builds
+}
+
+// BuildRequest returns the request a build has been submitted with, or nil if
+// the build does not exist.
+func (s *Server) BuildRequest(projectName, serviceName, id string) *api.BuildRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.service(projectName, serviceName).builds {
+		if b.ID == id {
+			req := b.request
+			return &req
+		}
+	}
+	return nil
+}
+
+// Deployments returns the deployments of a service in order of creation.
+func (s *Server) Deployments(projectName, serviceName string) []api.Deployment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]api.Deployment{}, s.service(projectName, serviceName).deployments...)
+}
+
+// Artifact returns the content of an uploaded artifact, or nil if it does not exist.
+func (s *Server) Artifact(projectName, serviceName, id string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.service(projectName, serviceName).artifacts[id]
+}
+
+// Domains returns the domains of a project sorted by name.
+func (s *Server) Domains(projectName string) []api.Domain {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.domains(projectName)
+}
+
+// Schedules returns the schedules of a service sorted by name.
+func (s *Server) Schedules(projectName, serviceName string) []api.Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.schedules(projectName, serviceName)
+}
+
+// project returns the named project, creating it if necessary. The caller must hold s.mu.
+func (s *Server) project(name string) *project {
+	prj, ok := s.projects[name]
+	if !ok {
+		prj = &project{
+			services: map[string]*service{},
+			domains:  map[string]*api.Domain{},
+		}
+		s.projects[name] = prj
+	}
+	return prj
+}
+
+// service returns the named service, creating it if necessary. The caller must hold s.mu.
+func (s *Server) service(projectName, name string) *service {
+	prj := s.project(projectName)
+	svc, ok := prj.services[name]
+	if !ok {
+		svc = &service{
+			info: api.Service{
+				ID:        s.newID(),
+				Name:      name,
+				CreatedAt: s.Now(),
+				Domains:   []string{},
+			},
+			artifacts: map[string][]byte{},
+			schedules: map[string]*schedule{},
+		}
+		prj.services[name] = svc
+	}
+	return svc
+}
+
+// build returns the latest build with the given ID prefix.
+func (svc *service) build(prefix string) *build {
+	for i := len(svc.builds) - 1; i >= 0; i-- {
+		if strings.HasPrefix(svc.builds[i].ID, prefix) {
+			return svc.builds[i]
+		}
+	}
+	return nil
+}
+
+func (s *Server) domains(projectName string) []api.Domain {
+	domains := []api.Domain{}
+	for _, d := range s.project(projectName).domains {
+		domains = append(domains, *d)
+	}
+	sort.Slice(domains, func(i, j int) bool { return domains[i].Domain < domains[j].Domain })
+	return domains
+}
+
+func (s *Server) schedules(projectName, serviceName string) []api.Schedule {
+	schedules := []api.Schedule{}
+	for _, sched := range s.service(projectName, serviceName).schedules {
+		schedules = append(schedules, sched.Schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].Name < schedules[j].Name })
+	return schedules
+}
+
+// newID returns a pseudo-random identifier. The sequence of identifiers is the
+// same for every server. The caller must hold s.mu.
+func (s *Server) newID() string {
+	return fmt.Sprintf("%016x", s.rand.Uint64())
+}
+
+// notify wakes up all followed streams. The caller must hold s.mu.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(api.JSONError{Err: fmt.Sprintf(format, args...)})
+}
@@ -1,8 +1,17 @@
 package cmd
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"text/tabwriter"
@@ -19,57 +28,73 @@ import (
 	"golang.org/x/crypto/ssh/terminal"
 )
 
-var buildService string
-
-var buildCmd = &cobra.Command{
-	Use:     "build [--service service]",
-	Short:   "Manage the builds of a service.",
-	Aliases: []string{"builds", "b"},
+func newBuildCmd(c *cli) *cobra.Command {
+	var service string
+	buildCmd := &cobra.Command{
+		Use:     "build [--service service]",
+		Short:   "Manage the builds of a service.",
+		Aliases: []string{"builds", "b"},
+	}
+	buildCmd.PersistentFlags().StringVarP(&service, "service", "s", "", "The service to inspect for builds")
+	buildCmd.RegisterFlagCompletionFunc("service", c.completeServices())
+	buildCmd.AddCommand(
+		newBuildListCmd(c, &service),
+		newBuildInspectCmd(c, &service),
+		newBuildLogsCmd(c, &service),
+		newBuildAbortCmd(c, &service),
+		newBuildStatusCmd(c, &service),
+		newBuildWatchCmd(c, &service),
+		newBuildPushCmd(c, &service),
+	)
+	return buildCmd
 }
 
-var buildListCmd = &cobra.Command{
-	Use:   "list [prefix]",
-	Short: "List builds of the service.",
-	Args:  cobra.MaximumNArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &buildService, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		if len(args) < 1 {
-			args = append(args, "")
-		}
-		return listBuilds(client, cfg, args[0])
-	}),
+func newBuildListCmd(c *cli, service *string) *cobra.Command {
+	return &cobra.Command{
+		Use:               "list [prefix]",
+		Short:             "List builds of the service.",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: firstArg(c.completeBuilds(service)),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, service, c.config)
+			if err != nil {
+				return err
+			}
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if len(args) < 1 {
+				args = append(args, "")
+			}
+			return c.listBuilds(cmd.Context(), cmd.OutOrStdout(), client, cfg, args[0])
+		}),
+	}
 }
 
-var buildAbortCmd = &cobra.Command{
-	Use:   "abort [prefix]",
-	Short: "Abort a scheduled or running build.",
-	Args:  cobra.MaximumNArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &buildService, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		if len(args) < 1 {
-			args = append(args, "")
-		}
-		return client.AbortBuild(cfg.Project(), cfg.Service(), args[0])
-	}),
+func newBuildAbortCmd(c *cli, service *string) *cobra.Command {
+	return &cobra.Command{
+		Use:               "abort [prefix]",
+		Short:             "Abort a scheduled or running build.",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: firstArg(c.completeBuilds(service)),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, service, c.config)
+			if err != nil {
+				return err
+			}
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if len(args) < 1 {
+				args = append(args, "")
+			}
+			return client.AbortBuildContext(cmd.Context(), cfg.Project(), cfg.Service(), args[0])
+		}),
+	}
 }
 
-var logsFollow = false
-var logsRaw = false
-
 func formatLogEntry(logEntry *api.LogEntry, terminalWidth int) string {
 	line := &strings.Builder{}
 
@@ -100,6 +125,10 @@ func formatLogEntry(logEntry *api.LogEntry, terminalWidth int) string {
 
 	contentRunes := []rune(logEntry.Content)
 	runeBlockLen := max(1, terminalWidth-len(timestampPrefix)-len(contextPrefix))
+	if terminalWidth <= 0 {
+		// Unknown width, e.g. if the output is not a terminal, so do not wrap.
+		runeBlockLen = len(contentRunes) + 1
+	}
 	for i := 0; i <= len(contentRunes)/runeBlockLen; i++ {
 		if i != 0 {
 			line.WriteString(color.HiBlackString(string(timestampPrefix)))
@@ -112,158 +141,176 @@ func formatLogEntry(logEntry *api.LogEntry, terminalWidth int) string {
 	return line.String()
 }
 
-var buildLogsCmd = &cobra.Command{
-	Use:   "logs [buildid]",
-	Short: "Show the build logs of the given task.",
-	Args:  cobra.MaximumNArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &buildService, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		// Get latest matching build
-		prefix := ""
-		if len(args) > 0 {
-			prefix = args[0]
-		}
-		builds, err := client.ListBuilds(cfg.Project(), cfg.Service(), prefix)
-		if err != nil {
-			return err
-		}
-		if len(builds) == 0 {
-			return fmt.Errorf("no builds available")
-		}
-		// Sort builds by date
-		width, _, _ := terminal.GetSize(0)
-		sort.Slice(builds, func(i, j int) bool { return builds[i].CreatedAt.After(builds[j].CreatedAt) })
-		latestBuildID := builds[0].ID
-		consumer := func(le api.LogEntry) {
-			if logsRaw {
-				fmt.Println(le.Content)
-			} else {
-				fmt.Println(formatLogEntry(&le, width))
+func newBuildLogsCmd(c *cli, service *string) *cobra.Command {
+	var follow, raw bool
+	buildLogsCmd := &cobra.Command{
+		Use:               "logs [buildid]",
+		Short:             "Show the build logs of the given task.",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: firstArg(c.completeBuilds(service)),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, service, c.config)
+			if err != nil {
+				return err
 			}
-		}
-		if logsFollow {
-			return client.StreamBuildLogs(cfg.Project(), cfg.Service(), latestBuildID, consumer)
-		}
-		return client.ShowBuildLogs(cfg.Project(), cfg.Service(), latestBuildID, consumer)
-	}),
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			// Get latest matching build
+			prefix := ""
+			if len(args) > 0 {
+				prefix = args[0]
+			}
+			builds, err := client.ListBuildsContext(cmd.Context(), cfg.Project(), cfg.Service(), prefix)
+			if err != nil {
+				return err
+			}
+			if len(builds) == 0 {
+				return fmt.Errorf("no builds available")
+			}
+			// Sort builds by date
+			width := terminalWidth(cmd.OutOrStdout())
+			sort.Slice(builds, func(i, j int) bool { return builds[i].CreatedAt.After(builds[j].CreatedAt) })
+			latestBuildID := builds[0].ID
+			consumer := func(le api.LogEntry) {
+				if raw {
+					fmt.Fprintln(cmd.OutOrStdout(), le.Content)
+				} else {
+					fmt.Fprintln(cmd.OutOrStdout(), formatLogEntry(&le, width))
+				}
+			}
+			if follow {
+				return client.StreamBuildLogsContext(cmd.Context(), cfg.Project(), cfg.Service(), latestBuildID, consumer)
+			}
+			return client.ShowBuildLogsContext(cmd.Context(), cfg.Project(), cfg.Service(), latestBuildID, consumer)
+		}),
+	}
+	buildLogsCmd.PersistentFlags().BoolVarP(&follow, "follow", "f", false, "Follow the logs")
+	buildLogsCmd.PersistentFlags().BoolVarP(&raw, "raw", "r", false, "Dump the unformatted log content")
+	return buildLogsCmd
 }
 
-var buildWatchCmd = &cobra.Command{
-	Use:   "watch [prefix]",
-	Short: "Watch a build until its completion.",
-	Args:  cobra.MaximumNArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &buildService, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		// Get latest matching build
-		prefix := ""
-		if len(args) > 0 {
-			prefix = args[0]
-		}
-		builds, err := client.ListBuilds(cfg.Project(), cfg.Service(), prefix)
-		if err != nil {
-			return err
-		}
-		if len(builds) == 0 {
-			return fmt.Errorf("no builds available")
-		}
-		// Sort builds by date
-		sort.Slice(builds, func(i, j int) bool { return builds[i].CreatedAt.After(builds[j].CreatedAt) })
-		latestBuildID := builds[0].ID
+func newBuildWatchCmd(c *cli, service *string) *cobra.Command {
+	return &cobra.Command{
+		Use:               "watch [prefix]",
+		Short:             "Watch a build until its completion.",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: firstArg(c.completeBuilds(service)),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, service, c.config)
+			if err != nil {
+				return err
+			}
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			// Get latest matching build
+			prefix := ""
+			if len(args) > 0 {
+				prefix = args[0]
+			}
+			builds, err := client.ListBuildsContext(cmd.Context(), cfg.Project(), cfg.Service(), prefix)
+			if err != nil {
+				return err
+			}
+			if len(builds) == 0 {
+				return fmt.Errorf("no builds available")
+			}
+			// Sort builds by date
+			sort.Slice(builds, func(i, j int) bool { return builds[i].CreatedAt.After(builds[j].CreatedAt) })
+			latestBuildID := builds[0].ID
 
-		terminalWidth, terminalHeight, _ := terminal.GetSize(0)
-		bar := progressbar.NewOptions(-1, progressbar.OptionEnableColorCodes(true), progressbar.OptionSpinnerType(3), progressbar.OptionSetElapsedTime(false), progressbar.OptionSetMaxDetailRow(terminalHeight-2), progressbar.OptionFullWidth())
-		build, err := client.InspectBuild(cfg.Project(), cfg.Service(), latestBuildID)
-		if err != nil {
-			return err
-		}
+			width, height, _ := terminal.GetSize(0)
+			bar := progressbar.NewOptions(-1, progressbar.OptionSetWriter(cmd.OutOrStdout()), progressbar.OptionEnableColorCodes(true), progressbar.OptionSpinnerType(3), progressbar.OptionSetElapsedTime(false), progressbar.OptionSetMaxDetailRow(height-2), progressbar.OptionFullWidth())
+			build, err := client.InspectBuildContext(cmd.Context(), cfg.Project(), cfg.Service(), latestBuildID)
+			if err != nil {
+				return err
+			}
 
-		switch build.Status {
-		case "scheduled":
-			bar.Describe("Scheduling build onto worker ...")
-		}
+			switch build.Status {
+			case "scheduled":
+				bar.Describe("Scheduling build onto worker ...")
+			}
 
-		client.StreamBuildLogs(cfg.Project(), cfg.Service(), latestBuildID, func(le api.LogEntry) {
-			switch le.Stage {
-			case api.LogEntryStageUnspecified:
-				bar.Describe("Processing ...")
-			case api.LogEntryStageSetup:
-				bar.Describe("Setting up build environment ...")
-			case api.LogEntryStageTurndown:
-				bar.Describe("Turning down build environment ...")
-			}
-			bar.AddDetail(formatLogEntry(&le, terminalWidth))
-			fmt.Printf("\n\033[1A\033[K")
-			bar.RenderBlank()
-		})
-
-		build, err = client.InspectBuild(cfg.Project(), cfg.Service(), latestBuildID)
-		if err != nil {
-			return err
-		}
-		switch build.Status {
-		case "done":
-			bar.Describe(color.GreenString("Build has succeeded."))
-		case "failed":
-			bar.Describe(color.RedString("Build has failed."))
-		}
-		bar.Finish()
-		fmt.Println()
+			err = client.StreamBuildLogsContext(cmd.Context(), cfg.Project(), cfg.Service(), latestBuildID, func(le api.LogEntry) {
+				switch le.Stage {
+				case api.LogEntryStageUnspecified:
+					bar.Describe("Processing ...")
+				case api.LogEntryStageSetup:
+					bar.Describe("Setting up build environment ...")
+				case api.LogEntryStageTurndown:
+					bar.Describe("Turning down build environment ...")
+				}
+				bar.AddDetail(formatLogEntry(&le, width))
+				fmt.Fprintf(cmd.OutOrStdout(), "\n\033[1A\033[K")
+				bar.RenderBlank()
+			})
+			if err != nil && cmd.Context().Err() != nil {
+				return err
+			}
+
+			build, err = client.InspectBuildContext(cmd.Context(), cfg.Project(), cfg.Service(), latestBuildID)
+			if err != nil {
+				return err
+			}
+			switch build.Status {
+			case "done":
+				bar.Describe(color.GreenString("Build has succeeded."))
+			case "failed":
+				bar.Describe(color.RedString("Build has failed."))
+			}
+			bar.Finish()
+			fmt.Fprintln(cmd.OutOrStdout())
 
-		return nil
-	}),
+			return nil
+		}),
+	}
 }
 
-var buildInspectCmd = &cobra.Command{
-	Use:   "inspect [prefix]",
-	Short: "Inspect the first matched task with the given ID prefix.",
-	Args:  cobra.ExactArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &buildService, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		return inspectBuild(client, cfg, args[0])
-	}),
+func newBuildInspectCmd(c *cli, service *string) *cobra.Command {
+	return &cobra.Command{
+		Use:               "inspect [prefix]",
+		Short:             "Inspect the first matched task with the given ID prefix.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(c.completeBuilds(service)),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, service, c.config)
+			if err != nil {
+				return err
+			}
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			return c.inspectBuild(cmd.Context(), cmd.OutOrStdout(), client, cfg, args[0])
+		}),
+	}
 }
 
-var buildStatusCmd = &cobra.Command{
-	Use:   "status [buildid]",
-	Short: "Show the status of the given build.",
-	Args:  cobra.ExactArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &buildService, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		showBuildStatusAndExit(client, cfg, args[0])
-		return nil
-	}),
+func newBuildStatusCmd(c *cli, service *string) *cobra.Command {
+	return &cobra.Command{
+		Use:               "status [buildid]",
+		Short:             "Show the status of the given build.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(c.completeBuilds(service)),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, service, c.config)
+			if err != nil {
+				return err
+			}
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			return c.showBuildStatusAndExit(cmd.Context(), cmd.OutOrStdout(), client, cfg, args[0])
+		}),
+	}
 }
 
-func listBuilds(client *api.Client, cfg config.ServiceConfig, id string) error {
-	builds, err := client.ListBuilds(cfg.Project(), cfg.Service(), id)
+func (c *cli) listBuilds(ctx context.Context, out io.Writer, client *api.Client, cfg config.ServiceConfig, id string) error {
+	builds, err := client.ListBuildsContext(ctx, cfg.Project(), cfg.Service(), id)
 	if err != nil {
 		return err
 	}
@@ -271,62 +318,85 @@ func listBuilds(client *api.Client, cfg config.ServiceConfig, id string) error {
 	sort.Slice(builds, func(i, j int) bool {
 		return builds[i].CreatedAt.Before(builds[j].CreatedAt)
 	})
-	tw := ansiterm.NewTabWriter(os.Stdout, 6, 0, 1, ' ', 0)
-	fmt.Fprintln(tw, "ID\tSTATUS\tCREATED")
-	for _, b := range builds {
-		fmt.Fprintln(tw, strings.Join([]string{
-			b.ID,
-			colorize(b.Status),
-			humanize.Time(b.CreatedAt),
-		}, "\t"))
-	}
-	tw.Flush()
-	return nil
+	return c.printResult(out, builds, func(w io.Writer, wide bool) {
+		tw := ansiterm.NewTabWriter(w, 6, 0, 1, ' ', 0)
+		if wide {
+			fmt.Fprintln(tw, "ID\tSTATUS\tCREATED\tCONSTRUCTOR\tOWNER\tERROR")
+		} else {
+			fmt.Fprintln(tw, "ID\tSTATUS\tCREATED")
+		}
+		for _, b := range builds {
+			row := []string{
+				b.ID,
+				colorize(b.Status),
+				c.humanizeTime(b.CreatedAt),
+			}
+			if wide {
+				row = append(row, b.Constructor, b.Owner, b.Err)
+			}
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		tw.Flush()
+	})
 }
 
-func showBuildStatusAndExit(client *api.Client, cfg config.ServiceConfig, id string) error {
-	build, err := client.InspectBuild(cfg.Project(), cfg.Service(), id)
+func (c *cli) showBuildStatusAndExit(ctx context.Context, out io.Writer, client *api.Client, cfg config.ServiceConfig, id string) error {
+	build, err := client.InspectBuildContext(ctx, cfg.Project(), cfg.Service(), id)
 	if err != nil {
 		return err
 	}
-	fmt.Fprintln(os.Stdout, colorize(build.Status))
-	os.Exit(statusToExitCode(build.Status))
+	fmt.Fprintln(out, colorize(build.Status))
+	c.Exit(statusToExitCode(build.Status))
 	return nil
 }
 
-func inspectBuild(client *api.Client, cfg config.ServiceConfig, id string) error {
-	build, err := client.InspectBuild(cfg.Project(), cfg.Service(), id)
+func (c *cli) inspectBuild(ctx context.Context, out io.Writer, client *api.Client, cfg config.ServiceConfig, id string) error {
+	build, err := client.InspectBuildContext(ctx, cfg.Project(), cfg.Service(), id)
 	if err != nil {
 		return err
 	}
-	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
-	fmt.Fprintln(tw, "ID:\t", build.ID)
-	fmt.Fprintln(tw, "Constructor:\t", build.Constructor)
-	fmt.Fprintln(tw, "CreatedAt:\t", build.CreatedAt)
-	fmt.Fprintln(tw, "Status:\t", colorize(build.Status))
-	fmt.Fprintln(tw, "Flags:\t", build.Flags)
-	fmt.Fprintln(tw, "Owner:\t", build.Owner)
-	if build.Err != "" {
-		fmt.Fprintln(tw, "Err:\t", build.Err)
-	}
-	tw.Flush()
-	return nil
+	return c.printResult(out, build, func(w io.Writer, wide bool) {
+		tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+		fmt.Fprintln(tw, "ID:\t", build.ID)
+		fmt.Fprintln(tw, "Constructor:\t", build.Constructor)
+		fmt.Fprintln(tw, "CreatedAt:\t", build.CreatedAt)
+		fmt.Fprintln(tw, "Status:\t", colorize(build.Status))
+		fmt.Fprintln(tw, "Flags:\t", build.Flags)
+		fmt.Fprintln(tw, "Owner:\t", build.Owner)
+		if build.Err != "" {
+			fmt.Fprintln(tw, "Err:\t", build.Err)
+		}
+		tw.Flush()
+	})
 }
 
-func deployBuild(client *api.Client, cfg config.ServiceConfig, id string) error {
+func deployBuild(ctx context.Context, out io.Writer, client *api.Client, cfg config.ServiceConfig, id string) error {
 	var deployReq api.DeployRequest
 	deployReq.Build = id
-	for _, kv := range cfg.Deployment().Environment {
-		deployReq.Environment = append(deployReq.Environment, api.KVPair(kv))
+	env, err := resolveEnvironment(ctx, client, cfg, cfg.Deployment().Environment)
+	if err != nil {
+		return err
 	}
-	deployment, err := client.SubmitDeploy(cfg.Project(), cfg.Service(), &deployReq)
+	deployReq.Environment = env
+	deployment, err := client.SubmitDeployContext(ctx, cfg.Project(), cfg.Service(), &deployReq)
 	if err != nil {
 		return err
 	}
-	fmt.Println(deployment.Version)
+	fmt.Fprintln(out, deployment.Version)
 	return nil
 }
 
+// terminalWidth returns the width of the terminal w writes to, or zero if
+// it does not write to a terminal.
+func terminalWidth(w io.Writer) int {
+	f, ok := w.(*os.File)
+	if !ok || !terminal.IsTerminal(int(f.Fd())) {
+		return 0
+	}
+	width, _, _ := terminal.GetSize(int(f.Fd()))
+	return width
+}
+
 func statusToExitCode(status string) int {
 	switch status {
 	case "done":
@@ -351,68 +421,442 @@ func colorize(status string) string {
 	}
 }
 
-var buildPushNoDeploy bool
+func newBuildPushCmd(c *cli, service *string) *cobra.Command {
+	var (
+		opts         pushOptions
+		all          bool
+		changedSince string
+	)
+	buildPushCmd := &cobra.Command{
+		Use:   "push [folder]",
+		Short: "Push and build a new version.",
+		Long: `Push and build a new version.
 
-var buildPushCmd = &cobra.Command{
-	Use:   "push folder",
-	Short: "Push and build a new version.",
-	Args:  cobra.MaximumNArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		serviceCfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, globalConfiguration)
-		if err != nil {
-			return err
+In a monorepo, the service is picked by --service or as the one whose path
+contains the working directory. Use --all to push every service listed in
+.valar.yml, or --changed-since to push the ones with files changed since the
+given git revision, including uncommitted and untracked ones.
+
+With --dry-run, the files that would be pushed are listed along with the
+largest files and directories instead, without contacting the endpoint.
+Pushes fail if the files exceed --max-size or the maxSize of the build.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			serviceCfg, err := config.NewServiceConfigWithFallback(functionConfiguration, service, c.config)
+			if err != nil {
+				return err
+			}
+			var client *api.Client
+			if !opts.dryRun {
+				if client, err = c.apiClient(cmd.Context()); err != nil {
+					return err
+				}
+			}
+			push := func(cfg config.ServiceConfig, folder string) (*api.Build, error) {
+				if opts.dryRun {
+					return nil, c.printManifest(cmd.OutOrStdout(), cfg, folder, opts)
+				}
+				return c.pushService(cmd.Context(), cmd.ErrOrStderr(), client, cfg, folder, opts)
+			}
+			if !all && changedSince == "" {
+				folder := serviceCfg.Dir()
+				if folder == "" {
+					if folder, err = os.Getwd(); err != nil {
+						return fmt.Errorf("locating working directory: %w", err)
+					}
+				}
+				if len(args) != 0 {
+					folder = args[0]
+				}
+				build, err := push(serviceCfg, folder)
+				if err != nil || opts.dryRun {
+					return err
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), build.ID)
+				return nil
+			}
+			if len(args) != 0 {
+				return fmt.Errorf("a folder cannot be pushed along with --all or --changed-since")
+			}
+			services := serviceCfg.Services()
+			if len(services) == 0 {
+				return fmt.Errorf("pushing several services requires a services list in %s", functionConfiguration)
+			}
+			if changedSince != "" {
+				services, err = changedServices(cmd.Context(), services, changedSince)
+				if err != nil {
+					return err
+				}
+				if len(services) == 0 {
+					fmt.Fprintf(cmd.ErrOrStderr(), "No service has changed since %s.\n", changedSince)
+					return nil
+				}
+			}
+			for _, svc := range services {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Pushing %s from %s.\n", svc.Service(), svc.Dir())
+				build, err := push(svc, svc.Dir())
+				if err != nil {
+					return fmt.Errorf("push %s: %w", svc.Service(), err)
+				}
+				if opts.dryRun {
+					continue
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s %s\n", svc.Service(), build.ID)
+			}
+			return nil
+		}),
+	}
+	buildPushCmd.Flags().BoolVar(&opts.noDeploy, "no-deploy", false, "Only build, skip deploy action")
+	buildPushCmd.Flags().StringVar(&opts.compression, "compression", compressionAuto, "Compress the source with gzip, zstd or auto, which uses zstd if the endpoint accepts it")
+	buildPushCmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "List the files that would be pushed without pushing them")
+	buildPushCmd.Flags().IntVar(&opts.top, "top", 10, "The number of largest files and directories listed by --dry-run")
+	buildPushCmd.Flags().StringVar(&opts.maxSize, "max-size", "", "Fail if the pushed files exceed the size, e.g. 500MB, overriding the maxSize of the build")
+	buildPushCmd.Flags().BoolVar(&all, "all", false, "Push every service of the monorepo")
+	buildPushCmd.Flags().StringVar(&changedSince, "changed-since", "", "Push the services of the monorepo with files changed since the git revision")
+	buildPushCmd.MarkFlagsMutuallyExclusive("all", "changed-since")
+	return buildPushCmd
+}
+
+// Values of build push --compression.
+const (
+	compressionAuto = "auto"
+	compressionGzip = util.EncodingGzip
+	compressionZstd = util.EncodingZstd
+)
+
+// pushOptions are the flags of build push applying to every pushed service.
+type pushOptions struct {
+	noDeploy    bool
+	compression string
+	dryRun      bool
+	top         int
+	maxSize     string
+}
+
+// limit returns the size the pushed files of the service must not exceed,
+// or zero if they may be of any size.
+func (opts pushOptions) limit(cfg config.ServiceConfig) (int64, error) {
+	size := opts.maxSize
+	if size == "" {
+		size = cfg.Build().MaxSize
+	}
+	if size == "" {
+		return 0, nil
+	}
+	return config.ParseSize(size)
+}
+
+// listSource lists the files of the folder to push.
+func listSource(cfg config.ServiceConfig, folder string) ([]util.SourceFile, error) {
+	ignore, err := util.NewIgnorer(folder, cfg.Build().Ignore, cfg.Build().Gitignore)
+	if err != nil {
+		return nil, err
+	}
+	files, err := util.ListSource(folder, ignore)
+	if err != nil {
+		return nil, fmt.Errorf("package compression failed: %w", err)
+	}
+	return files, nil
+}
+
+// checkSourceSize fails if the files exceed the maximum size of the service.
+func checkSourceSize(cfg config.ServiceConfig, files []util.SourceFile, opts pushOptions) error {
+	limit, err := opts.limit(cfg)
+	if err != nil {
+		return err
+	}
+	if size := sourceSize(files); limit > 0 && size > limit {
+		return fmt.Errorf("the files to push take %s, more than the maximum of %s", humanize.IBytes(uint64(size)), humanize.IBytes(uint64(limit)))
+	}
+	return nil
+}
+
+// sourceManifest lists the files a push would archive.
+type sourceManifest struct {
+	Files              []manifestEntry `json:"files"`
+	LargestFiles       []manifestEntry `json:"largestFiles"`
+	LargestDirectories []manifestEntry `json:"largestDirectories"`
+	TotalFiles         int             `json:"totalFiles"`
+	TotalSize          int64           `json:"totalSize"`
+}
+
+// manifestEntry is a file, or a directory with the number and total size
+// of the files in it.
+type manifestEntry struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	Files int    `json:"files,omitempty"`
+}
+
+// newSourceManifest lists the regular files along with the top largest files
+// and directories.
+func newSourceManifest(files []util.SourceFile, top int) *sourceManifest {
+	m := &sourceManifest{Files: []manifestEntry{}}
+	dirs := map[string]*manifestEntry{}
+	for _, file := range files {
+		if !file.Info.Mode().IsRegular() {
+			continue
+		}
+		size := file.Info.Size()
+		m.Files = append(m.Files, manifestEntry{Name: file.Name, Size: size})
+		m.TotalFiles++
+		m.TotalSize += size
+		for dir := path.Dir(file.Name); dir != "."; dir = path.Dir(dir) {
+			entry, ok := dirs[dir]
+			if !ok {
+				entry = &manifestEntry{Name: dir}
+				dirs[dir] = entry
+			}
+			entry.Size += size
+			entry.Files++
 		}
-		folder, err := os.Getwd()
-		if err != nil {
-			return fmt.Errorf("locating working directory: %w", err)
+	}
+	var dirEntries []manifestEntry
+	for _, entry := range dirs {
+		dirEntries = append(dirEntries, *entry)
+	}
+	m.LargestFiles = largest(m.Files, top)
+	m.LargestDirectories = largest(dirEntries, top)
+	return m
+}
+
+// largest returns the top entries by size, sorted by size and name.
+func largest(entries []manifestEntry, top int) []manifestEntry {
+	sorted := append([]manifestEntry{}, entries...)
+	slices.SortFunc(sorted, func(a, b manifestEntry) int {
+		return cmp.Or(cmp.Compare(b.Size, a.Size), strings.Compare(a.Name, b.Name))
+	})
+	return sorted[:min(max(top, 0), len(sorted))]
+}
+
+// printManifest lists the files of the folder a push would archive, failing
+// like the push if they exceed the maximum size.
+func (c *cli) printManifest(out io.Writer, cfg config.ServiceConfig, folder string, opts pushOptions) error {
+	files, err := listSource(cfg, folder)
+	if err != nil {
+		return err
+	}
+	m := newSourceManifest(files, opts.top)
+	err = c.printResult(out, m, func(w io.Writer, wide bool) {
+		tw := ansiterm.NewTabWriter(w, 6, 0, 1, ' ', 0)
+		fmt.Fprintln(tw, "SIZE\tFILE")
+		for _, file := range m.Files {
+			fmt.Fprintf(tw, "%s\t%s\n", humanize.IBytes(uint64(file.Size)), file.Name)
+		}
+		tw.Flush()
+		fmt.Fprintln(w)
+		fmt.Fprintln(tw, "SIZE\tLARGEST FILES")
+		for _, file := range m.LargestFiles {
+			fmt.Fprintf(tw, "%s\t%s\n", humanize.IBytes(uint64(file.Size)), file.Name)
+		}
+		if len(m.LargestDirectories) > 0 {
+			tw.Flush()
+			fmt.Fprintln(w)
+			fmt.Fprintln(tw, "SIZE\tFILES\tLARGEST DIRECTORIES")
+			for _, dir := range m.LargestDirectories {
+				fmt.Fprintf(tw, "%s\t%d\t%s/\n", humanize.IBytes(uint64(dir.Size)), dir.Files, dir.Name)
+			}
 		}
-		if len(args) != 0 {
-			folder = args[0]
+		tw.Flush()
+		fmt.Fprintf(w, "\n%d files, %s in total.\n", m.TotalFiles, humanize.IBytes(uint64(m.TotalSize)))
+	})
+	if err != nil {
+		return err
+	}
+	return checkSourceSize(cfg, files, opts)
+}
+
+// sourceSize returns the total size of the regular files.
+func sourceSize(files []util.SourceFile) int64 {
+	var size int64
+	for _, file := range files {
+		if file.Info.Mode().IsRegular() {
+			size += file.Info.Size()
 		}
-		// Upload archive artifact
-		archivePath, err := util.CompressDir(folder, serviceCfg.Build().Ignore)
+	}
+	return size
+}
+
+// pushService archives the folder, uploads it and submits a build of the
+// service. Details of the archive are written to log.
+func (c *cli) pushService(ctx context.Context, log io.Writer, client *api.Client, cfg config.ServiceConfig, folder string, opts pushOptions) (*api.Build, error) {
+	files, err := listSource(cfg, folder)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSourceSize(cfg, files, opts); err != nil {
+		return nil, fmt.Errorf("%w; list the largest files with --dry-run", err)
+	}
+	// Resolve the environment first, so that mistakes surface before uploading.
+	buildEnv, err := resolveEnvironment(ctx, client, cfg, cfg.Build().Environment)
+	if err != nil {
+		return nil, err
+	}
+	deployEnv, err := resolveEnvironment(ctx, client, cfg, cfg.Deployment().Environment)
+	if err != nil {
+		return nil, err
+	}
+	encoding, err := negotiateEncoding(ctx, log, client, cfg, opts.compression)
+	if err != nil {
+		return nil, err
+	}
+	artifact, summary, err := c.uploadArchive(ctx, log, client, cfg, files, encoding)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(log, "Archived %d files, %s compressed to %s, archive sha256:%s, source sha256:%s\n", summary.Files, humanize.IBytes(uint64(summary.Size)), humanize.IBytes(uint64(summary.ArchiveSize)), summary.SHA256, summary.SourceSHA256)
+	// Submit build request
+	var buildReq api.BuildRequest
+	buildReq.Artifact = artifact.Artifact
+	buildReq.Build.Constructor = cfg.Build().Constructor
+	buildReq.Build.Environment = buildEnv
+	buildReq.Deployment.Skip = opts.noDeploy
+	buildReq.Deployment.Environment = deployEnv
+	return client.SubmitBuildContext(ctx, cfg.Project(), cfg.Service(), &buildReq)
+}
+
+// negotiateEncoding picks the encoding to compress the archive with. Zstd is
+// only used if the endpoint advertises to accept it.
+func negotiateEncoding(ctx context.Context, log io.Writer, client *api.Client, cfg config.ServiceConfig, compression string) (string, error) {
+	switch compression {
+	case compressionGzip:
+		return util.EncodingGzip, nil
+	case compressionAuto, compressionZstd:
+	default:
+		return "", fmt.Errorf("unknown compression %q, expected %s, %s or %s", compression, compressionAuto, compressionGzip, compressionZstd)
+	}
+	encodings, err := client.ArtifactEncodingsContext(ctx, cfg.Project(), cfg.Service())
+	if err != nil {
+		return "", err
+	}
+	if slices.Contains(encodings, util.EncodingZstd) {
+		return util.EncodingZstd, nil
+	}
+	if compression == compressionZstd {
+		fmt.Fprintln(log, "The endpoint does not accept zstd, compressing with gzip instead.")
+	}
+	return util.EncodingGzip, nil
+}
+
+// uploadArchive streams the archive to the endpoint while it is compressed.
+// Endpoints requiring the length of uploads get the archive buffered in a
+// temporary file instead. The progress of the upload is reported to log.
+func (c *cli) uploadArchive(ctx context.Context, log io.Writer, client *api.Client, cfg config.ServiceConfig, files []util.SourceFile, encoding string) (*api.Artifact, *util.ArchiveSummary, error) {
+	sourceSize := sourceSize(files)
+	archive := util.NewArchiveReader(files, encoding)
+	progress := newUploadProgress(log, archive, -1, func() float64 {
+		if sourceSize == 0 {
+			return 0
+		}
+		return float64(archive.Archived()) / float64(sourceSize)
+	}, c.Now)
+	artifact, err := client.UploadArtifactContext(ctx, cfg.Project(), cfg.Service(), &api.ArtifactUpload{Archive: progress, Encoding: encoding, Size: -1})
+	progress.finish(err)
+	archive.Close()
+	summary, archiveErr := archive.Summary()
+	// Failures to archive surface as failures to upload.
+	if archiveErr != nil && !errors.Is(archiveErr, io.ErrClosedPipe) {
+		return nil, nil, fmt.Errorf("package compression failed: %w", archiveErr)
+	}
+	if !errors.Is(err, api.ErrLengthRequired) {
 		if err != nil {
-			return fmt.Errorf("package compression failed: %w", err)
+			return nil, nil, err
 		}
-		defer os.Remove(archivePath)
-		targzFile, err := os.Open(archivePath)
-		if err != nil {
-			return fmt.Errorf("package archive failed: %w", err)
+		if summary == nil {
+			return nil, nil, fmt.Errorf("package upload failed: the endpoint answered before receiving the whole archive")
 		}
-		defer targzFile.Close()
-		artifact, err := client.SubmitArtifact(serviceCfg.Project(), serviceCfg.Service(), targzFile)
+		return artifact, summary, nil
+	}
+
+	fmt.Fprintln(log, "The endpoint requires the length of the archive, buffering it in a temporary file.")
+	file, summary, err := util.CompressDir(files, encoding)
+	if err != nil {
+		return nil, nil, fmt.Errorf("package compression failed: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("package archive failed: %w", err)
+	}
+	progress = newUploadProgress(log, file, info.Size(), nil, c.Now)
+	artifact, err = client.UploadArtifactContext(ctx, cfg.Project(), cfg.Service(), &api.ArtifactUpload{Archive: progress, Encoding: encoding, Size: info.Size()})
+	progress.finish(err)
+	if err != nil {
+		return nil, nil, err
+	}
+	return artifact, summary, nil
+}
+
+// resolveEnvironment resolves the values of the variables to push or deploy.
+// Secret values obtained from outside of .valar.yml are encrypted on the fly,
+// so that they are neither sent nor stored in plain text.
+func resolveEnvironment(ctx context.Context, client *api.Client, cfg config.ServiceConfig, env []config.EnvironmentConfig) ([]api.KVPair, error) {
+	var kvs []api.KVPair
+	for _, e := range env {
+		value, external, err := e.Resolve(ctx)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("resolve variable %s: %w", e.Key, err)
 		}
-		// Submit build request
-		var buildReq api.BuildRequest
-		buildReq.Artifact = artifact.Artifact
-		buildReq.Build.Constructor = serviceCfg.Build().Constructor
-		for _, kv := range serviceCfg.Build().Environment {
-			buildReq.Build.Environment = append(buildReq.Build.Environment, api.KVPair(kv))
+		kv := &api.KVPair{Key: e.Key, Value: value, Secret: e.Secret}
+		if e.Secret && external {
+			if kv, err = client.EncryptEnvironmentContext(ctx, cfg.Project(), cfg.Service(), kv); err != nil {
+				return nil, fmt.Errorf("encrypt variable %s: %w", e.Key, err)
+			}
 		}
-		buildReq.Deployment.Skip = buildPushNoDeploy
-		for _, kv := range serviceCfg.Deployment().Environment {
-			buildReq.Deployment.Environment = append(buildReq.Deployment.Environment, api.KVPair(kv))
+		kvs = append(kvs, *kv)
+	}
+	return kvs, nil
+}
+
+// changedServices returns the services with files changed since the git
+// revision, including uncommitted and untracked ones.
+func changedServices(ctx context.Context, services []*config.ValidatedServiceConfig, revision string) ([]*config.ValidatedServiceConfig, error) {
+	file := services[0].Unwrap()
+	repo := filepath.Dir(file.FilePath())
+	top, err := runGit(ctx, repo, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	changed, err := runGit(ctx, repo, "diff", "--name-only", revision, "--")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := runGit(ctx, repo, "ls-files", "--others", "--exclude-standard", "--full-name")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, name := range strings.Split(changed+"\n"+untracked, "\n") {
+		if name != "" {
+			files = append(files, filepath.Join(top, filepath.FromSlash(name)))
 		}
-		build, err := client.SubmitBuild(serviceCfg.Project(), serviceCfg.Service(), &buildReq)
+	}
+	var result []*config.ValidatedServiceConfig
+	for _, svc := range services {
+		dir, err := filepath.EvalSymlinks(svc.Dir())
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("resolve source of %s: %w", svc.Service(), err)
+		}
+		for _, file := range files {
+			if rel, err := filepath.Rel(dir, file); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				result = append(result, svc)
+				break
+			}
 		}
-		fmt.Println(build.ID)
-		return nil
-	}),
-}
-
-func initBuildsCmd() {
-	buildCmd.PersistentFlags().StringVarP(&buildService, "service", "s", "", "The service to inspect for builds")
-	buildLogsCmd.PersistentFlags().BoolVarP(&logsFollow, "follow", "f", false, "Follow the logs")
-	buildLogsCmd.PersistentFlags().BoolVarP(&logsRaw, "raw", "r", false, "Dump the unformatted log content")
-	buildPushCmd.Flags().BoolVar(&buildPushNoDeploy, "no-deploy", false, "Only build, skip deploy action")
-	buildCmd.AddCommand(buildListCmd, buildInspectCmd, buildLogsCmd, buildAbortCmd, buildStatusCmd, buildWatchCmd, buildPushCmd)
-	rootCmd.AddCommand(buildCmd)
+	}
+	return result, nil
+}
+
+// runGit runs git in the directory and returns its trimmed output.
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	git := exec.CommandContext(ctx, "git", args...)
+	git.Dir = dir
+	var stderr bytes.Buffer
+	git.Stderr = &stderr
+	out, err := git.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
 }
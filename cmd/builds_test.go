@@ -0,0 +1,311 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dustin/go-humanize"
+	"github.com/valar/cli/api"
+)
+
+const testMonorepoConfig = `project: acme
+services:
+- service: api
+  path: services/api
+  build:
+    constructor: go1.22
+  deployment: {}
+- service: web
+  path: services/web
+  build:
+    constructor: node20
+  deployment: {}
+`
+
+func TestMonorepo(t *testing.T) {
+	fake := setupWorkspace(t, "secret")
+	root, _ := os.Getwd()
+	writeFiles(t, map[string]string{
+		functionConfiguration:      testMonorepoConfig,
+		"services/api/cmd/main.go": "package main\n",
+		"services/web/index.js":    "",
+	})
+	pushes := func(t *testing.T, args ...string) map[string]int {
+		t.Helper()
+		before := map[string]int{"api": len(fake.Builds("acme", "api")), "web": len(fake.Builds("acme", "web"))}
+		if _, stderr, code := runCommand(t, append([]string{"build", "push"}, args...)...); code != 0 {
+			t.Fatalf("build push %v exited with %d: %s", args, code, stderr)
+		}
+		return map[string]int{
+			"api": len(fake.Builds("acme", "api")) - before["api"],
+			"web": len(fake.Builds("acme", "web")) - before["web"],
+		}
+	}
+
+	// The service is picked from the working directory or --service.
+	t.Chdir(filepath.Join(root, "services", "api", "cmd"))
+	if got := pushes(t); got["api"] != 1 || got["web"] != 0 {
+		t.Errorf("build push in services/api/cmd pushed %v", got)
+	}
+	t.Chdir(root)
+	if got := pushes(t, "--service", "web"); got["api"] != 0 || got["web"] != 1 {
+		t.Errorf("build push --service web pushed %v", got)
+	}
+	if got := pushes(t, "--all"); got["api"] != 1 || got["web"] != 1 {
+		t.Errorf("build push --all pushed %v", got)
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	if got := pushes(t, "--changed-since", "HEAD"); got["api"] != 0 || got["web"] != 0 {
+		t.Errorf("build push --changed-since HEAD without changes pushed %v", got)
+	}
+	if err := os.WriteFile(filepath.Join(root, "services", "web", "style.css"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := pushes(t, "--changed-since", "HEAD"); got["api"] != 0 || got["web"] != 1 {
+		t.Errorf("build push --changed-since HEAD with a new file in web pushed %v", got)
+	}
+}
+
+func TestEnvironmentSources(t *testing.T) {
+	fake := setupWorkspace(t, "secret")
+	t.Setenv("VALAR_TEST_REGION", "eu")
+	cfg := `project: acme
+service: web
+build:
+  constructor: go1.22
+  environment:
+  - GOFLAGS=${VALAR_TEST_GOFLAGS:--mod=mod}
+deployment:
+  environment:
+  - REGION=${VALAR_TEST_REGION}
+  - PROMPT=$${PS1}
+  - key: API_KEY
+    valueFrom: file:api-key.txt
+    secret: true
+  - key: GREETING
+    valueFrom: command:echo hi
+  - key: STORED
+    value: encrypted:c2VjcmV0
+    secret: true
+`
+	writeFiles(t, map[string]string{functionConfiguration: cfg, "api-key.txt": "s3cr3t\n"})
+	req, _ := pushBuild(t, fake)
+	if want := []api.KVPair{{Key: "GOFLAGS", Value: "-mod=mod"}}; !slices.Equal(req.Build.Environment, want) {
+		t.Errorf("build environment = %v, want %v", req.Build.Environment, want)
+	}
+	want := []api.KVPair{
+		{Key: "REGION", Value: "eu"},
+		{Key: "PROMPT", Value: "${PS1}"},
+		{Key: "API_KEY", Value: "encrypted:czNjcjN0", Secret: true},
+		{Key: "GREETING", Value: "hi"},
+		{Key: "STORED", Value: "encrypted:c2VjcmV0", Secret: true},
+	}
+	if !slices.Equal(req.Deployment.Environment, want) {
+		t.Errorf("deployment environment = %v, want %v", req.Deployment.Environment, want)
+	}
+	if data, _ := os.ReadFile(functionConfiguration); string(data) != cfg {
+		t.Errorf("build push modified %s:\n%s", functionConfiguration, data)
+	}
+	// Listings name the sources instead of resolving them.
+	stdout, _, _ := runCommand(t, "env", "list", "--format", "raw")
+	if want := "REGION=${VALAR_TEST_REGION}\nPROMPT=$${PS1}\nAPI_KEY=(from file:api-key.txt)\nGREETING=(from command:echo hi)\nSTORED=encrypted:c2VjcmV0\n"; stdout != want {
+		t.Errorf("env list --format raw = %q, want %q", stdout, want)
+	}
+
+	os.Unsetenv("VALAR_TEST_REGION")
+	if _, stderr, code := runCommand(t, "build", "push"); code == 0 || !strings.Contains(stderr, "VALAR_TEST_REGION is not set") {
+		t.Errorf("build push with an unset variable exited with %d: %s", code, stderr)
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	fake := setupWorkspace(t, "secret")
+	// Keep the CLI configuration of the workspace out of the source.
+	t.Chdir(t.TempDir())
+	// The patterns of the build, .gitignore and .valarignore files all apply.
+	writeFiles(t, map[string]string{
+		functionConfiguration: `project: acme
+service: web
+build:
+  constructor: go1.22
+  ignore:
+  - "*.log"
+  - "!keep.log"
+  gitignore: true
+deployment: {}
+`,
+		".gitignore":       "dist/\n",
+		"main.go":          "",
+		"debug.log":        "",
+		"keep.log":         "",
+		"dist/app.js":      "",
+		"secret.txt":       "",
+		"sub/.valarignore": "secret.txt\n",
+		"sub/secret.txt":   "",
+	})
+	req, _ := pushBuild(t, fake, "--no-deploy")
+	got := archiveFiles(t, fake.Artifact("acme", "web", req.Artifact))
+	want := []string{".gitignore", functionConfiguration, "keep.log", "main.go", "secret.txt", "sub/.valarignore"}
+	if !slices.Equal(got, want) {
+		t.Errorf("build push archived %q, want %q", got, want)
+	}
+}
+
+func TestArchiveSummary(t *testing.T) {
+	fake := setupWorkspace(t, "secret")
+	t.Chdir(t.TempDir())
+	writeFiles(t, map[string]string{functionConfiguration: testServiceConfig, "main.go": "package main\n"})
+	req, stderr := pushBuild(t, fake, "--no-deploy")
+	artifact := fake.Artifact("acme", "web", req.Artifact)
+	size := len(testServiceConfig) + len("package main\n")
+	want := fmt.Sprintf("Archived 2 files, %d B compressed to %s, archive sha256:%x,", size, humanize.IBytes(uint64(len(artifact))), sha256.Sum256(artifact))
+	if !strings.Contains(stderr, want) {
+		t.Errorf("build push reported %q, want %q", stderr, want)
+	}
+}
+
+func TestArtifactUpload(t *testing.T) {
+	fake := setupWorkspace(t, "secret")
+	t.Chdir(t.TempDir())
+	writeFiles(t, map[string]string{functionConfiguration: testServiceConfig, "main.go": "package main\n"})
+	push := func(t *testing.T, args ...string) (artifact []byte, sourceHash, stderr string) {
+		t.Helper()
+		req, stderr := pushBuild(t, fake, append([]string{"--no-deploy"}, args...)...)
+		artifact = fake.Artifact("acme", "web", req.Artifact)
+		if got := archiveFiles(t, artifact); !slices.Equal(got, []string{functionConfiguration, "main.go"}) {
+			t.Errorf("build push %v archived %q", args, got)
+		}
+		_, sourceHash, _ = strings.Cut(stderr, "source sha256:")
+		return artifact, strings.TrimSpace(sourceHash), stderr
+	}
+	gzipMagic, zstdMagic := []byte{0x1f, 0x8b}, []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	gzipped, gzipHash, _ := push(t)
+	if !bytes.HasPrefix(gzipped, gzipMagic) {
+		t.Errorf("build push to an endpoint accepting gzip did not upload gzip")
+	}
+	if _, _, stderr := push(t, "--compression", "zstd"); !strings.Contains(stderr, "does not accept zstd") {
+		t.Errorf("build push --compression zstd to an endpoint accepting gzip reported %q", stderr)
+	}
+
+	fake.ArtifactEncodings = []string{"zstd", "gzip"}
+	compressed, zstdHash, _ := push(t)
+	if !bytes.HasPrefix(compressed, zstdMagic) {
+		t.Errorf("build push to an endpoint accepting zstd did not upload zstd")
+	}
+	if zstdHash != gzipHash {
+		t.Errorf("source hash of the zstd archive = %s, want %s as for gzip", zstdHash, gzipHash)
+	}
+	if gzipped, _, _ := push(t, "--compression", "gzip"); !bytes.HasPrefix(gzipped, gzipMagic) {
+		t.Errorf("build push --compression gzip did not upload gzip")
+	}
+
+	fake.RequireLength = true
+	if _, _, stderr := push(t); !strings.Contains(stderr, "buffering it in a temporary file") {
+		t.Errorf("build push to an endpoint requiring the length reported %q", stderr)
+	}
+}
+
+func TestArtifactUploadAnsweredEarly(t *testing.T) {
+	fake := setupWorkspace(t, "secret")
+	fake.AnswerEarly = true
+	t.Chdir(t.TempDir())
+	// An incompressible archive outgrows the buffers of the connection, so
+	// that it is still being written when the endpoint answers.
+	data := make([]byte, 16<<20)
+	rand.Read(data)
+	writeFiles(t, map[string]string{functionConfiguration: testServiceConfig, "data.bin": string(data)})
+	_, stderr, code := runCommand(t, "build", "push", "--no-deploy")
+	if code == 0 || !strings.Contains(stderr, "the endpoint answered before receiving the whole archive") {
+		t.Errorf("build push to an endpoint answering early exited with %d: %s", code, stderr)
+	}
+}
+
+func TestUploadProgress(t *testing.T) {
+	fake := setupWorkspace(t, "secret")
+	if err := os.WriteFile("main.go", bytes.Repeat([]byte("package main\n"), 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	interval := progressInterval
+	progressInterval = 0
+	t.Cleanup(func() { progressInterval = interval })
+
+	for _, requireLength := range []bool{false, true} {
+		fake.RequireLength = requireLength
+		_, stderr, code := runCommand(t, "build", "push", "--no-deploy")
+		if code != 0 {
+			t.Fatalf("build push exited with %d: %s", code, stderr)
+		}
+		for _, want := range []string{"Uploaded ", "/s).\n", " files, 13 KiB compressed to "} {
+			if !strings.Contains(stderr, want) {
+				t.Errorf("build push to an endpoint requiring the length %v reported %q, want %q", requireLength, stderr, want)
+			}
+		}
+		if requireLength && !strings.Contains(stderr, " left).\n") {
+			t.Errorf("build push of a buffered archive reported no remaining time: %q", stderr)
+		}
+	}
+}
+
+func TestPushDryRun(t *testing.T) {
+	fake := setupWorkspace(t, "secret")
+	writeFiles(t, map[string]string{"data/fixture.bin": string(make([]byte, 3000))})
+	builds := len(fake.Builds("acme", "web"))
+
+	stdout, stderr, code := runCommand(t, "build", "push", "--dry-run")
+	if code != 0 {
+		t.Fatalf("build push --dry-run exited with %d: %s", code, stderr)
+	}
+	for _, want := range []string{"data/fixture.bin\n", "SIZE    FILES LARGEST DIRECTORIES\n2.9 KiB 1     data/\n", " in total.\n"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("build push --dry-run printed %q, want %q", stdout, want)
+		}
+	}
+	stdout, _, _ = runCommand(t, "build", "push", "--dry-run", "--top", "1", "-o", "json")
+	var manifest sourceManifest
+	if err := json.Unmarshal([]byte(stdout), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.LargestFiles) != 1 || manifest.LargestFiles[0].Name != "data/fixture.bin" || manifest.TotalSize < 3000 {
+		t.Errorf("build push --dry-run --top 1 listed %+v", manifest)
+	}
+
+	if _, stderr, code := runCommand(t, "build", "push", "--max-size", "1KB"); code == 0 || !strings.Contains(stderr, "more than the maximum of 1000 B") {
+		t.Errorf("build push --max-size 1KB exited with %d: %s", code, stderr)
+	}
+	config := strings.Replace(testServiceConfig, "constructor: go1.22\n", "constructor: go1.22\n  maxSize: 1KiB\n", 1)
+	if err := os.WriteFile(functionConfiguration, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, stderr, code := runCommand(t, "build", "push", "--dry-run"); code == 0 || !strings.Contains(stderr, "more than the maximum of 1.0 KiB") {
+		t.Errorf("build push --dry-run of a source exceeding maxSize exited with %d: %s", code, stderr)
+	}
+	if got := len(fake.Builds("acme", "web")); got != builds {
+		t.Errorf("build push without pushing submitted %d builds", got-builds)
+	}
+	if _, stderr, code := runCommand(t, "build", "push", "--max-size", "1MB"); code != 0 {
+		t.Errorf("build push --max-size 1MB overriding maxSize exited with %d: %s", code, stderr)
+	}
+}
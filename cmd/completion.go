@@ -0,0 +1,314 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/valar/cli/api"
+	"github.com/valar/cli/config"
+)
+
+const (
+	// completionCacheTTL is how long candidates fetched from the API are reused,
+	// so that repeatedly pressing tab does not hit the API every time.
+	completionCacheTTL = 30 * time.Second
+	// completionTimeout bounds fetching candidates from the API.
+	completionTimeout = 5 * time.Second
+)
+
+// permissionActions are the actions permissions can be granted for.
+var permissionActions = []string{"read", "write", "invoke", "manage"}
+
+type completionFunc func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// completionFetcher lists candidates of a project or, if the service is not
+// empty, of a service.
+type completionFetcher func(ctx context.Context, client *api.Client, project, service string) ([]string, error)
+
+// firstArg restricts a completion to the first positional argument.
+func firstArg(f completionFunc) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return f(cmd, args, toComplete)
+	}
+}
+
+// completeFromAPI completes candidates returned by fetch. If service is not
+// nil, candidates are scoped to the selected service.
+func (c *cli) completeFromAPI(kind string, service *string, fetch completionFetcher) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		candidates, err := c.completionCandidates(cmd, kind, service, fetch)
+		if err != nil {
+			cobra.CompDebugln(err.Error(), true)
+			return nil, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveError
+		}
+		return filterCompletions(candidates, toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func (c *cli) completionCandidates(cmd *cobra.Command, kind string, service *string, fetch completionFetcher) ([]string, error) {
+	cfg, err := c.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	// Completion must neither end the process nor write the configuration.
+	var missing string
+	cfg.Fatal = func(message string) {
+		if missing == "" {
+			missing = message
+		}
+	}
+	cfg.ReadOnly = true
+	svc, err := config.NewServiceConfigWithFallback(functionConfiguration, service, cfg)
+	if err != nil {
+		return nil, err
+	}
+	project, serviceName := svc.Project(), ""
+	if service != nil {
+		serviceName = svc.Service()
+	}
+	endpoint, token := cfg.Endpoint(), cfg.Token()
+	if missing != "" {
+		return nil, errors.New(missing)
+	}
+	key := completionCacheKey(kind, endpoint, token, project, serviceName)
+	if candidates, ok := c.readCompletionCache(key); ok {
+		return candidates, nil
+	}
+	ctx, cancel := context.WithTimeout(cmd.Context(), completionTimeout)
+	defer cancel()
+	client, err := c.NewClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := fetch(ctx, client, project, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	writeCompletionCache(key, candidates)
+	return candidates, nil
+}
+
+// completionCacheKey derives the cache file name of a kind of candidates.
+// The token is part of it, as its permissions decide what is listed.
+func completionCacheKey(kind string, parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(h, "%q\n", part)
+	}
+	return kind + "-" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func completionCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "valar", "completion"), nil
+}
+
+func (c *cli) readCompletionCache(key string) ([]string, bool) {
+	dir, err := completionCacheDir()
+	if err != nil {
+		return nil, false
+	}
+	path := filepath.Join(dir, key+".json")
+	info, err := os.Stat(path)
+	if err != nil || c.Now().Sub(info.ModTime()) > completionCacheTTL {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var candidates []string
+	if err := json.Unmarshal(data, &candidates); err != nil {
+		return nil, false
+	}
+	return candidates, true
+}
+
+// writeCompletionCache stores candidates on a best-effort basis.
+func writeCompletionCache(key string, candidates []string) {
+	dir, err := completionCacheDir()
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+	data, err := json.Marshal(candidates)
+	if err != nil {
+		return
+	}
+	os.WriteFile(filepath.Join(dir, key+".json"), data, 0600)
+}
+
+// filterCompletions keeps the candidates starting with toComplete. Candidates
+// may carry a description separated by a tab.
+func filterCompletions(candidates []string, toComplete string) []string {
+	var filtered []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, toComplete) {
+			filtered = append(filtered, candidate)
+		}
+	}
+	return filtered
+}
+
+func completeStatic(candidates ...string) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return filterCompletions(candidates, toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func (c *cli) completeServices() completionFunc {
+	return c.completeFromAPI("services", nil, func(ctx context.Context, client *api.Client, project, _ string) ([]string, error) {
+		services, err := client.ListServicesContext(ctx, project, "")
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(services))
+		for _, svc := range services {
+			names = append(names, svc.Name)
+		}
+		sort.Strings(names)
+		return names, nil
+	})
+}
+
+func (c *cli) completeBuilds(service *string) completionFunc {
+	return c.completeFromAPI("builds", service, func(ctx context.Context, client *api.Client, project, service string) ([]string, error) {
+		builds, err := client.ListBuildsContext(ctx, project, service, "")
+		if err != nil {
+			return nil, err
+		}
+		// Offer the latest builds first.
+		sort.Slice(builds, func(i, j int) bool { return builds[i].CreatedAt.After(builds[j].CreatedAt) })
+		ids := make([]string, 0, len(builds))
+		for _, b := range builds {
+			ids = append(ids, fmt.Sprintf("%s\t%s, %s", b.ID, b.Status, c.humanizeTime(b.CreatedAt)))
+		}
+		return ids, nil
+	})
+}
+
+// completeRollbackDelta offers the distances to the previous deployments.
+func (c *cli) completeRollbackDelta(service *string) completionFunc {
+	return c.completeFromAPI("deployments", service, func(ctx context.Context, client *api.Client, project, service string) ([]string, error) {
+		deployments, err := client.ListDeploymentsContext(ctx, project, service)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(deployments, func(i, j int) bool { return deployments[i].Version > deployments[j].Version })
+		deltas := []string{}
+		for delta := 1; delta < len(deployments); delta++ {
+			d := deployments[delta]
+			deltas = append(deltas, fmt.Sprintf("%d\tversion %d of build %s", delta, d.Version, d.Build))
+		}
+		return deltas, nil
+	})
+}
+
+func (c *cli) completeSchedules(service *string) completionFunc {
+	return c.completeFromAPI("schedules", service, func(ctx context.Context, client *api.Client, project, service string) ([]string, error) {
+		schedules, err := client.ListSchedulesContext(ctx, project, service)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(schedules))
+		for _, sched := range schedules {
+			names = append(names, fmt.Sprintf("%s\t%s", sched.Name, sched.Timespec))
+		}
+		sort.Strings(names)
+		return names, nil
+	})
+}
+
+func (c *cli) completeDomains() completionFunc {
+	return c.completeFromAPI("domains", nil, func(ctx context.Context, client *api.Client, project, _ string) ([]string, error) {
+		domains, err := client.ListDomainsContext(ctx, project)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(domains))
+		for _, d := range domains {
+			names = append(names, d.Domain)
+		}
+		sort.Strings(names)
+		return names, nil
+	})
+}
+
+// completeConfigNames completes names of the local configuration, e.g. contexts.
+func (c *cli) completeConfigNames(names func(cfg *config.CLIConfig) []string) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		cfg, err := c.loadConfig()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveError
+		}
+		candidates := names(cfg)
+		sort.Strings(candidates)
+		return filterCompletions(candidates, toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func contextNames(cfg *config.CLIConfig) []string {
+	names := []string{}
+	for name := range cfg.Contexts {
+		names = append(names, name)
+	}
+	return names
+}
+
+// completeProfiles completes the profiles defined in .valar.yml, including
+// the ones of the services of a monorepo.
+func completeProfiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var svc config.ServiceConfigYAML
+	if err := svc.ReadFromFile(functionConfiguration); err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var candidates []string
+	for _, entry := range append([]config.ServiceConfigYAML{svc}, svc.Services...) {
+		for name := range entry.Profiles {
+			if !slices.Contains(candidates, name) {
+				candidates = append(candidates, name)
+			}
+		}
+	}
+	sort.Strings(candidates)
+	return filterCompletions(candidates, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+func configFiles(cfg *config.CLIConfig) []string {
+	return cfg.Files()
+}
+
+func endpointNames(cfg *config.CLIConfig) []string {
+	names := []string{}
+	for name := range cfg.Endpoints {
+		names = append(names, name)
+	}
+	return names
+}
+
+// completePermissionArgs completes the action of auth commands.
+func completePermissionArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 2 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return filterCompletions(permissionActions, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/juju/ansiterm"
@@ -14,13 +15,13 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-func prompt(label, defaultValue string) string {
+func prompt(cmd *cobra.Command, label, defaultValue string) string {
 	if defaultValue != "" {
-		fmt.Fprintf(os.Stderr, "%s (%s): ", label, defaultValue)
+		fmt.Fprintf(cmd.ErrOrStderr(), "%s (%s): ", label, defaultValue)
 	} else {
-		fmt.Fprintf(os.Stderr, "%s: ", label)
+		fmt.Fprintf(cmd.ErrOrStderr(), "%s: ", label)
 	}
-	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	line, _ := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
 	line = strings.TrimSpace(line)
 	if line == "" {
 		return defaultValue
@@ -28,249 +29,420 @@ func prompt(label, defaultValue string) string {
 	return line
 }
 
-func promptSecret(label string) string {
-	fmt.Fprintf(os.Stderr, "%s: ", label)
-	b, _ := term.ReadPassword(int(os.Stdin.Fd()))
-	fmt.Fprintln(os.Stderr)
+func promptSecret(cmd *cobra.Command, label string) string {
+	fmt.Fprintf(cmd.ErrOrStderr(), "%s: ", label)
+	// Only hide the input if it is typed into a terminal.
+	in, ok := cmd.InOrStdin().(*os.File)
+	if !ok || !term.IsTerminal(int(in.Fd())) {
+		line, _ := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+		return strings.TrimSpace(line)
+	}
+	b, _ := term.ReadPassword(int(in.Fd()))
+	fmt.Fprintln(cmd.ErrOrStderr())
 	return string(b)
 }
 
-var configCmd = &cobra.Command{
-	Use:   "config",
-	Short: "Configure the CLI tool",
+func newConfigCmd(c *cli) *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Configure the CLI tool",
+	}
+	configCmd.AddCommand(
+		newConfigInitCmd(c),
+		newConfigContextCmd(c),
+		newConfigEndpointCmd(c),
+		newConfigViewCmd(c),
+	)
+	return configCmd
 }
 
-var configViewCmd = &cobra.Command{
-	Use:   "view",
-	Short: "View the merged configuration as YAML.",
-	Run: func(cmd *cobra.Command, args []string) {
-		enc := yaml.NewEncoder(os.Stdout)
-		enc.SetIndent(2)
-		enc.Encode(globalConfiguration)
-	},
+func newConfigViewCmd(c *cli) *cobra.Command {
+	var showTokens, showOrigin bool
+	configViewCmd := &cobra.Command{
+		Use:   "view",
+		Short: "View the merged configuration as YAML.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := c.config.Masked()
+			if showTokens {
+				cfg = c.config
+			}
+			var doc yaml.Node
+			if err := doc.Encode(cfg); err != nil {
+				return fmt.Errorf("marshal config: %w", err)
+			}
+			if showOrigin {
+				annotateOrigins(&doc, c.config)
+			}
+			enc := yaml.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent(2)
+			return enc.Encode(&doc)
+		},
+	}
+	configViewCmd.Flags().BoolVar(&showTokens, "show-tokens", false, "Print tokens instead of masking them")
+	configViewCmd.Flags().BoolVar(&showOrigin, "show-origin", false, "Annotate each context and endpoint with the file and line it is defined on")
+	return configViewCmd
 }
 
-var configEndpointCmd = &cobra.Command{
-	Use:   "endpoint",
-	Short: "Manage API endpoints.",
-	Args:  cobra.NoArgs,
-	Run: func(cmd *cobra.Command, args []string) {
-		tw := ansiterm.NewTabWriter(os.Stdout, 6, 0, 1, ' ', 0)
-		fmt.Fprintln(tw, "NAME\tURL\tTOKEN")
-		for name, ep := range globalConfiguration.Endpoints {
-			fmt.Fprintf(tw, "%s\t%s\t%s\n", name, ep.URL, ep.Token)
+// annotateOrigins adds a comment telling where it is defined to the active
+// context and each context and endpoint of the encoded configuration.
+func annotateOrigins(doc *yaml.Node, cfg *config.CLIConfig) {
+	annotate := func(key *yaml.Node, origin config.Origin, ok bool) {
+		if ok {
+			key.LineComment = origin.String()
 		}
-		tw.Flush()
-	},
+	}
+	root := doc.Content
+	for i := 0; i+1 < len(root); i += 2 {
+		key, value := root[i], root[i+1]
+		switch key.Value {
+		case "activeContext":
+			origin, ok := cfg.ActiveContextOrigin()
+			annotate(key, origin, ok)
+		case "contexts":
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				origin, ok := cfg.ContextOrigin(value.Content[j].Value)
+				annotate(value.Content[j], origin, ok)
+			}
+		case "endpoints":
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				origin, ok := cfg.EndpointOrigin(value.Content[j].Value)
+				annotate(value.Content[j], origin, ok)
+			}
+		}
+	}
 }
 
-var configEndpointSetUrl, configEndpointSetToken string
-
-var configEndpointSetCmd = &cobra.Command{
-	Use:   "set [endpoint]",
-	Short: "Configure an API endpoint.",
-	Args:  cobra.ExactArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		ep := globalConfiguration.Endpoints[args[0]]
-		if configEndpointSetToken != "" {
-			ep.Token = configEndpointSetToken
-		}
-		if configEndpointSetUrl != "" {
-			ep.URL = configEndpointSetUrl
-		}
-		if globalConfiguration.Endpoints == nil {
-			globalConfiguration.Endpoints = map[string]config.APIEndpoint{}
-		}
-		globalConfiguration.Endpoints[args[0]] = ep
-		if err := globalConfiguration.Write(); err != nil {
-			return fmt.Errorf("write config: %w", err)
-		}
-		return nil
-	},
+func newConfigEndpointCmd(c *cli) *cobra.Command {
+	var showTokens bool
+	configEndpointCmd := &cobra.Command{
+		Use:   "endpoint",
+		Short: "Manage API endpoints.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			names := endpointNames(c.config)
+			sort.Strings(names)
+			tw := ansiterm.NewTabWriter(cmd.OutOrStdout(), 6, 0, 1, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tURL\tTOKEN\tCREDENTIAL HELPER")
+			for _, name := range names {
+				ep := c.config.Endpoints[name]
+				token := config.MaskToken(ep.Token)
+				if showTokens {
+					token = ep.Token
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", name, ep.URL, token, ep.CredentialHelper)
+			}
+			tw.Flush()
+		},
+	}
+	configEndpointCmd.Flags().BoolVar(&showTokens, "show-tokens", false, "Print tokens instead of masking them")
+	configEndpointCmd.AddCommand(
+		newConfigEndpointSetCmd(c),
+		newConfigEndpointRemoveCmd(c),
+		newConfigEndpointMigrateCredentialsCmd(c),
+	)
+	return configEndpointCmd
 }
 
-var configEndpointRemoveCmd = &cobra.Command{
-	Use:   "remove [endpoint]",
-	Short: "Drop an API endpoint from the configuration.",
-	Args:  cobra.ExactArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		delete(globalConfiguration.Endpoints, args[0])
-		if err := globalConfiguration.Write(); err != nil {
-			return fmt.Errorf("write config: %w", err)
-		}
-		return nil
-	},
+func newConfigEndpointSetCmd(c *cli) *cobra.Command {
+	var url, token, helper, file string
+	configEndpointSetCmd := &cobra.Command{
+		Use:               "set [endpoint]",
+		Short:             "Configure an API endpoint.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(c.completeConfigNames(endpointNames)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ep := c.config.Endpoints[args[0]]
+			if url != "" {
+				ep.URL = url
+			}
+			if cmd.Flags().Changed("credential-helper") {
+				ep.CredentialHelper = config.CredentialHelper(helper)
+			}
+			if c.config.Endpoints == nil {
+				c.config.Endpoints = map[string]config.APIEndpoint{}
+			}
+			c.config.Endpoints[args[0]] = ep
+			if file != "" {
+				if err := c.config.SetEndpointFile(args[0], file); err != nil {
+					return err
+				}
+			}
+			if token != "" {
+				if err := c.config.SetToken(cmd.Context(), args[0], token); err != nil {
+					return fmt.Errorf("store token: %w", err)
+				}
+			}
+			if err := c.config.Write(); err != nil {
+				return fmt.Errorf("write config: %w", err)
+			}
+			return nil
+		},
+	}
+	configEndpointSetCmd.Flags().StringVar(&token, "set-token", "", "Token to store for the endpoint")
+	configEndpointSetCmd.Flags().StringVar(&url, "url", "", "URL the API can be reached on")
+	configEndpointSetCmd.Flags().StringVar(&helper, "credential-helper", "", "Credential helper keeping the token, e.g. osxkeychain or libsecret (empty to keep it in the configuration)")
+	configEndpointSetCmd.Flags().StringVar(&file, "file", "", "Configuration file to store the endpoint in, one of the files in VALARCONFIG")
+	configEndpointSetCmd.RegisterFlagCompletionFunc("file", c.completeConfigNames(configFiles))
+	return configEndpointSetCmd
 }
 
-var configContextCmd = &cobra.Command{
-	Use:   "context",
-	Short: "Manage CLI contexts.",
-	Args:  cobra.NoArgs,
-	Run: func(cmd *cobra.Command, args []string) {
-		tw := ansiterm.NewTabWriter(os.Stdout, 6, 0, 1, ' ', 0)
-		fmt.Fprintln(tw, "ACTIVE\tNAME\tENDPOINT\tPROJECT")
-		for name, ctx := range globalConfiguration.Contexts {
-			active := ""
-			if name == globalConfiguration.ActiveContext {
-				active = "*"
-			}
-			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", active, name, ctx.Endpoint, ctx.Project)
-		}
-		tw.Flush()
-	},
+func newConfigEndpointMigrateCredentialsCmd(c *cli) *cobra.Command {
+	var helper string
+	configEndpointMigrateCredentialsCmd := &cobra.Command{
+		Use:               "migrate-credentials --credential-helper helper [endpoint...]",
+		Short:             "Move tokens stored in the configuration to a credential helper.",
+		ValidArgsFunction: c.completeConfigNames(endpointNames),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			names := args
+			if len(names) == 0 {
+				names = endpointNames(c.config)
+				sort.Strings(names)
+			}
+			var migrateErr error
+			for _, name := range names {
+				ep, ok := c.config.Endpoints[name]
+				if !ok {
+					migrateErr = fmt.Errorf("endpoint %s does not exist", name)
+					break
+				}
+				if ep.Token == "" {
+					continue
+				}
+				ep.CredentialHelper = config.CredentialHelper(helper)
+				if err := ep.CredentialHelper.Store(cmd.Context(), cmd.ErrOrStderr(), ep.URL, config.CredentialToken, ep.Token); err != nil {
+					migrateErr = fmt.Errorf("migrate endpoint %s: %w", name, err)
+					break
+				}
+				if ep.RefreshToken != "" {
+					if err := ep.CredentialHelper.Store(cmd.Context(), cmd.ErrOrStderr(), ep.URL, config.CredentialRefreshToken, ep.RefreshToken); err != nil {
+						migrateErr = fmt.Errorf("migrate endpoint %s: %w", name, err)
+						break
+					}
+				}
+				// Make sure the helper actually keeps the token before dropping it.
+				if stored, err := ep.CredentialHelper.Get(cmd.Context(), cmd.ErrOrStderr(), ep.URL, config.CredentialToken); err != nil || stored != ep.Token {
+					migrateErr = fmt.Errorf("migrate endpoint %s: credential helper %s did not keep the token", name, helper)
+					break
+				}
+				ep.Token, ep.RefreshToken = "", ""
+				c.config.Endpoints[name] = ep
+				fmt.Fprintf(cmd.ErrOrStderr(), "Moved token of endpoint %s to credential helper %s.\n", name, helper)
+			}
+			if err := c.config.Write(); err != nil {
+				return fmt.Errorf("write config: %w", err)
+			}
+			return migrateErr
+		},
+	}
+	configEndpointMigrateCredentialsCmd.Flags().StringVar(&helper, "credential-helper", "", "Credential helper to move the tokens to")
+	configEndpointMigrateCredentialsCmd.MarkFlagRequired("credential-helper")
+	return configEndpointMigrateCredentialsCmd
 }
 
-var configContextUseCmd = &cobra.Command{
-	Use:   "use [context]",
-	Short: "Change the active context.",
-	Args:  cobra.ExactArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		globalConfiguration.ActiveContext = args[0]
-		if err := globalConfiguration.Write(); err != nil {
-			return fmt.Errorf("write config: %w", err)
-		}
-		return nil
-	},
+func newConfigEndpointRemoveCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:               "remove [endpoint]",
+		Short:             "Drop an API endpoint from the configuration.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(c.completeConfigNames(endpointNames)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			delete(c.config.Endpoints, args[0])
+			if err := c.config.Write(); err != nil {
+				return fmt.Errorf("write config: %w", err)
+			}
+			return nil
+		},
+	}
 }
 
-var configContextSetEndpoint, configContextSetProject string
+func newConfigContextCmd(c *cli) *cobra.Command {
+	configContextCmd := &cobra.Command{
+		Use:   "context",
+		Short: "Manage CLI contexts.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			tw := ansiterm.NewTabWriter(cmd.OutOrStdout(), 6, 0, 1, ' ', 0)
+			fmt.Fprintln(tw, "ACTIVE\tNAME\tENDPOINT\tPROJECT\tPROFILE")
+			for name, ctx := range c.config.Contexts {
+				active := ""
+				if name == c.config.ActiveContext {
+					active = "*"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", active, name, ctx.Endpoint, ctx.Project, ctx.Profile)
+			}
+			tw.Flush()
+		},
+	}
+	configContextCmd.AddCommand(newConfigContextUseCmd(c), newConfigContextSetCmd(c), newConfigContextRemoveCmd(c))
+	return configContextCmd
+}
 
-var configContextSetCmd = &cobra.Command{
-	Use:   "set [context]",
-	Short: "Configure a CLI context.",
-	Args:  cobra.ExactArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		ctx := globalConfiguration.Contexts[args[0]]
-		if configContextSetEndpoint != "" {
-			ctx.Endpoint = configContextSetEndpoint
-		}
-		if configContextSetProject != "" {
-			ctx.Project = configContextSetProject
-		}
-		globalConfiguration.Contexts[args[0]] = ctx
-		if err := globalConfiguration.Write(); err != nil {
-			return fmt.Errorf("write config: %w", err)
-		}
-		return nil
-	},
+func newConfigContextUseCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:               "use [context]",
+		Short:             "Change the active context.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(c.completeConfigNames(contextNames)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c.config.ActiveContext = args[0]
+			if err := c.config.Write(); err != nil {
+				return fmt.Errorf("write config: %w", err)
+			}
+			return nil
+		},
+	}
 }
 
-var configContextRemoveCmd = &cobra.Command{
-	Use:   "remove [context]",
-	Short: "Drop a context from the configuration.",
-	Args:  cobra.ExactArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		delete(globalConfiguration.Contexts, args[0])
-		if err := globalConfiguration.Write(); err != nil {
-			return fmt.Errorf("write config: %w", err)
-		}
-		return nil
-	},
+func newConfigContextSetCmd(c *cli) *cobra.Command {
+	var endpoint, project, profile, file string
+	configContextSetCmd := &cobra.Command{
+		Use:               "set [context]",
+		Short:             "Configure a CLI context.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(c.completeConfigNames(contextNames)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := c.config.Contexts[args[0]]
+			if endpoint != "" {
+				ctx.Endpoint = endpoint
+			}
+			if project != "" {
+				ctx.Project = project
+			}
+			if cmd.Flags().Changed("set-profile") {
+				ctx.Profile = profile
+			}
+			c.config.Contexts[args[0]] = ctx
+			if file != "" {
+				if err := c.config.SetContextFile(args[0], file); err != nil {
+					return err
+				}
+			}
+			if err := c.config.Write(); err != nil {
+				return fmt.Errorf("write config: %w", err)
+			}
+			return nil
+		},
+	}
+	configContextSetCmd.Flags().StringVar(&project, "set-project", "", "Project of the context")
+	configContextSetCmd.Flags().StringVar(&endpoint, "set-endpoint", "", "API endpoint of the context")
+	configContextSetCmd.Flags().StringVar(&profile, "set-profile", "", "Profile of .valar.yml files the context applies (empty to apply none)")
+	configContextSetCmd.Flags().StringVar(&file, "file", "", "Configuration file to store the context in, one of the files in VALARCONFIG")
+	configContextSetCmd.RegisterFlagCompletionFunc("set-endpoint", c.completeConfigNames(endpointNames))
+	configContextSetCmd.RegisterFlagCompletionFunc("file", c.completeConfigNames(configFiles))
+	configContextSetCmd.RegisterFlagCompletionFunc("set-profile", completeProfiles)
+	return configContextSetCmd
 }
 
-var configInitUrl, configInitToken, configInitProject, configInitName string
-var configInitForce bool
+func newConfigContextRemoveCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:               "remove [context]",
+		Short:             "Drop a context from the configuration.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(c.completeConfigNames(contextNames)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			delete(c.config.Contexts, args[0])
+			if err := c.config.Write(); err != nil {
+				return fmt.Errorf("write config: %w", err)
+			}
+			return nil
+		},
+	}
+}
 
-var configInitCmd = &cobra.Command{
-	Use:   "init",
-	Short: "Set up a new CLI configuration interactively.",
-	RunE: func(cmd *cobra.Command, args []string) error {
-		// Collect values from flags, falling back to interactive prompts.
-		urlValue := configInitUrl
-		tokenValue := configInitToken
-		projectValue := configInitProject
-		nameValue := configInitName
+func newConfigInitCmd(c *cli) *cobra.Command {
+	var (
+		url, token, project, name, helper string
+		force                             bool
+	)
+	configInitCmd := &cobra.Command{
+		Use:   "init",
+		Short: "Set up a new CLI configuration interactively.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Collect values from flags, falling back to interactive prompts.
+			urlValue := url
+			tokenValue := token
+			projectValue := project
+			nameValue := name
 
-		interactive := !cmd.Flags().Changed("token") || !cmd.Flags().Changed("project")
+			interactive := !cmd.Flags().Changed("set-token") || !cmd.Flags().Changed("set-project")
 
-		if !cmd.Flags().Changed("url") && interactive {
-			urlValue = prompt("API endpoint URL", configInitUrl)
-		}
-		if !cmd.Flags().Changed("token") {
-			tokenValue = promptSecret("API token")
-		}
-		if tokenValue == "" {
-			return fmt.Errorf("token is required")
-		}
+			if !cmd.Flags().Changed("url") && interactive {
+				urlValue = prompt(cmd, "API endpoint URL", url)
+			}
+			if !cmd.Flags().Changed("set-token") {
+				tokenValue = promptSecret(cmd, "API token")
+			}
+			if tokenValue == "" {
+				return fmt.Errorf("token is required")
+			}
 
-		// Validate credentials.
-		fmt.Fprint(os.Stderr, "Verifying credentials... ")
-		if _, err := api.NewClient(urlValue, tokenValue); err != nil {
-			fmt.Fprintln(os.Stderr, "failed.")
-			return fmt.Errorf("verify credentials: %w", err)
-		}
-		fmt.Fprintln(os.Stderr, "done.")
+			// Validate credentials.
+			fmt.Fprint(cmd.ErrOrStderr(), "Verifying credentials... ")
+			if _, err := api.NewClientContext(cmd.Context(), urlValue, tokenValue); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), "failed.")
+				return fmt.Errorf("verify credentials: %w", err)
+			}
+			fmt.Fprintln(cmd.ErrOrStderr(), "done.")
 
-		if !cmd.Flags().Changed("project") {
-			projectValue = prompt("Project", "")
-		}
-		if projectValue == "" {
-			return fmt.Errorf("project is required")
-		}
+			if !cmd.Flags().Changed("set-project") {
+				projectValue = prompt(cmd, "Project", "")
+			}
+			if projectValue == "" {
+				return fmt.Errorf("project is required")
+			}
 
-		if !cmd.Flags().Changed("name") && interactive {
-			nameValue = prompt("Context name", configInitName)
-		}
+			if !cmd.Flags().Changed("name") && interactive {
+				nameValue = prompt(cmd, "Context name", name)
+			}
 
-		// Check for existing context with the same name.
-		_, endpointExists := globalConfiguration.Endpoints[nameValue]
-		_, contextExists := globalConfiguration.Contexts[nameValue]
-		if endpointExists || contextExists {
-			if !configInitForce {
-				if interactive {
-					answer := prompt(fmt.Sprintf("Context %q already exists. Overwrite?", nameValue), "n")
-					answer = strings.ToLower(strings.TrimSpace(answer))
-					if answer != "y" && answer != "yes" {
-						return fmt.Errorf("aborted")
+			// Check for existing context with the same name.
+			_, endpointExists := c.config.Endpoints[nameValue]
+			_, contextExists := c.config.Contexts[nameValue]
+			if endpointExists || contextExists {
+				if !force {
+					if interactive {
+						answer := prompt(cmd, fmt.Sprintf("Context %q already exists. Overwrite?", nameValue), "n")
+						answer = strings.ToLower(strings.TrimSpace(answer))
+						if answer != "y" && answer != "yes" {
+							return fmt.Errorf("aborted")
+						}
+					} else {
+						return fmt.Errorf("context %q already exists (use --force to overwrite)", nameValue)
 					}
-				} else {
-					return fmt.Errorf("context %q already exists (use --force to overwrite)", nameValue)
 				}
 			}
-		}
 
-		// Write config.
-		if globalConfiguration.Endpoints == nil {
-			globalConfiguration.Endpoints = map[string]config.APIEndpoint{}
-		}
-		if globalConfiguration.Contexts == nil {
-			globalConfiguration.Contexts = map[string]config.CLIContext{}
-		}
-		globalConfiguration.Endpoints[nameValue] = config.APIEndpoint{
-			Token: tokenValue,
-			URL:   urlValue,
-		}
-		globalConfiguration.Contexts[nameValue] = config.CLIContext{
-			Endpoint: nameValue,
-			Project:  projectValue,
-		}
-		globalConfiguration.ActiveContext = nameValue
-		if err := globalConfiguration.Write(); err != nil {
-			return fmt.Errorf("write config: %w", err)
-		}
-		fmt.Fprintf(os.Stderr, "Configuration written to %s.\n", globalConfiguration.Path)
-		return nil
-	},
-}
-
-func initConfigCmd() {
-	configInitCmd.Flags().StringVar(&configInitUrl, "url", "https://api.valar.dev/v2", "API endpoint URL")
-	configInitCmd.Flags().StringVar(&configInitToken, "token", "", "API token")
-	configInitCmd.Flags().StringVar(&configInitProject, "project", "", "Project name")
-	configInitCmd.Flags().StringVar(&configInitName, "name", "default", "Context name")
-	configInitCmd.Flags().BoolVar(&configInitForce, "force", false, "Overwrite existing context")
-	configEndpointSetCmd.Flags().StringVar(&configEndpointSetToken, "token", "", "Token to use")
-	configEndpointSetCmd.Flags().StringVar(&configEndpointSetUrl, "url", "", "URL the API can be reached on")
-	configEndpointCmd.AddCommand(configEndpointSetCmd)
-	configEndpointCmd.AddCommand(configEndpointRemoveCmd)
-	configContextSetCmd.Flags().StringVar(&configContextSetProject, "project", "", "Project to use")
-	configContextSetCmd.Flags().StringVar(&configContextSetEndpoint, "endpoint", "", "API endpoint")
-	configContextCmd.AddCommand(configContextUseCmd)
-	configContextCmd.AddCommand(configContextSetCmd)
-	configContextCmd.AddCommand(configContextRemoveCmd)
-	configCmd.AddCommand(configInitCmd)
-	configCmd.AddCommand(configContextCmd)
-	configCmd.AddCommand(configEndpointCmd)
-	configCmd.AddCommand(configViewCmd)
-	rootCmd.AddCommand(configCmd)
+			// Write config.
+			if c.config.Endpoints == nil {
+				c.config.Endpoints = map[string]config.APIEndpoint{}
+			}
+			if c.config.Contexts == nil {
+				c.config.Contexts = map[string]config.CLIContext{}
+			}
+			c.config.Endpoints[nameValue] = config.APIEndpoint{
+				URL:              urlValue,
+				CredentialHelper: config.CredentialHelper(helper),
+			}
+			if err := c.config.SetToken(cmd.Context(), nameValue, tokenValue); err != nil {
+				return fmt.Errorf("store token: %w", err)
+			}
+			c.config.Contexts[nameValue] = config.CLIContext{
+				Endpoint: nameValue,
+				Project:  projectValue,
+			}
+			c.config.ActiveContext = nameValue
+			if err := c.config.Write(); err != nil {
+				return fmt.Errorf("write config: %w", err)
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "Configuration written to %s.\n", c.config.Path)
+			return nil
+		},
+	}
+	configInitCmd.Flags().StringVar(&url, "url", "https://api.valar.dev/v2", "API endpoint URL")
+	configInitCmd.Flags().StringVar(&token, "set-token", "", "API token")
+	configInitCmd.Flags().StringVar(&project, "set-project", "", "Project name")
+	configInitCmd.Flags().StringVar(&name, "name", "default", "Context name")
+	configInitCmd.Flags().BoolVar(&force, "force", false, "Overwrite existing context")
+	configInitCmd.Flags().StringVar(&helper, "credential-helper", "", "Credential helper keeping the token instead of the configuration file")
+	return configInitCmd
 }
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCredentialHelper(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("credential helpers are shell scripts")
+	}
+	setupWorkspace(t, "secret")
+	bin := t.TempDir()
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	helper := `#!/bin/sh
+store="$(dirname "$0")/store"
+echo "helper $1" >&2
+case "$1" in
+get) [ -f "$store" ] && echo "password=$(cat "$store")" ;;
+store) while IFS='=' read -r key value; do [ "$key" = password ] && printf %s "$value" > "$store"; done; exit 0 ;;
+erase) rm -f "$store" ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(bin, "valar-credential-test"), []byte(helper), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, stderr, code := runCommand(t, "config", "endpoint", "migrate-credentials", "--credential-helper", "test"); code != 0 {
+		t.Fatalf("migrate-credentials exited with %d: %s", code, stderr)
+	}
+	data, err := os.ReadFile(os.Getenv("VALARCONFIG"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") || !strings.Contains(string(data), "credentialHelper: test") {
+		t.Errorf("config after migration:\n%s", data)
+	}
+	// The output of the helper goes to the error stream of the command.
+	if _, stderr, code := runCommand(t, "service", "list", "-o", "json"); code != 0 || !strings.Contains(stderr, "helper get") {
+		t.Errorf("service list with token from helper exited with %d: %s", code, stderr)
+	}
+
+	// Plaintext tokens are masked unless asked for.
+	if _, _, code := runCommand(t, "config", "endpoint", "set", "default", "--credential-helper", "", "--set-token", "0123456789abcdef"); code != 0 {
+		t.Fatalf("endpoint set exited with %d", code)
+	}
+	stdout, _, _ := runCommand(t, "config", "endpoint")
+	if strings.Contains(stdout, "0123456789abcdef") || !strings.Contains(stdout, "********cdef") {
+		t.Errorf("config endpoint = %q, want the token masked", stdout)
+	}
+	stdout, _, _ = runCommand(t, "config", "view", "--show-tokens")
+	if !strings.Contains(stdout, "0123456789abcdef") {
+		t.Errorf("config view --show-tokens = %q, want the token", stdout)
+	}
+}
+
+func TestConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	shared, personal := filepath.Join(dir, "shared"), filepath.Join(dir, "personal")
+	// The shared file uses the layout predating contexts.
+	if err := os.WriteFile(shared, []byte("endpoint: https://api.example.com\ntoken: secret\nproject: acme\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(personal, []byte("contexts:\n  staging:\n    endpoint: default\n    project: acme-staging\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VALARCONFIG", shared+";"+personal)
+
+	for _, args := range [][]string{
+		{"config", "context", "set", "staging", "--set-project", "acme-test"},
+		{"config", "context", "use", "staging"},
+		{"config", "endpoint", "set", "local", "--url", "http://localhost:7420"},
+	} {
+		if _, stderr, code := runCommand(t, args...); code != 0 {
+			t.Fatalf("valar %s exited with %d: %s", strings.Join(args, " "), code, stderr)
+		}
+	}
+	want := map[string]string{
+		shared:   "version: \"1\"\nactiveContext: staging\nendpoints:\n  default:\n    token: secret\n    url: https://api.example.com\ncontexts:\n  default:\n    endpoint: default\n    project: acme\n",
+		personal: "version: \"1\"\nactiveContext: \"\"\nendpoints:\n  local:\n    url: http://localhost:7420\ncontexts:\n  staging:\n    endpoint: default\n    project: acme-test\n",
+	}
+	for path, content := range want {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s =\n%s\nwant\n%s", filepath.Base(path), data, content)
+		}
+		if info, err := os.Stat(path); err == nil && runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+			t.Errorf("%s has mode %v, want 0600", filepath.Base(path), info.Mode().Perm())
+		}
+	}
+
+}
+
+func TestConfigContextSetOverrides(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte("activeContext: staging\nendpoints:\n  default:\n    url: https://api.example.com\ncontexts:\n  staging:\n    endpoint: default\n    project: acme-staging\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VALARCONFIG", path)
+
+	// The global overrides leave the stored context alone, the --set-* flags
+	// change it.
+	for _, args := range [][]string{
+		{"config", "context", "set", "staging", "--project", "other", "--endpoint", "http://localhost:7420", "--profile", "preview"},
+		{"config", "context", "set", "staging", "--set-project", "acme-test", "--set-profile", "production", "--project", "other"},
+	} {
+		if _, stderr, code := runCommand(t, args...); code != 0 {
+			t.Fatalf("valar %s exited with %d: %s", strings.Join(args, " "), code, stderr)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "  staging:\n    endpoint: default\n    project: acme-test\n    profile: production\n"; !strings.HasSuffix(string(data), want) {
+		t.Errorf("config =\n%s\nwant the context to end with\n%s", data, want)
+	}
+}
+
+func TestConfigOrigin(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	shared, personal := filepath.Join(dir, "shared"), filepath.Join(dir, "personal")
+	if err := os.WriteFile(shared, []byte("activeContext: default\nendpoints:\n  default:\n    url: https://api.example.com\ncontexts:\n  default:\n    endpoint: default\n    project: acme\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(personal, []byte("contexts:\n  staging:\n    endpoint: default\n    project: acme-staging\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VALARCONFIG", shared+";"+personal)
+
+	stdout, _, _ := runCommand(t, "config", "view", "--show-origin")
+	for _, want := range []string{
+		"activeContext: default # " + shared + ":1",
+		"  default: # " + shared + ":3",
+		"  default: # " + shared + ":6",
+		"  staging: # " + personal + ":2",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("config view --show-origin = %q, want %q", stdout, want)
+		}
+	}
+
+	// Moving a context removes it from the file it came from.
+	if _, stderr, code := runCommand(t, "config", "context", "set", "default", "--file", personal); code != 0 {
+		t.Fatalf("config context set --file exited with %d: %s", code, stderr)
+	}
+	stdout, _, _ = runCommand(t, "config", "view", "--show-origin")
+	if want := "  default: # " + personal + ":"; !strings.Contains(stdout, want) {
+		t.Errorf("config view --show-origin = %q, want %q", stdout, want)
+	}
+	if data, _ := os.ReadFile(shared); strings.Contains(string(data), "project: acme") {
+		t.Errorf("%s still defines the moved context:\n%s", shared, data)
+	}
+	if _, stderr, code := runCommand(t, "config", "endpoint", "set", "default", "--file", filepath.Join(dir, "other")); code == 0 || !strings.Contains(stderr, "is not one of the configuration files") {
+		t.Errorf("config endpoint set --file with an unknown file exited with %d: %s", code, stderr)
+	}
+}
@@ -3,8 +3,7 @@ package cmd
 import (
 	"errors"
 	"fmt"
-	"net/http"
-	"os"
+	"io"
 	"strings"
 	"text/tabwriter"
 
@@ -13,62 +12,86 @@ import (
 	"github.com/valar/cli/config"
 )
 
-var (
-	cronService string
-
-	cronCmd = &cobra.Command{
+func newCronCmd(c *cli) *cobra.Command {
+	var service string
+	cronCmd := &cobra.Command{
 		Use:   "cron",
 		Short: "Manage scheduled invocations of a service.",
 	}
+	cronCmd.PersistentFlags().StringVarP(&service, "service", "s", "", "The service to manage cron schedules for")
+	cronCmd.RegisterFlagCompletionFunc("service", c.completeServices())
+	cronCmd.AddCommand(
+		newCronListCmd(c, &service),
+		newCronSetCmd(c, &service),
+		newCronTriggerCmd(c, &service),
+		newCronDeleteCmd(c, &service),
+		newCronInspectCmd(c, &service),
+	)
+	return cronCmd
+}
 
-	cronListCmd = &cobra.Command{
+func newCronListCmd(c *cli, service *string) *cobra.Command {
+	return &cobra.Command{
 		Use:   "list",
 		Short: "List all cron schedules for a service.",
-		Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &cronService, globalConfiguration)
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, service, c.config)
 			if err != nil {
 				return err
 			}
-			client, err := globalConfiguration.APIClient()
+			client, err := c.apiClient(cmd.Context())
 			if err != nil {
 				return err
 			}
-			schedules, err := client.ListSchedules(cfg.Project(), cfg.Service())
+			schedules, err := client.ListSchedulesContext(cmd.Context(), cfg.Project(), cfg.Service())
 			if err != nil {
 				return err
 			}
-			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
-			fmt.Fprintln(tw, "NAME\tTIMESPEC\tPATH\tSTATUS")
-			for _, sched := range schedules {
-				fmt.Fprintln(tw, strings.Join([]string{sched.Name, sched.Timespec, sched.Path, colorize(sched.Status)}, "\t"))
-			}
-			tw.Flush()
-			return nil
+			return c.printResult(cmd.OutOrStdout(), schedules, func(w io.Writer, wide bool) {
+				tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+				if wide {
+					fmt.Fprintln(tw, "NAME\tTIMESPEC\tPATH\tSTATUS\tPAYLOAD")
+				} else {
+					fmt.Fprintln(tw, "NAME\tTIMESPEC\tPATH\tSTATUS")
+				}
+				for _, sched := range schedules {
+					row := []string{sched.Name, sched.Timespec, sched.Path, colorize(sched.Status)}
+					if wide {
+						row = append(row, sched.Payload)
+					}
+					fmt.Fprintln(tw, strings.Join(row, "\t"))
+				}
+				tw.Flush()
+			})
 		}),
 	}
+}
 
-	cronSetPayload       string
-	cronSetPath          string
-	cronSetEnabledCount  int
-	cronSetDisabledCount int
-	cronSetCmd           = &cobra.Command{
-		Use:   "set [--payload payload] [--path path] [--enable|--disable] name [timespec]",
-		Short: "Set a service invocation schedule.",
-		Args:  cobra.RangeArgs(1, 2),
-		Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &cronService, globalConfiguration)
+func newCronSetCmd(c *cli, service *string) *cobra.Command {
+	var (
+		payload       string
+		path          string
+		enabledCount  int
+		disabledCount int
+	)
+	cronSetCmd := &cobra.Command{
+		Use:               "set [--payload payload] [--path path] [--enable|--disable] name [timespec]",
+		Short:             "Set a service invocation schedule.",
+		Args:              cobra.RangeArgs(1, 2),
+		ValidArgsFunction: firstArg(c.completeSchedules(service)),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, service, c.config)
 			if err != nil {
 				return err
 			}
-			client, err := globalConfiguration.APIClient()
+			client, err := c.apiClient(cmd.Context())
 			if err != nil {
 				return err
 			}
 			// Attempt to fetch the schedule already present.
-			var apiError api.Error
 			schedule := api.Schedule{}
-			existing, err := client.InspectSchedule(cfg.Project(), cfg.Service(), args[0])
-			if err != nil && !(errors.As(err, &apiError) && apiError.StatusCode == http.StatusNotFound) {
+			existing, err := client.InspectScheduleContext(cmd.Context(), cfg.Project(), cfg.Service(), args[0])
+			if err != nil && !errors.Is(err, api.ErrNotFound) {
 				return err
 			}
 			if existing != nil {
@@ -77,13 +100,13 @@ var (
 					schedule.Timespec = args[1]
 				}
 				if cmd.Flags().Changed("payload") {
-					schedule.Payload = cronSetPayload
+					schedule.Payload = payload
 				}
 				if cmd.Flags().Changed("path") {
-					schedule.Path = cronSetPath
+					schedule.Path = path
 				}
 				if cmd.Flags().Changed("enabled") || cmd.Flags().Changed("disabled") {
-					if cronSetEnabledCount-cronSetDisabledCount >= 0 {
+					if enabledCount-disabledCount >= 0 {
 						schedule.Status = "enabled"
 					} else {
 						schedule.Status = "disabled"
@@ -91,109 +114,119 @@ var (
 				}
 			} else {
 				if len(args) != 2 {
-					fmt.Fprintln(os.Stderr, "Timespec must be specified when setting a schedule for the first time.")
-					os.Exit(1)
+					return fmt.Errorf("timespec must be specified when setting a schedule for the first time")
 				}
 				schedule = api.Schedule{
 					Name:     args[0],
 					Timespec: args[1],
-					Path:     cronSetPath,
-					Payload:  cronSetPayload,
+					Path:     path,
+					Payload:  payload,
 				}
-				if cronSetEnabledCount-cronSetDisabledCount >= 0 {
+				if enabledCount-disabledCount >= 0 {
 					schedule.Status = "enabled"
 				} else {
 					schedule.Status = "disabled"
 				}
 			}
-			if err := client.SetSchedule(cfg.Project(), cfg.Service(), schedule); err != nil {
+			if err := client.SetScheduleContext(cmd.Context(), cfg.Project(), cfg.Service(), schedule); err != nil {
 				return err
 			}
 			return nil
 		}),
 	}
+	cronSetCmd.Flags().StringVar(&path, "path", "/", "The service path to send a request to")
+	cronSetCmd.Flags().StringVar(&payload, "payload", "", "The body payload to send in a request")
+	cronSetCmd.Flags().CountVar(&enabledCount, "enabled", "Enables the specified schedule")
+	cronSetCmd.Flags().CountVar(&disabledCount, "disabled", "Disables the specified schedule to prevent it from triggering")
+	return cronSetCmd
+}
 
-	cronTriggerCmd = &cobra.Command{
-		Use:   "trigger schedule",
-		Short: "Manually triggers a scheduled invocation.",
-		Args:  cobra.ExactArgs(1),
-		Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &cronService, globalConfiguration)
+func newCronTriggerCmd(c *cli, service *string) *cobra.Command {
+	return &cobra.Command{
+		Use:               "trigger schedule",
+		Short:             "Manually triggers a scheduled invocation.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(c.completeSchedules(service)),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, service, c.config)
 			if err != nil {
 				return err
 			}
-			client, err := globalConfiguration.APIClient()
+			client, err := c.apiClient(cmd.Context())
 			if err != nil {
 				return err
 			}
-			if err := client.TriggerSchedule(cfg.Project(), cfg.Service(), args[0]); err != nil {
+			if err := client.TriggerScheduleContext(cmd.Context(), cfg.Project(), cfg.Service(), args[0]); err != nil {
 				return err
 			}
 			return nil
 		}),
 	}
-	cronDeleteCmd = &cobra.Command{
-		Use:   "delete schedule",
-		Short: "Delete a service invocation schedule.",
-		Args:  cobra.ExactArgs(1),
-		Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &cronService, globalConfiguration)
+}
+
+func newCronDeleteCmd(c *cli, service *string) *cobra.Command {
+	return &cobra.Command{
+		Use:               "delete schedule",
+		Short:             "Delete a service invocation schedule.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(c.completeSchedules(service)),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, service, c.config)
 			if err != nil {
 				return err
 			}
-			client, err := globalConfiguration.APIClient()
+			client, err := c.apiClient(cmd.Context())
 			if err != nil {
 				return err
 			}
-			if err := client.DeleteSchedule(cfg.Project(), cfg.Service(), args[0]); err != nil {
+			if err := client.DeleteScheduleContext(cmd.Context(), cfg.Project(), cfg.Service(), args[0]); err != nil {
 				return err
 			}
 			return nil
 		}),
 	}
-	cronInspectCmd = &cobra.Command{
-		Use:   "inspect schedule",
-		Short: "Inspect the details of a service schedule.",
-		Args:  cobra.ExactArgs(1),
-		Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &cronService, globalConfiguration)
+}
+
+func newCronInspectCmd(c *cli, service *string) *cobra.Command {
+	return &cobra.Command{
+		Use:               "inspect schedule",
+		Short:             "Inspect the details of a service schedule.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(c.completeSchedules(service)),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, service, c.config)
 			if err != nil {
 				return err
 			}
-			client, err := globalConfiguration.APIClient()
+			client, err := c.apiClient(cmd.Context())
 			if err != nil {
 				return err
 			}
-			details, err := client.InspectSchedule(cfg.Project(), cfg.Service(), args[0])
+			details, err := client.InspectScheduleContext(cmd.Context(), cfg.Project(), cfg.Service(), args[0])
 			if err != nil {
 				return err
 			}
-			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
-			fmt.Fprintln(tw, "Name:\t", details.Schedule.Name)
-			fmt.Fprintln(tw, "Timespec:\t", details.Schedule.Timespec)
-			fmt.Fprintln(tw, "Path:\t", details.Schedule.Path)
-			fmt.Fprintln(tw, "Payload:\t", details.Schedule.Payload)
-			fmt.Fprintln(tw, "Status:\t", colorize(details.Schedule.Status))
-			if details.LastRun == nil {
-				fmt.Fprintln(tw, "Last Run:\t", "-")
-			} else {
-				fmt.Fprintln(tw, "Last Run:\t", "")
-				fmt.Fprintln(tw, "  Start:\t", details.LastRun.StartTime)
-				fmt.Fprintln(tw, "  End:\t", details.LastRun.EndTime)
-				fmt.Fprintln(tw, "  Status:\t", colorize(details.LastRun.Status))
-			}
-			tw.Flush()
-			return nil
+			return c.printResult(cmd.OutOrStdout(), details, func(w io.Writer, wide bool) {
+				tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+				fmt.Fprintln(tw, "Name:\t", details.Schedule.Name)
+				fmt.Fprintln(tw, "Timespec:\t", details.Schedule.Timespec)
+				fmt.Fprintln(tw, "Path:\t", details.Schedule.Path)
+				fmt.Fprintln(tw, "Payload:\t", details.Schedule.Payload)
+				fmt.Fprintln(tw, "Status:\t", colorize(details.Schedule.Status))
+				if details.LastRun == nil {
+					fmt.Fprintln(tw, "Last Run:\t", "-")
+				} else {
+					fmt.Fprintln(tw, "Last Run:\t", "")
+					fmt.Fprintln(tw, "  Start:\t", details.LastRun.StartTime)
+					fmt.Fprintln(tw, "  End:\t", details.LastRun.EndTime)
+					fmt.Fprintln(tw, "  Status:\t", colorize(details.LastRun.Status))
+					if wide {
+						fmt.Fprintln(tw, "  ID:\t", details.LastRun.ID)
+						fmt.Fprintln(tw, "  Triggered By:\t", details.LastRun.TriggerSource)
+					}
+				}
+				tw.Flush()
+			})
 		}),
 	}
-)
-
-func initCronCmd() {
-	rootCmd.AddCommand(cronCmd)
-	cronCmd.PersistentFlags().StringVarP(&cronService, "service", "s", "", "The service to manage cron schedules for")
-	cronCmd.AddCommand(cronListCmd, cronSetCmd, cronTriggerCmd, cronDeleteCmd, cronInspectCmd)
-	cronSetCmd.Flags().StringVar(&cronSetPath, "path", "/", "The service path to send a request to")
-	cronSetCmd.Flags().StringVar(&cronSetPayload, "payload", "", "The body payload to send in a request")
-	cronSetCmd.Flags().CountVar(&cronSetEnabledCount, "enabled", "Enables the specified schedule")
-	cronSetCmd.Flags().CountVar(&cronSetDisabledCount, "disabled", "Disables the specified schedule to prevent it from triggering")
 }
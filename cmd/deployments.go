@@ -1,87 +1,105 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"io"
 	"sort"
 	"strconv"
 	"strings"
 
-	"github.com/dustin/go-humanize"
 	"github.com/juju/ansiterm"
 	"github.com/spf13/cobra"
 	"github.com/valar/cli/api"
 	"github.com/valar/cli/config"
 )
 
-var deploymentService string
-
-var deploymentCmd = &cobra.Command{
-	Use:     "deployment",
-	Short:   "Manage the deployments of a service.",
-	Aliases: []string{"deploys", "deploy", "d"},
+func newDeploymentCmd(c *cli) *cobra.Command {
+	var service string
+	deploymentCmd := &cobra.Command{
+		Use:     "deployment",
+		Short:   "Manage the deployments of a service.",
+		Aliases: []string{"deploys", "deploy", "d"},
+	}
+	deploymentCmd.PersistentFlags().StringVarP(&service, "service", "s", "", "The service to manage")
+	deploymentCmd.RegisterFlagCompletionFunc("service", c.completeServices())
+	deploymentCmd.AddCommand(
+		newDeploymentListCmd(c, &service),
+		newDeploymentRollbackCmd(c, &service),
+		newDeploymentCreateCmd(c, &service),
+	)
+	return deploymentCmd
 }
 
-var deploymentListCmd = &cobra.Command{
-	Use:     "list",
-	Short:   "List deployments of the service.",
-	Aliases: []string{"l"},
-	Args:    cobra.NoArgs,
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &deploymentService, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		return listDeployments(client, cfg)
-	}),
+func newDeploymentListCmd(c *cli, service *string) *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Short:   "List deployments of the service.",
+		Aliases: []string{"l"},
+		Args:    cobra.NoArgs,
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, service, c.config)
+			if err != nil {
+				return err
+			}
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			return c.listDeployments(cmd.Context(), cmd.OutOrStdout(), client, cfg)
+		}),
+	}
 }
 
-var deploymentCreateCmd = &cobra.Command{
-	Use:   "create [build]",
-	Short: "Deploy the build with the fully given ID.",
-	Args:  cobra.ExactArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &deploymentService, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		return deployBuild(client, cfg, args[0])
-	}),
+func newDeploymentCreateCmd(c *cli, service *string) *cobra.Command {
+	return &cobra.Command{
+		Use:               "create [build]",
+		Short:             "Deploy the build with the fully given ID.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(c.completeBuilds(service)),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, service, c.config)
+			if err != nil {
+				return err
+			}
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			return deployBuild(cmd.Context(), cmd.OutOrStdout(), client, cfg, args[0])
+		}),
+	}
 }
 
-var rollbackDelta int
-
-var deploymentRollbackCmd = &cobra.Command{
-	Use:   "rollback",
-	Short: "Reverse service to the previous deployment.",
-	Args:  cobra.NoArgs,
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &deploymentService, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		return rollbackLatestDeployment(client, cfg)
-	}),
+func newDeploymentRollbackCmd(c *cli, service *string) *cobra.Command {
+	var delta int
+	deploymentRollbackCmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "Reverse service to the previous deployment.",
+		Args:  cobra.NoArgs,
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, service, c.config)
+			if err != nil {
+				return err
+			}
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			return rollbackLatestDeployment(cmd.Context(), cmd.OutOrStdout(), client, cfg, delta)
+		}),
+	}
+	deploymentRollbackCmd.Flags().IntVarP(&delta, "delta", "d", 1, "Number of deployments to roll back")
+	deploymentRollbackCmd.RegisterFlagCompletionFunc("delta", c.completeRollbackDelta(service))
+	return deploymentRollbackCmd
 }
 
-func rollbackLatestDeployment(client *api.Client, cfg config.ServiceConfig) error {
-	deployments, err := client.ListDeployments(cfg.Project(), cfg.Service())
+func rollbackLatestDeployment(ctx context.Context, out io.Writer, client *api.Client, cfg config.ServiceConfig, delta int) error {
+	deployments, err := client.ListDeploymentsContext(ctx, cfg.Project(), cfg.Service())
 	if err != nil {
 		return err
 	}
-	if len(deployments) < rollbackDelta+1 {
+	if len(deployments) < delta+1 {
 		return fmt.Errorf("not enough deployments available")
 	}
 	// Sort by version (desc)
@@ -89,18 +107,18 @@ func rollbackLatestDeployment(client *api.Client, cfg config.ServiceConfig) erro
 		return deployments[i].Version > deployments[j].Version
 	})
 	// Get targeted version identifier
-	deployment, err := client.RollbackDeploy(cfg.Project(), cfg.Service(), &api.RollbackRequest{
-		Version: deployments[rollbackDelta].Version,
+	deployment, err := client.RollbackDeployContext(ctx, cfg.Project(), cfg.Service(), &api.RollbackRequest{
+		Version: deployments[delta].Version,
 	})
 	if err != nil {
 		return err
 	}
-	fmt.Println(deployment.Version)
+	fmt.Fprintln(out, deployment.Version)
 	return nil
 }
 
-func listDeployments(client *api.Client, cfg config.ServiceConfig) error {
-	deployments, err := client.ListDeployments(cfg.Project(), cfg.Service())
+func (c *cli) listDeployments(ctx context.Context, out io.Writer, client *api.Client, cfg config.ServiceConfig) error {
+	deployments, err := client.ListDeploymentsContext(ctx, cfg.Project(), cfg.Service())
 	if err != nil {
 		return err
 	}
@@ -108,24 +126,18 @@ func listDeployments(client *api.Client, cfg config.ServiceConfig) error {
 	sort.Slice(deployments, func(i, j int) bool {
 		return deployments[i].Version < deployments[j].Version
 	})
-	tw := ansiterm.NewTabWriter(os.Stdout, 6, 0, 1, ' ', 0)
-	fmt.Fprintln(tw, "VERSION\tSTATUS\tCREATED\tBUILD\tERROR")
-	for _, d := range deployments {
-		fmt.Fprintln(tw, strings.Join([]string{
-			strconv.FormatInt(d.Version, 10),
-			colorize(d.Status),
-			humanize.Time(d.CreatedAt),
-			d.Build,
-			d.Error,
-		}, "\t"))
-	}
-	tw.Flush()
-	return nil
-}
-
-func initDeploymentsCmd() {
-	deploymentRollbackCmd.Flags().IntVarP(&rollbackDelta, "delta", "d", 1, "Number of deployments to roll back")
-	deploymentCmd.PersistentFlags().StringVarP(&deploymentService, "service", "s", "", "The service to manage")
-	deploymentCmd.AddCommand(deploymentListCmd, deploymentRollbackCmd, deploymentCreateCmd)
-	rootCmd.AddCommand(deploymentCmd)
+	return c.printResult(out, deployments, func(w io.Writer, wide bool) {
+		tw := ansiterm.NewTabWriter(w, 6, 0, 1, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tSTATUS\tCREATED\tBUILD\tERROR")
+		for _, d := range deployments {
+			fmt.Fprintln(tw, strings.Join([]string{
+				strconv.FormatInt(d.Version, 10),
+				colorize(d.Status),
+				c.humanizeTime(d.CreatedAt),
+				d.Build,
+				d.Error,
+			}, "\t"))
+		}
+		tw.Flush()
+	})
 }
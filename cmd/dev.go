@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/spf13/cobra"
+	"github.com/valar/cli/apitest"
+)
+
+func newDevCmd(c *cli) *cobra.Command {
+	devCmd := &cobra.Command{
+		Use:    "dev",
+		Short:  "Tools for developing against the Valar API.",
+		Hidden: true,
+	}
+	devCmd.AddCommand(newDevFakeServerCmd(c))
+	return devCmd
+}
+
+func newDevFakeServerCmd(c *cli) *cobra.Command {
+	var (
+		addr     string
+		token    string
+		user     string
+		projects []string
+	)
+	devFakeServerCmd := &cobra.Command{
+		Use:   "fake-server",
+		Short: "Serve an in-memory fake of the Valar API.",
+		Args:  cobra.NoArgs,
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			if len(projects) == 0 {
+				return fmt.Errorf("at least one project is required")
+			}
+			fake := apitest.NewServer(user)
+			fake.Token = token
+			for _, project := range projects {
+				fake.AddProject(project)
+			}
+			listener, err := net.Listen("tcp", addr)
+			if err != nil {
+				return fmt.Errorf("listen: %w", err)
+			}
+			server := &http.Server{Handler: fake}
+			go func() {
+				<-cmd.Context().Done()
+				server.Close()
+			}()
+			url := "http://" + listener.Addr().String()
+			fmt.Fprintf(cmd.ErrOrStderr(), "Serving fake API on %s, stop with Ctrl-C.\n", url)
+			fmt.Fprintf(cmd.ErrOrStderr(), "Point the CLI at it using: VALARCONFIG=/tmp/valar-dev valar config init --url %s --set-project %s\n", url, projects[0])
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		}),
+	}
+	devFakeServerCmd.Flags().StringVar(&addr, "addr", "127.0.0.1:7420", "Address to listen on")
+	devFakeServerCmd.Flags().StringVar(&token, "token", "", "Token clients have to present, any token is accepted if empty")
+	devFakeServerCmd.Flags().StringVar(&user, "user", "dev", "Name of the authenticated user")
+	devFakeServerCmd.Flags().StringSliceVar(&projects, "project", []string{"dev"}, "Projects to create on startup")
+	return devFakeServerCmd
+}
@@ -0,0 +1,370 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/juju/ansiterm"
+	"github.com/spf13/cobra"
+	"github.com/valar/cli/api"
+	"github.com/valar/cli/config"
+	"github.com/valar/cli/util"
+)
+
+// Results of the checks of valar doctor.
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// Thresholds of the clock skew between the local machine and the endpoint.
+const (
+	clockSkewWarning = 30 * time.Second
+	clockSkewFailure = 5 * time.Minute
+)
+
+// doctorCheck is the result of one of the checks of valar doctor.
+type doctorCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	// LatencyMS is the round-trip time of checks talking to the endpoint.
+	LatencyMS int64 `json:"latencyMs,omitempty"`
+}
+
+// doctor runs the checks in order, later ones building on the findings of
+// earlier ones.
+type doctor struct {
+	c      *cli
+	checks []doctorCheck
+
+	cfg     *config.CLIConfig
+	fatal   string
+	url     string
+	status  *api.EndpointStatus
+	user    *api.UserInfo
+	service *config.ServiceConfigYAML
+}
+
+func newDoctorCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose the configuration, credentials and connectivity.",
+		Long: `Diagnose the configuration, credentials and connectivity.
+
+Checks that the configuration resolves to an endpoint, that the endpoint is
+reachable and accepts the token, that the project and the .valar.yml of the
+current directory are set up correctly and that the local clock is in sync
+with the endpoint. Exits with status 1 if any check fails.`,
+		Args: cobra.NoArgs,
+		// Broken configurations are reported by the checks instead of
+		// preventing the command from running.
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return checkOutputFormat(c.output)
+		},
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			d := &doctor{c: c}
+			d.run(cmd)
+			failed := 0
+			for _, check := range d.checks {
+				if check.Status == checkFail {
+					failed++
+				}
+			}
+			err := c.printResult(cmd.OutOrStdout(), d.checks, func(w io.Writer, wide bool) {
+				tw := ansiterm.NewTabWriter(w, 6, 0, 1, ' ', 0)
+				fmt.Fprintln(tw, "CHECK\tSTATUS\tDETAILS")
+				for _, check := range d.checks {
+					fmt.Fprintf(tw, "%s\t%s\t%s\n", check.Name, check.Status, check.Message)
+				}
+				tw.Flush()
+			})
+			if err != nil {
+				return err
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d checks failed", failed, len(d.checks))
+			}
+			return nil
+		}),
+	}
+}
+
+func (d *doctor) run(cmd *cobra.Command) {
+	ctx := cmd.Context()
+	d.checkConfig(cmd)
+	d.checkContext()
+	d.checkEndpoint(ctx)
+	d.checkToken(ctx)
+	d.checkProject()
+	d.checkServiceConfig()
+	d.checkIgnore()
+	d.checkClock()
+}
+
+func (d *doctor) report(name, status, format string, args ...interface{}) {
+	d.checks = append(d.checks, doctorCheck{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+}
+
+func (d *doctor) checkConfig(cmd *cobra.Command) {
+	cfg, err := d.c.loadConfig()
+	if err != nil {
+		d.report("config", checkFail, "%v", err)
+		return
+	}
+	d.c.applyFlags(cmd, cfg)
+	// Record missing values instead of exiting, the checks report them.
+	cfg.Fatal = func(message string) {
+		d.fatal = message
+	}
+	d.cfg = cfg
+	d.c.config = cfg
+	d.report("config", checkPass, "loaded %s", strings.Join(cfg.Files(), ", "))
+}
+
+func (d *doctor) checkContext() {
+	const name = "context"
+	if d.cfg == nil {
+		d.report(name, checkSkip, "requires a configuration")
+		return
+	}
+	contextName := d.cfg.ContextName()
+	if contextName == "" && d.cfg.Overrides.Endpoint == "" {
+		d.report(name, checkFail, "no context is active, set one up using `valar config init`")
+		return
+	}
+	if _, ok := d.cfg.Contexts[contextName]; !ok && contextName != "" {
+		d.report(name, checkFail, "context %s does not exist", contextName)
+		return
+	}
+	endpoint := d.cfg.EndpointName()
+	if _, ok := d.cfg.Endpoints[endpoint]; endpoint != "" && !ok {
+		d.report(name, checkFail, "context %s refers to endpoint %s, which does not exist", contextName, endpoint)
+		return
+	}
+	d.fatal = ""
+	d.url = d.cfg.Endpoint()
+	if d.fatal != "" {
+		d.report(name, checkFail, "endpoint %s has no URL", endpoint)
+		return
+	}
+	if endpoint == "" {
+		d.report(name, checkPass, "context %s uses %s", contextName, d.url)
+		return
+	}
+	d.report(name, checkPass, "context %s uses endpoint %s at %s", contextName, endpoint, d.url)
+}
+
+func (d *doctor) checkEndpoint(ctx context.Context) {
+	const name = "endpoint"
+	if d.url == "" {
+		d.report(name, checkSkip, "no endpoint is configured")
+		return
+	}
+	// A missing token is reported by the token check.
+	token := d.cfg.Token()
+	status, err := api.ProbeContext(ctx, d.url, token)
+	if status == nil {
+		d.report(name, checkFail, "%s is unreachable: %v", d.url, err)
+		return
+	}
+	d.status = status
+	latency := status.Latency.Round(time.Millisecond)
+	switch {
+	case errors.Is(err, api.ErrUnauthorized):
+		d.report(name, checkWarn, "reachable in %v, but the version cannot be checked without a valid token", latency)
+	case err != nil:
+		d.report(name, checkFail, "responded in %v with an error: %v", latency, err)
+	case status.Version != "v2":
+		d.report(name, checkFail, "reports version %q, but the CLI requires v2", status.Version)
+	default:
+		d.report(name, checkPass, "reports v2, round trip in %v", latency)
+	}
+	d.checks[len(d.checks)-1].LatencyMS = status.Latency.Milliseconds()
+}
+
+func (d *doctor) checkToken(ctx context.Context) {
+	const name = "token"
+	if d.status == nil {
+		d.report(name, checkSkip, "requires a reachable endpoint")
+		return
+	}
+	d.fatal = ""
+	d.cfg.Token()
+	if d.fatal != "" {
+		d.report(name, checkFail, "%s Log in using `valar login`.", d.fatal)
+		return
+	}
+	client, err := d.c.NewClient(ctx, d.cfg)
+	if err != nil {
+		d.report(name, checkFail, "%v", err)
+		return
+	}
+	user, err := client.UserInfoContext(ctx)
+	if err != nil {
+		d.report(name, checkFail, "%v", err)
+		return
+	}
+	d.user = user
+	d.report(name, checkPass, "authenticated as %s", user.Name)
+}
+
+func (d *doctor) checkProject() {
+	const name = "project"
+	if d.user == nil {
+		d.report(name, checkSkip, "requires an accepted token")
+		return
+	}
+	project := d.cfg.Overrides.Project
+	if project == "" {
+		project = d.cfg.Contexts[d.cfg.ContextName()].Project
+	}
+	switch {
+	case project == "":
+		d.report(name, checkWarn, "the context has no project, commands rely on .valar.yml or --project")
+	case !slices.Contains(d.user.Projects, project):
+		d.report(name, checkFail, "%s is not one of the projects of %s (%s)", project, d.user.Name, strings.Join(d.user.Projects, ", "))
+	default:
+		d.report(name, checkPass, "%s is accessible", project)
+	}
+}
+
+func (d *doctor) checkServiceConfig() {
+	const name = "service"
+	var service config.ServiceConfigYAML
+	err := service.ReadFromFile(functionConfiguration)
+	if errors.Is(err, os.ErrNotExist) {
+		wd, _ := os.Getwd()
+		d.report(name, checkSkip, "no %s in %s or its parent directories", functionConfiguration, wd)
+		return
+	} else if err != nil {
+		d.report(name, checkFail, "%v", err)
+		return
+	}
+	data, err := os.ReadFile(service.FilePath())
+	if err != nil {
+		d.report(name, checkFail, "%v", err)
+		return
+	}
+	problems := config.ValidateServiceConfig(service.FilePath(), data)
+	project := func(svc config.ServiceConfigYAML) string {
+		if d.cfg != nil && d.cfg.Overrides.Project != "" {
+			return d.cfg.Overrides.Project
+		}
+		return svc.Project
+	}
+	if entries := service.ServiceEntries(); len(entries) > 0 {
+		for _, entry := range entries {
+			if err := api.VerifyNames(project(entry), entry.Service); err != nil {
+				d.report(name, checkFail, "%s: service %q: bad naming scheme: %v", service.FilePath(), entry.Service, err)
+				return
+			}
+		}
+		service = service.SelectService("")
+		if service.Service == "" {
+			if d.reportProblems(name, problems) {
+				return
+			}
+			d.report(name, checkPass, "%s lists %d services, none of which contains the working directory", service.FilePath(), len(entries))
+			return
+		}
+	}
+	d.service = &service
+	if d.reportProblems(name, problems) {
+		return
+	}
+	if err := api.VerifyNames(project(service), service.Service); err != nil {
+		d.report(name, checkFail, "%s: bad naming scheme: %v", service.FilePath(), err)
+		return
+	}
+	if d.user != nil && !slices.Contains(d.user.Projects, project(service)) {
+		d.report(name, checkWarn, "%s deploys %s/%s, but %s is not one of the projects of %s", service.FilePath(), project(service), service.Service, project(service), d.user.Name)
+		return
+	}
+	d.report(name, checkPass, "%s deploys %s/%s", service.FilePath(), project(service), service.Service)
+}
+
+// reportProblems reports the first of the problems found by validating the
+// service configuration, if any.
+func (d *doctor) reportProblems(name string, problems []config.Problem) bool {
+	if len(problems) == 0 {
+		return false
+	}
+	d.report(name, checkFail, "%s (%d problems in total, list them using `valar service validate`)", problems[0], len(problems))
+	return true
+}
+
+func (d *doctor) checkIgnore() {
+	const name = "ignore"
+	if d.service == nil {
+		d.report(name, checkSkip, "no service is selected")
+		return
+	}
+	if d.service.Build == nil {
+		d.report(name, checkSkip, "%s configures no build", d.service.FilePath())
+		return
+	}
+	// Pushes archive the source directory of a service of a monorepo and
+	// the working directory otherwise.
+	root := d.service.Dir()
+	if root == "" {
+		var err error
+		if root, err = os.Getwd(); err != nil {
+			d.report(name, checkFail, "locating working directory: %v", err)
+			return
+		}
+	}
+	ignore, err := util.NewIgnorer(root, d.service.Build.Ignore, d.service.Build.Gitignore)
+	if err != nil {
+		d.report(name, checkFail, "%v", err)
+		return
+	}
+	pushed := 0
+	err = util.WalkSource(root, ignore, func(path, rel string, info os.FileInfo) error {
+		if !info.IsDir() {
+			pushed++
+		}
+		return nil
+	})
+	switch {
+	case err != nil:
+		d.report(name, checkFail, "walking %s: %v", root, err)
+	case pushed == 0:
+		d.report(name, checkFail, "the ignore rules exclude every file in %s", root)
+	default:
+		d.report(name, checkPass, "%d files are pushed", pushed)
+	}
+}
+
+func (d *doctor) checkClock() {
+	const name = "clock"
+	if d.status == nil {
+		d.report(name, checkSkip, "requires a reachable endpoint")
+		return
+	}
+	if d.status.Date.IsZero() {
+		d.report(name, checkSkip, "the endpoint did not report its time")
+		return
+	}
+	skew := d.c.Now().Sub(d.status.Date).Round(time.Second)
+	direction := "ahead of"
+	if skew < 0 {
+		skew, direction = -skew, "behind"
+	}
+	switch {
+	case skew >= clockSkewFailure:
+		d.report(name, checkFail, "the local clock is %v %s the endpoint, tokens may be considered expired", skew, direction)
+	case skew >= clockSkewWarning:
+		d.report(name, checkWarn, "the local clock is %v %s the endpoint", skew, direction)
+	default:
+		d.report(name, checkPass, "in sync with the endpoint")
+	}
+}
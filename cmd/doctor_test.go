@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDoctor(t *testing.T) {
+	doctor := func(t *testing.T) (map[string]string, int) {
+		t.Helper()
+		stdout, stderr, code := runCommand(t, "doctor", "-o", "json")
+		var checks []doctorCheck
+		if err := json.Unmarshal([]byte(stdout), &checks); err != nil {
+			t.Fatalf("doctor printed %q (%s): %v", stdout, stderr, err)
+		}
+		statuses := map[string]string{}
+		for _, check := range checks {
+			statuses[check.Name] = check.Status
+		}
+		return statuses, code
+	}
+	expect := func(t *testing.T, statuses map[string]string, want map[string]string) {
+		t.Helper()
+		for name, status := range want {
+			if statuses[name] != status {
+				t.Errorf("check %s = %s, want %s (%v)", name, statuses[name], status, statuses)
+			}
+		}
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		setupWorkspace(t, "secret")
+		statuses, code := doctor(t)
+		expect(t, statuses, map[string]string{"config": "pass", "context": "pass", "endpoint": "pass", "token": "pass", "project": "pass", "service": "pass", "ignore": "pass", "clock": "pass"})
+		if code != 0 {
+			t.Errorf("doctor exited with %d", code)
+		}
+	})
+	t.Run("invalid token", func(t *testing.T) {
+		setupWorkspace(t, "wrong")
+		statuses, code := doctor(t)
+		expect(t, statuses, map[string]string{"endpoint": "warn", "token": "fail", "project": "skip", "clock": "pass"})
+		if code != 1 {
+			t.Errorf("doctor exited with %d, want 1", code)
+		}
+	})
+	t.Run("broken setup", func(t *testing.T) {
+		fake := setupWorkspace(t, "secret")
+		fake.Now = func() time.Time { return testNow.Add(-10 * time.Minute) }
+		if err := os.WriteFile(functionConfiguration, []byte("project: acme\nservice: web-app\nbuild:\n  ignore: [\"*\"]\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		statuses, _ := doctor(t)
+		expect(t, statuses, map[string]string{"service": "fail", "ignore": "fail", "clock": "fail"})
+	})
+}
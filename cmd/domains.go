@@ -2,8 +2,10 @@ package cmd
 
 import (
 	"fmt"
-	"os"
+	"io"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/juju/ansiterm"
 	"github.com/spf13/cobra"
@@ -12,175 +14,215 @@ import (
 	"golang.org/x/exp/slices"
 )
 
-var domainsCmd = &cobra.Command{
-	Use:     "domain",
-	Short:   "Manage custom domains.",
-	Aliases: []string{"dom", "domains"},
+func newDomainCmd(c *cli) *cobra.Command {
+	domainCmd := &cobra.Command{
+		Use:     "domain",
+		Short:   "Manage custom domains.",
+		Aliases: []string{"dom", "domains"},
+	}
+	domainCmd.AddCommand(
+		newDomainListCmd(c),
+		newDomainAddCmd(c),
+		newDomainVerifyCmd(c),
+		newDomainLinkCmd(c),
+		newDomainUnlinkCmd(c),
+		newDomainDeleteCmd(c),
+	)
+	return domainCmd
 }
 
-var domainsListCmd = &cobra.Command{
-	Use:     "list",
-	Short:   "List all domains bound to the active project.",
-	Aliases: []string{"l"},
-	Args:    cobra.ExactArgs(0),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		// Attempt to read project from file if possible
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		doms, err := client.ListDomains(cfg.Project())
-		if err != nil {
-			return err
-		}
-		// Sort domains before printing them out
-		slices.SortFunc(doms, func(a, b api.Domain) int { return strings.Compare(a.Domain, b.Domain) })
-		// Print them pretty
-		tw := ansiterm.NewTabWriter(os.Stdout, 6, 0, 1, ' ', 0)
-		fmt.Fprintln(tw, "DOMAIN\tVERIFIED\tSERVICE\tERROR")
-		for _, d := range doms {
-			svc := "<none>"
-			if d.Service != nil {
-				svc = *d.Service
-			}
-			fmt.Fprintf(tw, "%s\t%v\t%s\t%s\n", d.Domain, d.Verified, svc, d.Error)
-		}
-		tw.Flush()
-		return nil
-	}),
-}
-
-var domainsAddCmd = &cobra.Command{
-	Use:   "add [domain]",
-	Short: "Add a new domain to the project.",
-	Args:  cobra.ExactArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		// Attempt to read project from file if possible
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		records, err := client.AddDomain(cfg.Project(), args[0])
-		if err != nil {
-			return err
-		}
-		fmt.Println("Please set the following records (choose one for A/AAAA and CNAME):")
-		fmt.Println()
-		tw := ansiterm.NewTabWriter(os.Stdout, 6, 0, 1, ' ', 0)
-		for rt, rr := range records {
-			fmt.Fprintf(tw, "%s\t%s\n", rt, rr)
-		}
-		tw.Flush()
-		return nil
-	}),
+func newDomainListCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Short:   "List all domains bound to the active project.",
+		Aliases: []string{"l"},
+		Args:    cobra.ExactArgs(0),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			// Attempt to read project from file if possible
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, c.config)
+			if err != nil {
+				return err
+			}
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			doms, err := client.ListDomainsContext(cmd.Context(), cfg.Project())
+			if err != nil {
+				return err
+			}
+			// Sort domains before printing them out
+			slices.SortFunc(doms, func(a, b api.Domain) int { return strings.Compare(a.Domain, b.Domain) })
+			// Print them pretty
+			return c.printResult(cmd.OutOrStdout(), doms, func(w io.Writer, wide bool) {
+				tw := ansiterm.NewTabWriter(w, 6, 0, 1, ' ', 0)
+				if wide {
+					fmt.Fprintln(tw, "DOMAIN\tVERIFIED\tSERVICE\tERROR\tEXPIRES\tTOKEN")
+				} else {
+					fmt.Fprintln(tw, "DOMAIN\tVERIFIED\tSERVICE\tERROR")
+				}
+				for _, d := range doms {
+					svc := "<none>"
+					if d.Service != nil {
+						svc = *d.Service
+					}
+					if wide {
+						fmt.Fprintf(tw, "%s\t%v\t%s\t%s\t%s\t%s\n", d.Domain, d.Verified, svc, d.Error, d.Expiration.Format(time.RFC3339), d.Token)
+					} else {
+						fmt.Fprintf(tw, "%s\t%v\t%s\t%s\n", d.Domain, d.Verified, svc, d.Error)
+					}
+				}
+				tw.Flush()
+			})
+		}),
+	}
 }
 
-var domainsDeleteCmd = &cobra.Command{
-	Use:   "delete [domain]",
-	Short: "Deletes an existing domain from the project.",
-	Args:  cobra.ExactArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		// Attempt to read project from file if possible
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		if err := client.DeleteDomain(cfg.Project(), args[0]); err != nil {
-			return err
-		}
-		return nil
-	}),
+func newDomainAddCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "add [domain]",
+		Short: "Add a new domain to the project.",
+		Args:  cobra.ExactArgs(1),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			// Attempt to read project from file if possible
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, c.config)
+			if err != nil {
+				return err
+			}
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			records, err := client.AddDomainContext(cmd.Context(), cfg.Project(), args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Please set the following records (choose one for A/AAAA and CNAME):")
+			fmt.Fprintln(cmd.OutOrStdout())
+			tw := ansiterm.NewTabWriter(cmd.OutOrStdout(), 6, 0, 1, ' ', 0)
+			types := make([]string, 0, len(records))
+			for rt := range records {
+				types = append(types, rt)
+			}
+			sort.Strings(types)
+			for _, rt := range types {
+				fmt.Fprintf(tw, "%s\t%s\n", rt, records[rt])
+			}
+			tw.Flush()
+			return nil
+		}),
+	}
 }
 
-var domainsVerifyCmd = &cobra.Command{
-	Use:   "verify [domain]",
-	Short: "Verify a newly added domain.",
-	Args:  cobra.ExactArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		// Attempt to read project from file if possible
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		dom, err := client.VerifyDomain(cfg.Project(), args[0])
-		if err != nil {
-			return err
-		}
-		fmt.Println("Verified until", dom.Expiration)
-		return nil
-	}),
+func newDomainDeleteCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:               "delete [domain]",
+		Short:             "Deletes an existing domain from the project.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(c.completeDomains()),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			// Attempt to read project from file if possible
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, c.config)
+			if err != nil {
+				return err
+			}
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if err := client.DeleteDomainContext(cmd.Context(), cfg.Project(), args[0]); err != nil {
+				return err
+			}
+			return nil
+		}),
+	}
 }
 
-var domainsLinkAllowInsecureTraffic bool
-var domainsLinkService string
-
-var domainsLinkCmd = &cobra.Command{
-	Use:   "link [--insecure] [--service service] domain",
-	Short: "Link a domain to a service.",
-	Args:  cobra.ExactArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		// Attempt to read project from file if possible
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &domainsLinkService, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		return client.LinkDomain(api.LinkDomainArgs{
-			Project:              cfg.Project(),
-			Service:              cfg.Service(),
-			Domain:               args[0],
-			AllowInsecureTraffic: domainsLinkAllowInsecureTraffic,
-		})
-	}),
+func newDomainVerifyCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:               "verify [domain]",
+		Short:             "Verify a newly added domain.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(c.completeDomains()),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			// Attempt to read project from file if possible
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, c.config)
+			if err != nil {
+				return err
+			}
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			dom, err := client.VerifyDomainContext(cmd.Context(), cfg.Project(), args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Verified until", dom.Expiration)
+			return nil
+		}),
+	}
 }
 
-var domainsUnlinkService string
-
-var domainsUnlinkCmd = &cobra.Command{
-	Use:   "unlink [--service service] [domain]",
-	Short: "Unlink a domain from a service.",
-	Args:  cobra.ExactArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		// Attempt to read project from file if possible
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &domainsUnlinkService, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		return client.UnlinkDomain(api.UnlinkDomainArgs{
-			Project: cfg.Project(),
-			Service: cfg.Service(),
-			Domain:  args[0],
-		})
-	}),
+func newDomainLinkCmd(c *cli) *cobra.Command {
+	var (
+		allowInsecureTraffic bool
+		service              string
+	)
+	domainLinkCmd := &cobra.Command{
+		Use:               "link [--insecure] [--service service] domain",
+		Short:             "Link a domain to a service.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(c.completeDomains()),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			// Attempt to read project from file if possible
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &service, c.config)
+			if err != nil {
+				return err
+			}
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			return client.LinkDomainContext(cmd.Context(), api.LinkDomainArgs{
+				Project:              cfg.Project(),
+				Service:              cfg.Service(),
+				Domain:               args[0],
+				AllowInsecureTraffic: allowInsecureTraffic,
+			})
+		}),
+	}
+	domainLinkCmd.Flags().StringVarP(&service, "service", "s", "", "The service to link the domain to")
+	domainLinkCmd.RegisterFlagCompletionFunc("service", c.completeServices())
+	domainLinkCmd.Flags().BoolVarP(&allowInsecureTraffic, "insecure", "i", false, "Allow insecure traffic to the service. Disables the default HTTPS redirect for this domain.")
+	return domainLinkCmd
 }
 
-func initDomainsCmd() {
-	domainsLinkCmd.Flags().StringVarP(&domainsLinkService, "service", "s", "", "The service to link the domain to")
-	domainsLinkCmd.Flags().BoolVarP(&domainsLinkAllowInsecureTraffic, "insecure", "i", false, "Allow insecure traffic to the service. Disables the default HTTPS redirect for this domain.")
-	domainsUnlinkCmd.Flags().StringVarP(&domainsUnlinkService, "service", "s", "", "The service to unlink the domain from")
-	domainsCmd.AddCommand(domainsListCmd, domainsAddCmd, domainsVerifyCmd, domainsLinkCmd, domainsUnlinkCmd, domainsDeleteCmd)
-	rootCmd.AddCommand(domainsCmd)
+func newDomainUnlinkCmd(c *cli) *cobra.Command {
+	var service string
+	domainUnlinkCmd := &cobra.Command{
+		Use:               "unlink [--service service] [domain]",
+		Short:             "Unlink a domain from a service.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: firstArg(c.completeDomains()),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			// Attempt to read project from file if possible
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &service, c.config)
+			if err != nil {
+				return err
+			}
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			return client.UnlinkDomainContext(cmd.Context(), api.UnlinkDomainArgs{
+				Project: cfg.Project(),
+				Service: cfg.Service(),
+				Domain:  args[0],
+			})
+		}),
+	}
+	domainUnlinkCmd.Flags().StringVarP(&service, "service", "s", "", "The service to unlink the domain from")
+	domainUnlinkCmd.RegisterFlagCompletionFunc("service", c.completeServices())
+	return domainUnlinkCmd
 }
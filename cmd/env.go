@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 	"strings"
 	"text/tabwriter"
 
@@ -11,136 +10,141 @@ import (
 	"github.com/valar/cli/config"
 )
 
-var envCmd = &cobra.Command{
-	Use:   "env",
-	Short: "Manage environment variables.",
+func newEnvCmd(c *cli) *cobra.Command {
+	var (
+		format string
+		build  bool
+	)
+	envCmd := &cobra.Command{
+		Use:   "env",
+		Short: "Manage environment variables.",
+	}
+	envCmd.PersistentFlags().BoolVarP(&build, "build", "b", false, "Build scope instead of deployments")
+	envCmd.PersistentFlags().StringVar(&format, "format", "table", "Choose display format (table|raw)")
+	envCmd.AddCommand(newEnvListCmd(c, &format, &build), newEnvSetCmd(c, &build), newEnvDeleteCmd(c, &build))
+	return envCmd
 }
 
-var envListCmd = &cobra.Command{
-	Use:   "list",
-	Short: "List all environment variables bound to the specified scope.",
-	Args:  cobra.NoArgs,
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		kvs := cfg.Deployment().Environment
-		if envBuild {
-			kvs = cfg.Build().Environment
-		}
-		switch envFormat {
-		case "raw":
-			for _, kv := range kvs {
-				fmt.Printf("%s=%s\n", kv.Key, kv.Value)
+func newEnvListCmd(c *cli, format *string, build *bool) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List all environment variables bound to the specified scope.",
+		Args:  cobra.NoArgs,
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, c.config)
+			if err != nil {
+				return err
 			}
-		case "table":
-			tw := tabwriter.NewWriter(os.Stdout, 0, 1, 1, ' ', 0)
-			fmt.Fprintln(tw, "KEY\tVALUE\tSECRET")
-			for _, kv := range kvs {
-				fmt.Fprintf(tw, "%s\t%s\t%v\n", kv.Key, kv.Value, kv.Secret)
+			kvs := cfg.Deployment().Environment
+			if *build {
+				kvs = cfg.Build().Environment
 			}
-			tw.Flush()
-		default:
-			return fmt.Errorf("unknown env format %s", envFormat)
-		}
-		return nil
-	}),
+			switch *format {
+			case "raw":
+				for _, kv := range kvs {
+					fmt.Fprintf(cmd.OutOrStdout(), "%s=%s\n", kv.Key, envValue(kv))
+				}
+			case "table":
+				tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 1, 1, ' ', 0)
+				fmt.Fprintln(tw, "KEY\tVALUE\tSECRET")
+				for _, kv := range kvs {
+					fmt.Fprintf(tw, "%s\t%s\t%v\n", kv.Key, envValue(kv), kv.Secret)
+				}
+				tw.Flush()
+			default:
+				return fmt.Errorf("unknown env format %s", *format)
+			}
+			return nil
+		}),
+	}
 }
 
-var envFormat string
-var envBuild bool
-var envSetSecret bool
+// envValue describes the value of a variable as listed, naming the source of
+// values that are only read on push.
+func envValue(kv config.EnvironmentConfig) string {
+	if kv.ValueFrom != "" {
+		return "(from " + kv.ValueFrom + ")"
+	}
+	return kv.Value
+}
 
-var envSetCmd = &cobra.Command{
-	Use:   "set [envvar]=[value]",
-	Short: "Set variable to the given value",
-	Args:  cobra.ExactArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		// parse arg
-		kvp := strings.SplitN(args[0], "=", 2)
-		if len(kvp) != 2 {
-			return fmt.Errorf("bad arg format, must be key=value")
-		}
-		kv := &api.KVPair{
-			Key:   kvp[0],
-			Value: kvp[1],
-		}
-		if envSetSecret {
-			kv, err = client.EncryptEnvironment(cfg.Project(), cfg.Service(), &api.KVPair{
-				Key:    kvp[0],
-				Value:  kvp[1],
-				Secret: true,
-			})
+func newEnvSetCmd(c *cli, build *bool) *cobra.Command {
+	var secret bool
+	envSetCmd := &cobra.Command{
+		Use:   "set [envvar]=[value]",
+		Short: "Set variable to the given value",
+		Args:  cobra.ExactArgs(1),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, c.config)
 			if err != nil {
 				return err
 			}
-		}
-		yamlCfg := cfg.Unwrap()
-		target := &yamlCfg.Deployment.Environment
-		if envBuild {
-			target = &yamlCfg.Build.Environment
-		}
-		// Check for conflict
-		replaced := false
-		for i := range *target {
-			if (*target)[i].Key == kv.Key {
-				(*target)[i] = config.EnvironmentConfig(*kv)
-				replaced = true
-				break
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
 			}
-		}
-		if !replaced {
-			*target = append(*target, config.EnvironmentConfig(*kv))
-		}
-		return yamlCfg.WriteBack()
-	}),
-}
-
-var envDeleteCmd = &cobra.Command{
-	Use:   "delete [variable]",
-	Short: "Delete the environment variable.",
-	Args:  cobra.ExactArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		yamlCfg := cfg.Unwrap()
-		target := &yamlCfg.Deployment.Environment
-		if envBuild {
-			target = &yamlCfg.Build.Environment
-		}
-		// If found, swap key to end and delete item
-		index := -1
-		for i := range *target {
-			if (*target)[i].Key == args[0] {
-				index = i
-				break
+			// parse arg
+			kvp := strings.SplitN(args[0], "=", 2)
+			if len(kvp) != 2 {
+				return fmt.Errorf("bad arg format, must be key=value")
+			}
+			kv := &api.KVPair{
+				Key:   kvp[0],
+				Value: kvp[1],
 			}
-		}
-		// If not found
-		if index < 0 {
-			return fmt.Errorf("key not found")
-		}
-		(*target)[index] = (*target)[len(*target)-1]
-		(*target) = (*target)[:len(*target)-1]
-		return yamlCfg.WriteBack()
-	}),
+			if secret {
+				kv, err = client.EncryptEnvironmentContext(cmd.Context(), cfg.Project(), cfg.Service(), &api.KVPair{
+					Key:    kvp[0],
+					Value:  kvp[1],
+					Secret: true,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return cfg.UpdateEnvironment(*build, func(env []config.EnvironmentConfig) ([]config.EnvironmentConfig, error) {
+				value := config.EnvironmentConfig{Key: kv.Key, Value: kv.Value, Secret: kv.Secret}
+				// Check for conflict
+				for i := range env {
+					if env[i].Key == kv.Key {
+						env[i] = value
+						return env, nil
+					}
+				}
+				return append(env, value), nil
+			})
+		}),
+	}
+	envSetCmd.Flags().BoolVar(&secret, "secret", false, "Hide variable content in logs and other listings")
+	return envSetCmd
 }
 
-func initEnvCmd() {
-	envSetCmd.Flags().BoolVar(&envSetSecret, "secret", false, "Hide variable content in logs and other listings")
-	envCmd.PersistentFlags().BoolVarP(&envBuild, "build", "b", false, "Build scope instead of deployments")
-	envCmd.Flags().StringVar(&envFormat, "format", "table", "Choose display format (table|raw)")
-	envCmd.AddCommand(envListCmd, envSetCmd, envDeleteCmd)
-	rootCmd.AddCommand(envCmd)
+func newEnvDeleteCmd(c *cli, build *bool) *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete [variable]",
+		Short: "Delete the environment variable.",
+		Args:  cobra.ExactArgs(1),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, c.config)
+			if err != nil {
+				return err
+			}
+			return cfg.UpdateEnvironment(*build, func(env []config.EnvironmentConfig) ([]config.EnvironmentConfig, error) {
+				// If found, swap key to end and delete item
+				index := -1
+				for i := range env {
+					if env[i].Key == args[0] {
+						index = i
+						break
+					}
+				}
+				// If not found
+				if index < 0 {
+					return nil, fmt.Errorf("key not found")
+				}
+				env[index] = env[len(env)-1]
+				return env[:len(env)-1], nil
+			})
+		}),
+	}
 }
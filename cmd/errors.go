@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/valar/cli/api"
+)
+
+// Exit codes of the CLI, allowing scripts to tell failures apart.
+const (
+	exitFailure           = 1
+	exitUnauthorized      = 3
+	exitForbidden         = 4
+	exitNotFound          = 5
+	exitConflict          = 6
+	exitRateLimited       = 7
+	exitServerUnavailable = 8
+	exitCancelled         = 130
+)
+
+var errorClasses = []struct {
+	target error
+	code   int
+	hint   string
+}{
+	{api.ErrUnauthorized, exitUnauthorized, "The endpoint rejected your token, log in again using `valar login` or set up new credentials using `valar config init`."},
+	{api.ErrForbidden, exitForbidden, "You are not allowed to perform this action, ask a project manager to grant you access."},
+	{api.ErrNotFound, exitNotFound, "Make sure the referenced project, service or resource exists and is spelled correctly."},
+	{api.ErrConflict, exitConflict, "The resource already exists or has been modified concurrently."},
+	{api.ErrRateLimited, exitRateLimited, "Too many requests, try again later or allow more attempts using `--retries`."},
+	{api.ErrServerUnavailable, exitServerUnavailable, "The endpoint is temporarily unavailable, try again later."},
+}
+
+// reportError prints the error with a hint on how to resolve it and returns
+// the matching exit code.
+func reportError(w io.Writer, err error) int {
+	code, hint := classifyError(err)
+	if code == exitCancelled {
+		fmt.Fprintln(w, "Operation cancelled.")
+		return code
+	}
+	fmt.Fprintln(w, err)
+	var apiErr api.Error
+	if errors.As(err, &apiErr) {
+		for _, detail := range apiErr.Body.Details {
+			fmt.Fprintln(w, "  -", detail)
+		}
+		if apiErr.Body.RequestID != "" {
+			fmt.Fprintln(w, "Request ID:", apiErr.Body.RequestID)
+		}
+	}
+	if hint != "" {
+		fmt.Fprintln(w, "Hint:", hint)
+	}
+	return code
+}
+
+// classifyError returns the exit code and a resolution hint for the error.
+func classifyError(err error) (int, string) {
+	if errors.Is(err, context.Canceled) {
+		return exitCancelled, ""
+	}
+	for _, class := range errorClasses {
+		if errors.Is(err, class.target) {
+			return class.code, class.hint
+		}
+	}
+	return exitFailure, ""
+}
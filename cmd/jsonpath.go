@@ -0,0 +1,551 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a JSONPath template as accepted by kubectl, e.g.
+// {range [*]}{.id}{"\t"}{.status}{"\n"}{end}. Text outside of braces is
+// printed as is. Inside of them, it supports fields, recursive descent,
+// indices, slices, wildcards, filters, string literals and ranges. Missing
+// fields and indices select nothing instead of failing.
+type jsonPath struct {
+	nodes []jsonPathNode
+}
+
+// jsonPathNode is either literal text, a path to print the values of, or a
+// range executing its body for each value of the path.
+type jsonPathNode struct {
+	text  string
+	path  []jsonPathStep
+	body  *jsonPath
+	print bool
+}
+
+// jsonPathStep selects values from each of the current ones.
+type jsonPathStep struct {
+	// field selects a field, or every field and element if it is "*". If
+	// recursive, it is searched for at any depth.
+	field     string
+	recursive bool
+	// index selects an element at start, or a slice of them if slice is
+	// set, or every element with start and end unset. Negative positions
+	// count from the end.
+	index      bool
+	slice      bool
+	start, end *int
+	// filter selects the elements for which the condition holds.
+	filter *jsonPathFilter
+}
+
+// jsonPathFilter is the condition of a filter like [?(@.status=="failed")].
+// Without an operator, it holds if the path selects anything.
+type jsonPathFilter struct {
+	path  []jsonPathStep
+	op    string
+	value interface{}
+}
+
+// parseJSONPathTemplate parses a JSONPath template.
+func parseJSONPathTemplate(text string) (*jsonPath, error) {
+	root := &jsonPath{}
+	stack := []*jsonPath{root}
+	for text != "" {
+		current := stack[len(stack)-1]
+		open := strings.IndexByte(text, '{')
+		if open < 0 {
+			current.nodes = append(current.nodes, jsonPathNode{text: text})
+			break
+		}
+		if open > 0 {
+			current.nodes = append(current.nodes, jsonPathNode{text: text[:open]})
+		}
+		end, err := actionEnd(text[open+1:])
+		if err != nil {
+			return nil, err
+		}
+		action := strings.TrimSpace(text[open+1 : open+1+end])
+		text = text[open+1+end+1:]
+		switch {
+		case action == "end":
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("{end} without {range}")
+			}
+			stack = stack[:len(stack)-1]
+		case strings.HasPrefix(action, "range") && len(action) > len("range") && strings.ContainsRune(" \t.[$@", rune(action[len("range")])):
+			path, err := parseJSONPathSteps(strings.TrimSpace(action[len("range"):]))
+			if err != nil {
+				return nil, err
+			}
+			body := &jsonPath{}
+			current.nodes = append(current.nodes, jsonPathNode{path: path, body: body})
+			stack = append(stack, body)
+		case strings.HasPrefix(action, `"`):
+			s, err := strconv.Unquote(action)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", action)
+			}
+			current.nodes = append(current.nodes, jsonPathNode{text: s})
+		default:
+			path, err := parseJSONPathSteps(action)
+			if err != nil {
+				return nil, err
+			}
+			current.nodes = append(current.nodes, jsonPathNode{path: path, print: true})
+		}
+	}
+	if len(stack) > 1 {
+		return nil, fmt.Errorf("{range} without {end}")
+	}
+	return root, nil
+}
+
+// actionEnd returns the index of the brace closing the action text starts
+// with, skipping brackets and strings.
+func actionEnd(text string) (int, error) {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '"', '\'':
+			n, err := quotedEnd(text[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '}':
+			if depth > 0 {
+				return 0, fmt.Errorf("unterminated array")
+			}
+			return i, nil
+		}
+	}
+	if depth > 0 {
+		return 0, fmt.Errorf("unterminated array")
+	}
+	return 0, fmt.Errorf("unclosed action")
+}
+
+// quotedEnd returns the index of the quote closing the string text starts
+// with.
+func quotedEnd(text string) (int, error) {
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case text[0]:
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated string")
+}
+
+// parseJSONPathSteps parses a path like .builds[0].id, optionally starting
+// with $ or @ for the current value.
+func parseJSONPathSteps(text string) ([]jsonPathStep, error) {
+	orig := text
+	text = strings.TrimPrefix(strings.TrimPrefix(text, "$"), "@")
+	var steps []jsonPathStep
+	for text != "" {
+		switch {
+		case strings.HasPrefix(text, ".."):
+			name, rest := cutFieldName(text[2:])
+			if name == "" {
+				return nil, fmt.Errorf("invalid path %q: missing field after ..", orig)
+			}
+			steps = append(steps, jsonPathStep{field: name, recursive: true})
+			text = rest
+		case text[0] == '.':
+			name, rest := cutFieldName(text[1:])
+			if name != "" {
+				steps = append(steps, jsonPathStep{field: name})
+			}
+			text = rest
+		case text[0] == '[':
+			end, err := bracketEnd(text)
+			if err != nil {
+				return nil, err
+			}
+			step, err := parseJSONPathBracket(text[1:end])
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
+			text = text[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid path %q: unexpected %q", orig, text)
+		}
+	}
+	return steps, nil
+}
+
+// cutFieldName splits off the name of a field.
+func cutFieldName(text string) (name, rest string) {
+	i := strings.IndexFunc(text, func(r rune) bool {
+		return !(r == '_' || r == '-' || r == '*' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 0x7f)
+	})
+	if i < 0 {
+		return text, ""
+	}
+	return text[:i], text[i:]
+}
+
+// bracketEnd returns the index of the bracket closing the one text starts
+// with.
+func bracketEnd(text string) (int, error) {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '"', '\'':
+			n, err := quotedEnd(text[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unterminated array")
+}
+
+// parseJSONPathBracket parses the inside of brackets: a quoted field, a
+// wildcard, an index, a slice or a filter.
+func parseJSONPathBracket(text string) (jsonPathStep, error) {
+	text = strings.TrimSpace(text)
+	switch {
+	case text == "*":
+		return jsonPathStep{index: true}, nil
+	case strings.HasPrefix(text, "'") || strings.HasPrefix(text, `"`):
+		name, err := unquoteJSONPath(text)
+		if err != nil {
+			return jsonPathStep{}, err
+		}
+		return jsonPathStep{field: name}, nil
+	case strings.HasPrefix(text, "?("):
+		if !strings.HasSuffix(text, ")") {
+			return jsonPathStep{}, fmt.Errorf("invalid filter %q", text)
+		}
+		filter, err := parseJSONPathFilter(strings.TrimSpace(text[2 : len(text)-1]))
+		if err != nil {
+			return jsonPathStep{}, err
+		}
+		return jsonPathStep{filter: filter}, nil
+	}
+	parts := strings.Split(text, ":")
+	if len(parts) > 2 {
+		return jsonPathStep{}, fmt.Errorf("invalid array index %q", text)
+	}
+	var positions []*int
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			positions = append(positions, nil)
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return jsonPathStep{}, fmt.Errorf("invalid array index %q", text)
+		}
+		positions = append(positions, &n)
+	}
+	if len(positions) == 1 {
+		if positions[0] == nil {
+			return jsonPathStep{}, fmt.Errorf("invalid array index %q", text)
+		}
+		return jsonPathStep{index: true, start: positions[0]}, nil
+	}
+	return jsonPathStep{index: true, slice: true, start: positions[0], end: positions[1]}, nil
+}
+
+// parseJSONPathFilter parses the condition of a filter, like @.age>2.
+func parseJSONPathFilter(text string) (*jsonPathFilter, error) {
+	if !strings.HasPrefix(text, "@") {
+		return nil, fmt.Errorf("invalid filter %q: the path has to start with @", text)
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		path, value, ok := strings.Cut(text, op)
+		if !ok {
+			continue
+		}
+		steps, err := parseJSONPathSteps(strings.TrimSpace(path))
+		if err != nil {
+			return nil, err
+		}
+		filter := &jsonPathFilter{path: steps, op: op}
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(value, "'") || strings.HasPrefix(value, `"`):
+			if filter.value, err = unquoteJSONPath(value); err != nil {
+				return nil, err
+			}
+		case value == "true" || value == "false":
+			filter.value = value == "true"
+		default:
+			if filter.value, err = strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("invalid filter %q: %s is neither a string, a number nor a boolean", text, value)
+			}
+		}
+		return filter, nil
+	}
+	steps, err := parseJSONPathSteps(text)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonPathFilter{path: steps}, nil
+}
+
+// unquoteJSONPath unquotes a string in single or double quotes.
+func unquoteJSONPath(text string) (string, error) {
+	if len(text) < 2 || text[len(text)-1] != text[0] {
+		return "", fmt.Errorf("unterminated string")
+	}
+	if text[0] == '\'' {
+		text = `"` + strings.ReplaceAll(text[1:len(text)-1], `"`, `\"`) + `"`
+	}
+	s, err := strconv.Unquote(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid string %s", text)
+	}
+	return s, nil
+}
+
+// execute writes the template for data, a document decoded from JSON.
+func (p *jsonPath) execute(out io.Writer, data interface{}) error {
+	return p.executeOn(out, data, data)
+}
+
+func (p *jsonPath) executeOn(out io.Writer, root, current interface{}) error {
+	for _, node := range p.nodes {
+		if !node.print && node.body == nil {
+			if _, err := io.WriteString(out, node.text); err != nil {
+				return err
+			}
+			continue
+		}
+		values := selectJSONPath(node.path, root, current)
+		if node.body != nil {
+			for _, v := range values {
+				if err := node.body.executeOn(out, root, v); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		// Several values of one path are separated by spaces.
+		for i, v := range values {
+			if i > 0 {
+				if _, err := io.WriteString(out, " "); err != nil {
+					return err
+				}
+			}
+			text, err := jsonPathText(v)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(out, text); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonPathText prints strings as they are and other values as JSON.
+func jsonPathText(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// selectJSONPath returns the values the steps select from current.
+func selectJSONPath(steps []jsonPathStep, root, current interface{}) []interface{} {
+	values := []interface{}{current}
+	for _, step := range steps {
+		var next []interface{}
+		for _, v := range values {
+			next = append(next, step.apply(root, v)...)
+		}
+		values = next
+	}
+	return values
+}
+
+func (step jsonPathStep) apply(root, v interface{}) []interface{} {
+	switch {
+	case step.recursive:
+		return descendants(v, step.field)
+	case step.field == "*":
+		return children(v)
+	case step.field != "":
+		if m, ok := v.(map[string]interface{}); ok {
+			if field, ok := m[step.field]; ok {
+				return []interface{}{field}
+			}
+		}
+		return nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	if step.filter != nil {
+		var matches []interface{}
+		for _, elem := range list {
+			if step.filter.holds(root, elem) {
+				matches = append(matches, elem)
+			}
+		}
+		return matches
+	}
+	if step.start != nil && !step.slice {
+		i := *step.start
+		if i < 0 {
+			i += len(list)
+		}
+		if i < 0 || i >= len(list) {
+			return nil
+		}
+		return list[i : i+1]
+	}
+	start, end := 0, len(list)
+	if step.start != nil {
+		start = position(*step.start, len(list))
+	}
+	if step.end != nil {
+		end = position(*step.end, len(list))
+	}
+	if start >= end {
+		return nil
+	}
+	return list[start:end]
+}
+
+// position resolves an index relative to a list of n elements, counting
+// negative ones from the end.
+func position(i, n int) int {
+	if i < 0 {
+		i += n
+	}
+	return min(max(i, 0), n)
+}
+
+// children returns the fields of an object or the elements of a list.
+func children(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		values := make([]interface{}, 0, len(v))
+		for _, key := range sortedKeys(v) {
+			values = append(values, v[key])
+		}
+		return values
+	case []interface{}:
+		return v
+	}
+	return nil
+}
+
+// descendants returns the values of the fields with the given name, or of
+// all fields if it is "*", at any depth.
+func descendants(v interface{}, name string) []interface{} {
+	var values []interface{}
+	if m, ok := v.(map[string]interface{}); ok {
+		if field, ok := m[name]; ok && name != "*" {
+			values = append(values, field)
+		}
+	}
+	for _, child := range children(v) {
+		if name == "*" {
+			values = append(values, child)
+		}
+		values = append(values, descendants(child, name)...)
+	}
+	return values
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// holds tells whether the filter selects the element.
+func (f *jsonPathFilter) holds(root, elem interface{}) bool {
+	values := selectJSONPath(f.path, root, elem)
+	if f.op == "" {
+		return len(values) > 0
+	}
+	for _, v := range values {
+		if compareJSON(v, f.op, f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareJSON compares a value to a literal of a filter. Values of other
+// types than the literal only differ from it.
+func compareJSON(v interface{}, op string, literal interface{}) bool {
+	var cmp int
+	switch literal := literal.(type) {
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return op == "!="
+		}
+		cmp = strings.Compare(s, literal)
+	case float64:
+		f, ok := v.(float64)
+		if !ok {
+			return op == "!="
+		}
+		cmp = compareFloats(f, literal)
+	case bool:
+		b, ok := v.(bool)
+		if !ok || op != "==" && op != "!=" {
+			return op == "!=" && !ok
+		}
+		return (b == literal) == (op == "==")
+	}
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONPath(t *testing.T) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(`{
+		"name": "web",
+		"replicas": 2,
+		"labels": {"tier": "frontend", "team": "core"},
+		"builds": [
+			{"id": "b1", "status": "succeeded", "size": 10, "tags": ["a"]},
+			{"id": "b2", "status": "failed", "size": 30},
+			{"id": "b3", "status": "succeeded", "size": 20, "tags": ["b", "c"]}
+		]
+	}`), &data); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name, template, want string
+	}{
+		{"field", "{.name}", "web"},
+		{"number", "{.replicas}", "2"},
+		{"object", "{.labels}", `{"team":"core","tier":"frontend"}`},
+		{"root", "{$.name}", "web"},
+		{"missing", "{.image}{.builds[7].id}", ""},
+		{"text", `name: {.name}{"\n"}`, "name: web\n"},
+		{"index", "{.builds[0].id} {.builds[-1].id}", "b1 b3"},
+		{"slice", "{.builds[1:].id}", "b2 b3"},
+		{"wildcard", "{.builds[*].id}", "b1 b2 b3"},
+		{"wildcard field", "{.labels.*}", "core frontend"},
+		{"quoted field", "{.labels['tier']}", "frontend"},
+		{"recursive", "{..tags[*]}", "a b c"},
+		{"filter", `{.builds[?(@.status=="failed")].id}`, "b2"},
+		{"numeric filter", "{.builds[?(@.size >= 20)].id}", "b2 b3"},
+		{"existence filter", "{.builds[?(@.tags)].id}", "b1 b3"},
+		{"range", `{range .builds[*]}{.id}:{.status}{"\n"}{end}`, "b1:succeeded\nb2:failed\nb3:succeeded\n"},
+		{"nested range", `{range .builds[*]}{range .tags[*]}{@},{end}{end}`, "a,b,c,"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := parseJSONPathTemplate(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out strings.Builder
+			if err := path.execute(&out, data); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("got %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONPathErrors(t *testing.T) {
+	for _, tt := range []struct {
+		template, want string
+	}{
+		{"{.[}", "unterminated array"},
+		{"{.name", "unclosed action"},
+		{"{range .items[*]}{.id}", "{range} without {end}"},
+		{"{.id}{end}", "{end} without {range}"},
+		{"{.items[a]}", `invalid array index "a"`},
+		{`{.items[?(@.id=="a)]}`, "unterminated string"},
+	} {
+		if _, err := parseJSONPathTemplate(tt.template); err == nil || err.Error() != tt.want {
+			t.Errorf("%s: got error %v, want %s", tt.template, err, tt.want)
+		}
+	}
+}
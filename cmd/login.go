@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/valar/cli/api"
+	"github.com/valar/cli/config"
+)
+
+// loginTimeout bounds how long the user may take to approve a login.
+const loginTimeout = 10 * time.Minute
+
+func newLoginCmd(c *cli) *cobra.Command {
+	var device, noBrowser bool
+	loginCmd := &cobra.Command{
+		Use:   "login [--device]",
+		Short: "Log in to the API endpoint using the browser.",
+		Long: `Log in to the API endpoint using the browser.
+
+The login is approved in the browser, which hands the token back to the CLI
+on a local port. On machines without a browser, --device prints a code to
+approve the login with on another device instead. The token is stored on the
+endpoint in use and refreshed automatically before it expires.`,
+		Args: cobra.NoArgs,
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			name := c.config.EndpointName()
+			if _, ok := c.config.Endpoints[name]; !ok {
+				return fmt.Errorf("logging in requires a configured endpoint, add one using `valar config endpoint set`")
+			}
+			ctx, cancel := context.WithTimeout(cmd.Context(), loginTimeout)
+			defer cancel()
+			oauth := api.NewOAuth(c.config.Endpoint())
+			issued := c.Now()
+			var (
+				token *api.OAuthToken
+				err   error
+			)
+			if device {
+				token, err = loginWithDevice(ctx, cmd.ErrOrStderr(), oauth)
+			} else {
+				token, err = c.loginWithBrowser(ctx, cmd.ErrOrStderr(), oauth, !noBrowser)
+			}
+			if err != nil {
+				return fmt.Errorf("log in: %w", err)
+			}
+			if err := c.config.SetOAuthToken(ctx, name, token, issued); err != nil {
+				return fmt.Errorf("store token: %w", err)
+			}
+			if err := c.config.Write(); err != nil {
+				return fmt.Errorf("write config: %w", err)
+			}
+			client, err := c.apiClient(ctx)
+			if err != nil {
+				return err
+			}
+			info, err := client.UserInfoContext(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "Logged in to endpoint %s as %s.\n", name, info.Name)
+			return nil
+		}),
+	}
+	loginCmd.Flags().BoolVar(&device, "device", false, "Approve the login on another device, e.g. on machines without a browser")
+	loginCmd.Flags().BoolVar(&noBrowser, "no-browser", false, "Print the login URL instead of opening the browser")
+	return loginCmd
+}
+
+// loginWithBrowser runs the authorization code flow, receiving the code on a
+// loopback listener.
+func (c *cli) loginWithBrowser(ctx context.Context, w io.Writer, oauth *api.OAuth, open bool) (*api.OAuthToken, error) {
+	pkce, err := api.NewPKCE()
+	if err != nil {
+		return nil, err
+	}
+	state, err := api.NewOAuthState()
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listen for callback: %w", err)
+	}
+	redirectURI := fmt.Sprintf("http://%s/callback", listener.Addr())
+
+	type callback struct {
+		code string
+		err  error
+	}
+	callbacks := make(chan callback, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var result callback
+		switch {
+		case r.URL.Path != "/callback":
+			http.NotFound(rw, r)
+			return
+		case query.Get("state") != state:
+			result.err = fmt.Errorf("callback does not match the login request")
+		case query.Get("error") != "":
+			result.err = api.OAuthError{Code: query.Get("error"), Description: query.Get("error_description")}
+		default:
+			result.code = query.Get("code")
+		}
+		if result.err != nil {
+			http.Error(rw, "Login failed, see the terminal for details.", http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(rw, "Login approved, you may close this window and return to the terminal.")
+		}
+		select {
+		case callbacks <- result:
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	authorizeURL := oauth.AuthorizeURL(redirectURI, state, pkce)
+	if open {
+		fmt.Fprintf(w, "Opening %s in your browser to log in.\n", authorizeURL)
+		if err := c.OpenURL(authorizeURL); err != nil {
+			fmt.Fprintf(w, "Could not open the browser (%v), open the URL manually.\n", err)
+		}
+	} else {
+		fmt.Fprintf(w, "Open %s in your browser to log in.\n", authorizeURL)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for approval: %w", ctx.Err())
+	case result := <-callbacks:
+		if result.err != nil {
+			return nil, result.err
+		}
+		return oauth.ExchangeCodeContext(ctx, result.code, redirectURI, pkce)
+	}
+}
+
+// loginWithDevice runs the device authorization flow.
+func loginWithDevice(ctx context.Context, w io.Writer, oauth *api.OAuth) (*api.OAuthToken, error) {
+	auth, err := oauth.AuthorizeDeviceContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(w, "Open %s on any device and enter the code %s to log in.\n", auth.VerificationURI, auth.UserCode)
+	if auth.VerificationURIComplete != "" {
+		fmt.Fprintf(w, "Alternatively, open %s.\n", auth.VerificationURIComplete)
+	}
+	return oauth.PollDeviceTokenContext(ctx, auth)
+}
+
+func newLogoutCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "logout",
+		Short: "Revoke and delete the token of the API endpoint.",
+		Args:  cobra.NoArgs,
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			name := c.config.EndpointName()
+			ep, ok := c.config.Endpoints[name]
+			if !ok {
+				return fmt.Errorf("logging out requires a configured endpoint")
+			}
+			oauth := api.NewOAuth(ep.URL)
+			for _, kind := range []string{config.CredentialToken, config.CredentialRefreshToken} {
+				token := c.config.StoredSecret(cmd.Context(), name, kind)
+				if token == "" {
+					continue
+				}
+				if err := oauth.RevokeContext(cmd.Context(), token); err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "Warning: Could not revoke %s: %v\n", kind, err)
+				}
+			}
+			if err := c.config.ClearToken(cmd.Context(), name); err != nil {
+				return fmt.Errorf("delete token: %w", err)
+			}
+			if err := c.config.Write(); err != nil {
+				return fmt.Errorf("write config: %w", err)
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "Logged out of endpoint %s.\n", name)
+			return nil
+		}),
+	}
+}
+
+// openBrowser opens the URL in the default browser.
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
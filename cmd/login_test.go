@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valar/cli/config"
+)
+
+func TestLogin(t *testing.T) {
+	fake := setupWorkspace(t, "")
+	// Tokens about to expire are refreshed by the next command.
+	fake.TokenLifetime = 30 * time.Second
+	readConfig := func() *config.CLIConfig {
+		t.Helper()
+		cfg, err := config.NewCLIConfigFromEnvironment(io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+
+	_, stderr, code := runCommand(t, "login")
+	if code != 0 || !strings.Contains(stderr, "Logged in to endpoint default as alice.") {
+		t.Fatalf("valar login exited with %d: %s", code, stderr)
+	}
+	loggedIn := readConfig().Endpoints["default"]
+	if loggedIn.Token == "" || loggedIn.RefreshToken == "" || loggedIn.TokenExpiry.IsZero() {
+		t.Fatalf("endpoint after login = %+v, want token, refresh token and expiry", loggedIn)
+	}
+
+	// Completing is read-only and leaves expiring tokens alone.
+	if stdout, stderr, code := runCommand(t, "__complete", "build", "inspect", "b0"); code != 0 || !strings.Contains(stdout, "b0a1") {
+		t.Fatalf("completion exited with %d: %s%s", code, stdout, stderr)
+	}
+	if completed := readConfig().Endpoints["default"]; completed.Token != loggedIn.Token || !fake.TokenValid(loggedIn.RefreshToken) {
+		t.Errorf("completion refreshed the token: %+v", completed)
+	}
+
+	if _, stderr, code := runCommand(t, "service", "list"); code != 0 {
+		t.Fatalf("service list exited with %d: %s", code, stderr)
+	}
+	refreshed := readConfig().Endpoints["default"]
+	if refreshed.Token == loggedIn.Token || fake.TokenValid(loggedIn.RefreshToken) {
+		t.Errorf("token has not been refreshed: %+v", refreshed)
+	}
+
+	if _, stderr, code := runCommand(t, "logout"); code != 0 {
+		t.Fatalf("valar logout exited with %d: %s", code, stderr)
+	}
+	if ep := readConfig().Endpoints["default"]; ep.Token != "" || ep.RefreshToken != "" {
+		t.Errorf("endpoint after logout = %+v, want no tokens", ep)
+	}
+	if fake.TokenValid(refreshed.Token) || fake.TokenValid(refreshed.RefreshToken) {
+		t.Error("tokens have not been revoked on logout")
+	}
+
+	if _, stderr, code := runCommand(t, "login", "--device"); code != 0 || !strings.Contains(stderr, "enter the code WDJB-MJHT") {
+		t.Errorf("valar login --device exited with %d: %s", code, stderr)
+	}
+}
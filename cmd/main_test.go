@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/klauspost/compress/zstd"
+	"github.com/valar/cli/api"
+	"github.com/valar/cli/apitest"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// testNow is the fixed time the commands and the fake API run at.
+var testNow = time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	color.NoColor = true
+	os.Exit(m.Run())
+}
+
+// newFakeAPI seeds a fake API with a project acme and a service web.
+func newFakeAPI() *apitest.Server {
+	fake := apitest.NewServer("alice")
+	fake.Token = "secret"
+	fake.Now = func() time.Time { return testNow }
+
+	fake.AddService("acme", api.Service{
+		ID:        "5e7a1c0de5e7a1c0",
+		Name:      "web",
+		CreatedAt: testNow.Add(-72 * time.Hour),
+		Domains:   []string{},
+	})
+	fake.AddBuild("acme", "web", api.Build{
+		ID:          "b0a1c2d3e4f50617",
+		Constructor: "go1.22",
+		Status:      "succeeded",
+		CreatedAt:   testNow.Add(-2 * time.Hour),
+		Owner:       "alice",
+	},
+		api.LogEntry{Timestamp: testNow.Add(-2 * time.Hour), Source: api.LogEntrySourceWrapper, Stage: api.LogEntryStageSetup, Content: "Fetching artifact"},
+		api.LogEntry{Timestamp: testNow.Add(-2*time.Hour + time.Second), Source: api.LogEntrySourceProcess, Content: "go build ./..."},
+	)
+	fake.AddBuild("acme", "web", api.Build{
+		ID:          "f1e2d3c4b5a69788",
+		Constructor: "go1.22",
+		Status:      "failed",
+		Err:         "exit status 2",
+		CreatedAt:   testNow.Add(-time.Hour),
+		Owner:       "alice",
+	},
+		api.LogEntry{Timestamp: testNow.Add(-time.Hour), Source: api.LogEntrySourceProcess, Content: "main.go:3:1: syntax error"},
+	)
+	fake.AddDeployment("acme", "web", api.Deployment{
+		Version:   1,
+		CreatedAt: testNow.Add(-48 * time.Hour),
+		Status:    "succeeded",
+		Build:     "0c1d2e3f40516273",
+	})
+	fake.AddDeployment("acme", "web", api.Deployment{
+		Version:   2,
+		CreatedAt: testNow.Add(-2 * time.Hour),
+		Status:    "succeeded",
+		Build:     "b0a1c2d3e4f50617",
+	})
+	service := "web"
+	fake.AddDomain("acme", api.Domain{
+		Domain:     "acme.example",
+		Token:      "valar-verify-acme",
+		Verified:   true,
+		Expiration: testNow.Add(30 * 24 * time.Hour),
+		Service:    &service,
+	})
+	fake.AddSchedule("acme", "web", api.Schedule{
+		Name:     "nightly",
+		Timespec: "0 3 * * *",
+		Path:     "/cleanup",
+		Status:   "enabled",
+	}, &api.ServiceInvocation{
+		ID:            "1nv0ca7e1nv0ca7e",
+		StartTime:     testNow.Add(-9 * time.Hour),
+		EndTime:       testNow.Add(-9*time.Hour + 3*time.Second),
+		Status:        "succeeded",
+		TriggerSource: "schedule",
+	})
+	fake.AddPermission("acme", api.Permission{
+		Path:   api.PermissionPath{Namespace: "service", Items: []string{"acme", "web"}},
+		User:   api.PermissionUser{Type: "user", Identifier: []string{"bob"}},
+		Action: "invoke",
+		State:  "allow",
+	})
+	return fake
+}
+
+const testServiceConfig = `project: acme
+service: web
+build:
+  constructor: go1.22
+  environment:
+  - GOFLAGS=-mod=mod
+deployment:
+  environment:
+  - LOG_LEVEL=debug
+  - key: API_KEY
+    value: encrypted:c2VjcmV0
+    secret: true
+`
+
+// setupWorkspace starts the fake API and points the CLI configuration and
+// the working directory to a fresh temporary directory.
+func setupWorkspace(t *testing.T, token string) *apitest.Server {
+	t.Helper()
+	fake := newFakeAPI()
+	srv := fake.Start()
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	cfg := fmt.Sprintf(`activeContext: default
+endpoints:
+  default:
+    url: %s
+    token: %q
+contexts:
+  default:
+    endpoint: default
+    project: acme
+`, srv.URL, token)
+	cfgpath := filepath.Join(dir, "config")
+	if err := os.WriteFile(cfgpath, []byte(cfg), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, functionConfiguration), []byte(testServiceConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VALARCONFIG", cfgpath)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	t.Chdir(dir)
+	return fake
+}
+
+// writeFiles writes the files, given by their path relative to the working
+// directory using slashes, creating their directories as needed.
+func writeFiles(t *testing.T, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		name = filepath.FromSlash(name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// pushBuild runs build push and returns the request of the submitted build
+// along with the error output.
+func pushBuild(t *testing.T, fake *apitest.Server, args ...string) (*api.BuildRequest, string) {
+	t.Helper()
+	stdout, stderr, code := runCommand(t, append([]string{"build", "push"}, args...)...)
+	if code != 0 {
+		t.Fatalf("build push %v exited with %d: %s", args, code, stderr)
+	}
+	req := fake.BuildRequest("acme", "web", strings.TrimSpace(stdout))
+	if req == nil {
+		t.Fatalf("build push %v printed %q, which is not a build", args, stdout)
+	}
+	return req, stderr
+}
+
+// exitSignal carries the code passed to exit up to runCommand.
+type exitSignal int
+
+// runCommand runs a fresh command tree in-process and returns its output
+// along with the exit code.
+func runCommand(t *testing.T, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	var outBuf, errBuf bytes.Buffer
+	c := newCLI(Options{
+		In:   strings.NewReader(""),
+		Out:  &outBuf,
+		Err:  &errBuf,
+		Now:  func() time.Time { return testNow },
+		Exit: func(code int) { panic(exitSignal(code)) },
+		// Follow the login URL like a browser approving it right away.
+		OpenURL: func(url string) error {
+			resp, err := http.Get(url)
+			if err != nil {
+				return err
+			}
+			return resp.Body.Close()
+		},
+	})
+
+	code = func() (code int) {
+		defer func() {
+			if r := recover(); r != nil {
+				sig, ok := r.(exitSignal)
+				if !ok {
+					panic(r)
+				}
+				code = int(sig)
+			}
+		}()
+		if err := c.execute(context.Background(), c.rootCommand(), args); err != nil {
+			code, _ := classifyError(err)
+			return code
+		}
+		return 0
+	}()
+	return outBuf.String(), errBuf.String(), code
+}
+
+// archiveFiles lists the regular files of a tar artifact compressed with
+// gzip or zstd in order.
+func archiveFiles(t *testing.T, data []byte) []string {
+	t.Helper()
+	var (
+		r   io.Reader
+		err error
+	)
+	if bytes.HasPrefix(data, []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+		r, err = zstd.NewReader(bytes.NewReader(data))
+	} else {
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			files = append(files, hdr.Name)
+		}
+	}
+}
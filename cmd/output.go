@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats accepted by the --output flag.
+const (
+	outputTable          = "table"
+	outputWide           = "wide"
+	outputJSON           = "json"
+	outputYAML           = "yaml"
+	outputTemplatePrefix = "go-template="
+	outputJSONPathPrefix = "jsonpath="
+)
+
+// checkOutputFormat validates the value of the --output flag.
+func checkOutputFormat(format string) error {
+	switch format {
+	case outputTable, outputWide, outputJSON, outputYAML:
+		return nil
+	}
+	if text, ok := strings.CutPrefix(format, outputJSONPathPrefix); ok {
+		_, err := parseJSONPath(text)
+		return err
+	}
+	text, ok := strings.CutPrefix(format, outputTemplatePrefix)
+	if !ok {
+		return fmt.Errorf("unknown output format %q, expected one of table, wide, json, yaml, go-template=... or jsonpath=...", format)
+	}
+	if _, err := template.New("output").Parse(text); err != nil {
+		return fmt.Errorf("parse output template: %w", err)
+	}
+	return nil
+}
+
+// parseJSONPath parses a JSONPath template as accepted by kubectl, where
+// the braces around a single expression may be left out.
+func parseJSONPath(text string) (*jsonPath, error) {
+	if !strings.Contains(text, "{") {
+		text = "{" + text + "}"
+	}
+	path, err := parseJSONPathTemplate(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse output jsonpath: %w", err)
+	}
+	return path, nil
+}
+
+// printResult writes v to out in the selected output format. Tables are
+// rendered by table, which is told whether to include additional columns.
+// The other formats serialize v using its JSON field names, so that all of
+// them share the same shape.
+func (c *cli) printResult(out io.Writer, v interface{}, table func(w io.Writer, wide bool)) error {
+	switch c.output {
+	case outputTable, "":
+		table(out, false)
+		return nil
+	case outputWide:
+		table(out, true)
+		return nil
+	}
+	// Print empty lists as such instead of null.
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.IsNil() {
+		v = []interface{}{}
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode output: %w", err)
+	}
+	switch c.output {
+	case outputJSON:
+		_, err := fmt.Fprintf(out, "%s\n", data)
+		return err
+	case outputYAML:
+		return writeYAML(out, data)
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return fmt.Errorf("encode output: %w", err)
+	}
+	if text, ok := strings.CutPrefix(c.output, outputJSONPathPrefix); ok {
+		path, err := parseJSONPath(text)
+		if err != nil {
+			return err
+		}
+		if err := path.execute(out, generic); err != nil {
+			return fmt.Errorf("execute output jsonpath: %w", err)
+		}
+		return nil
+	}
+	text, _ := strings.CutPrefix(c.output, outputTemplatePrefix)
+	tmpl, err := template.New("output").Parse(text)
+	if err != nil {
+		return fmt.Errorf("parse output template: %w", err)
+	}
+	if err := tmpl.Execute(out, generic); err != nil {
+		return fmt.Errorf("execute output template: %w", err)
+	}
+	return nil
+}
+
+// writeYAML converts JSON data to YAML, keeping the order of all fields.
+func writeYAML(out io.Writer, data []byte) error {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return fmt.Errorf("encode output: %w", err)
+	}
+	blockStyle(&node)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return fmt.Errorf("encode output: %w", err)
+	}
+	enc.Close()
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+// blockStyle drops the flow style and quoting JSON documents are parsed
+// with. Strings that need quotes in YAML are quoted again on encoding.
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
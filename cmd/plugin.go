@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/valar/cli/config"
+)
+
+// pluginPrefix is the prefix of executables extending the CLI, e.g. the
+// executable valar-promote provides the command valar promote.
+const pluginPrefix = "valar-"
+
+// plugin is an executable found in one of the plugin directories.
+type plugin struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// pluginDirs returns the directories searched for plugins in order of
+// precedence, starting with ~/.valar/plugins followed by the PATH.
+func pluginDirs() []string {
+	var dirs []string
+	if homedir, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(homedir, ".valar", "plugins"))
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			dir = "."
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// pluginName returns the command name of a plugin executable.
+func pluginName(entry os.DirEntry) (string, bool) {
+	name := entry.Name()
+	if entry.IsDir() || !strings.HasPrefix(name, pluginPrefix) {
+		return "", false
+	}
+	info, err := entry.Info()
+	if err != nil {
+		return "", false
+	}
+	if runtime.GOOS == "windows" {
+		ext := strings.ToLower(filepath.Ext(name))
+		if ext != ".exe" && ext != ".bat" && ext != ".cmd" {
+			return "", false
+		}
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	} else if info.Mode()&0111 == 0 {
+		return "", false
+	}
+	name = strings.TrimPrefix(name, pluginPrefix)
+	// Credential helpers share the prefix, but are no commands.
+	if strings.HasPrefix(name, "credential-") {
+		return "", false
+	}
+	return name, name != ""
+}
+
+// findPlugins lists all plugins. A plugin hidden by one of the same name in
+// a directory of higher precedence is listed with a warning.
+func findPlugins() []plugin {
+	var plugins []plugin
+	seen := map[string]string{}
+	for _, dir := range pluginDirs() {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name, ok := pluginName(entry)
+			if !ok {
+				continue
+			}
+			p := plugin{Name: name, Path: filepath.Join(dir, entry.Name())}
+			if first, ok := seen[name]; ok {
+				if first == p.Path {
+					continue
+				}
+				p.Warning = fmt.Sprintf("shadowed by %s", first)
+			} else {
+				seen[name] = p.Path
+			}
+			plugins = append(plugins, p)
+		}
+	}
+	return plugins
+}
+
+// lookupPlugin returns the path of the plugin providing the named command.
+func lookupPlugin(name string) (string, bool) {
+	for _, p := range findPlugins() {
+		if p.Name == name {
+			return p.Path, true
+		}
+	}
+	return "", false
+}
+
+// pluginEnvironment passes the resolved configuration on to plugins. Plugins
+// may not need all of it, so values missing in the configuration are left
+// empty instead of failing.
+func (c *cli) pluginEnvironment() []string {
+	cfg, err := c.loadConfig()
+	if err != nil {
+		return nil
+	}
+	cfg.Fatal = func(string) {}
+	env := []string{
+		"VALAR_ENDPOINT_URL=" + cfg.Endpoint(),
+		"VALAR_TOKEN=" + cfg.Token(),
+	}
+	svc, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, cfg)
+	if err != nil {
+		return env
+	}
+	yaml := svc.Unwrap()
+	return append(env,
+		"VALAR_PROJECT="+svc.Project(),
+		"VALAR_SERVICE="+svc.Service(),
+		"VALAR_SERVICE_CONFIG="+yaml.FilePath(),
+	)
+}
+
+// runPlugin runs the plugin executable with the given arguments and exits
+// with its status code.
+func (c *cli) runPlugin(ctx context.Context, path string, args []string) error {
+	plugin := exec.Command(path, args...)
+	plugin.Env = append(os.Environ(), c.pluginEnvironment()...)
+	plugin.Stdin, plugin.Stdout, plugin.Stderr = c.In, c.Out, c.Err
+	if plugin.Stdin == nil {
+		plugin.Stdin = os.Stdin
+	}
+	if plugin.Stdout == nil {
+		plugin.Stdout = os.Stdout
+	}
+	if plugin.Stderr == nil {
+		plugin.Stderr = os.Stderr
+	}
+	// Interrupts reach the plugin directly, so it decides on its own how to
+	// wind down.
+	err := plugin.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		code := exitErr.ExitCode()
+		if code < 0 {
+			code = exitFailure
+		}
+		c.Exit(code)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("run plugin %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+func newPluginCmd(c *cli) *cobra.Command {
+	pluginCmd := &cobra.Command{
+		Use:   "plugin",
+		Short: "Manage executables extending the CLI.",
+		Long: `Manage executables extending the CLI.
+
+Any executable named valar-<name> in ~/.valar/plugins or on the PATH can be
+run as valar <name>, unless a built-in command of that name exists. Plugins
+receive the resolved configuration in the environment variables
+VALAR_ENDPOINT_URL, VALAR_TOKEN, VALAR_PROJECT, VALAR_SERVICE and
+VALAR_SERVICE_CONFIG.`,
+	}
+	pluginCmd.AddCommand(newPluginListCmd(c))
+	return pluginCmd
+}
+
+func newPluginListCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the plugins found.",
+		Args:  cobra.NoArgs,
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			plugins := findPlugins()
+			for i, p := range plugins {
+				if builtin, _, err := cmd.Root().Find([]string{p.Name}); err == nil && builtin != cmd.Root() {
+					plugins[i].Warning = "overridden by built-in command"
+				}
+			}
+			return c.printResult(cmd.OutOrStdout(), plugins, func(w io.Writer, wide bool) {
+				tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+				fmt.Fprintln(tw, "NAME\tPATH\tWARNING")
+				for _, p := range plugins {
+					fmt.Fprintln(tw, strings.Join([]string{p.Name, p.Path, p.Warning}, "\t"))
+				}
+				tw.Flush()
+			})
+		}),
+	}
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/schollz/progressbar/v3"
+)
+
+// progressInterval is how often the progress of an upload is reported when
+// it cannot be shown as a bar.
+var progressInterval = 5 * time.Second
+
+// uploadProgress reports how much of an upload has been read, as a bar with
+// throughput and remaining time if the log is a terminal and as a line every
+// progressInterval otherwise.
+type uploadProgress struct {
+	r   io.Reader
+	log io.Writer
+	bar *progressbar.ProgressBar
+	// size is the size of the upload, or -1 if it is compressed while it is
+	// uploaded. The remaining time of such uploads is estimated from the
+	// fraction of the source compressed so far, as returned by compressed.
+	size       int64
+	compressed func() float64
+	now        func() time.Time
+
+	// sent is updated by the transport reading the upload while the command
+	// may read it.
+	sent              atomic.Int64
+	start, lastReport time.Time
+}
+
+func newUploadProgress(log io.Writer, r io.Reader, size int64, compressed func() float64, now func() time.Time) *uploadProgress {
+	p := &uploadProgress{r: r, log: log, size: size, compressed: compressed, now: now, start: now()}
+	p.lastReport = p.start
+	if terminalWidth(log) > 0 {
+		p.bar = progressbar.NewOptions64(size,
+			progressbar.OptionSetWriter(log),
+			progressbar.OptionSetDescription("Uploading"),
+			progressbar.OptionShowBytes(true),
+			progressbar.OptionShowTotalBytes(true),
+			progressbar.OptionShowCount(),
+			progressbar.OptionUseIECUnits(true),
+			progressbar.OptionSetWidth(20),
+			progressbar.OptionSpinnerType(14),
+			progressbar.OptionThrottle(100*time.Millisecond),
+			progressbar.OptionClearOnFinish(),
+		)
+	}
+	return p
+}
+
+func (p *uploadProgress) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.sent.Add(int64(n))
+	now := p.now()
+	if p.bar == nil {
+		if now.Sub(p.lastReport) >= progressInterval {
+			p.lastReport = now
+			p.report(now)
+		}
+		return n, err
+	}
+	// The bar predicts the remaining time of uploads of known size itself.
+	if p.size < 0 && now.Sub(p.lastReport) >= time.Second {
+		p.lastReport = now
+		if left, ok := p.remaining(now); ok {
+			p.bar.Describe(fmt.Sprintf("Uploading, about %s left", left))
+		}
+	}
+	p.bar.Add(n)
+	return n, err
+}
+
+// remaining estimates the time left to upload, if possible yet.
+func (p *uploadProgress) remaining(now time.Time) (time.Duration, bool) {
+	elapsed := now.Sub(p.start)
+	var done float64
+	switch {
+	case p.size > 0:
+		done = float64(p.sent.Load()) / float64(p.size)
+	case p.size < 0 && p.compressed != nil:
+		done = p.compressed()
+	}
+	if done <= 0 {
+		return 0, false
+	}
+	left := time.Duration(float64(elapsed) * (1 - done) / done)
+	return max(left, 0).Round(time.Second), true
+}
+
+func (p *uploadProgress) report(now time.Time) {
+	sent, rate := humanize.IBytes(uint64(p.sent.Load())), humanize.IBytes(uint64(p.rate(now)))
+	left, ok := p.remaining(now)
+	switch {
+	case ok && p.size >= 0:
+		fmt.Fprintf(p.log, "Uploaded %s of %s (%s/s, %s left).\n", sent, humanize.IBytes(uint64(p.size)), rate, left)
+	case ok:
+		fmt.Fprintf(p.log, "Uploaded %s (%s/s, about %s left).\n", sent, rate, left)
+	default:
+		fmt.Fprintf(p.log, "Uploaded %s (%s/s).\n", sent, rate)
+	}
+}
+
+// rate returns the average throughput in bytes per second.
+func (p *uploadProgress) rate(now time.Time) float64 {
+	elapsed := now.Sub(p.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(p.sent.Load()) / elapsed
+}
+
+// finish removes the bar and reports the throughput of a completed upload.
+func (p *uploadProgress) finish(err error) {
+	if p.bar != nil {
+		p.bar.Clear()
+	}
+	if err != nil {
+		return
+	}
+	now := p.now()
+	fmt.Fprintf(p.log, "Uploaded %s in %s (%s/s).\n", humanize.IBytes(uint64(p.sent.Load())), now.Sub(p.start).Round(100*time.Millisecond), humanize.IBytes(uint64(p.rate(now))))
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// tickingReader yields chunks of data, advancing the clock by a second with
+// each one.
+type tickingReader struct {
+	n, chunk int
+	now      *time.Time
+}
+
+func (r *tickingReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	n := min(len(p), r.chunk, r.n)
+	r.n -= n
+	*r.now = r.now.Add(time.Second)
+	return n, nil
+}
+
+func TestUploadProgressReport(t *testing.T) {
+	interval := progressInterval
+	progressInterval = 2 * time.Second
+	t.Cleanup(func() { progressInterval = interval })
+
+	for _, tt := range []struct {
+		name string
+		size int64
+		want string
+	}{
+		{"known size", 4096, "Uploaded 2.0 KiB of 4.0 KiB (1.0 KiB/s, 2s left).\n" +
+			"Uploaded 4.0 KiB of 4.0 KiB (1.0 KiB/s, 0s left).\n" +
+			"Uploaded 4.0 KiB in 4s (1.0 KiB/s).\n"},
+		{"compressed while uploading", -1, "Uploaded 2.0 KiB (1.0 KiB/s, about 2s left).\n" +
+			"Uploaded 4.0 KiB (1.0 KiB/s, about 0s left).\n" +
+			"Uploaded 4.0 KiB in 4s (1.0 KiB/s).\n"},
+	} {
+		now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+		r := &tickingReader{n: 4096, chunk: 1024, now: &now}
+		var log bytes.Buffer
+		p := newUploadProgress(&log, r, tt.size, func() float64 { return float64(4096-r.n) / 4096 }, func() time.Time { return now })
+		if _, err := io.Copy(io.Discard, p); err != nil {
+			t.Fatal(err)
+		}
+		p.finish(nil)
+		if log.String() != tt.want {
+			t.Errorf("%s: reported\n%s\nwant\n%s", tt.name, log.String(), tt.want)
+		}
+	}
+}
@@ -2,7 +2,7 @@ package cmd
 
 import (
 	"fmt"
-	"os"
+	"io"
 	"strings"
 	"text/tabwriter"
 
@@ -12,17 +12,17 @@ import (
 	"github.com/valar/cli/config"
 )
 
-var (
-	authListCmd = &cobra.Command{
+func newAuthListCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
 		Use:   "list [path]",
 		Short: "List permissions for a path prefix.",
 		Args:  cobra.MaximumNArgs(1),
-		Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-			client, err := globalConfiguration.APIClient()
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			client, err := c.apiClient(cmd.Context())
 			if err != nil {
 				return err
 			}
-			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, globalConfiguration)
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, c.config)
 			if err != nil {
 				return err
 			}
@@ -34,48 +34,64 @@ var (
 				}
 				namespace, prefix = subargs[0], subargs[1]
 			}
-			permissions, err := client.ListPermissions(cfg.Project(), namespace, prefix)
+			permissions, err := client.ListPermissionsContext(cmd.Context(), cfg.Project(), namespace, prefix)
 			if err != nil {
 				return err
 			}
-			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
-			fmt.Fprintln(tw, "PATH\tUSER\tACTION\tSTATE")
-			for _, pm := range permissions {
-				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", pm.Path, pm.User, pm.Action, pm.State)
-			}
-			tw.Flush()
-			return nil
+			return c.printResult(cmd.OutOrStdout(), permissions, func(w io.Writer, wide bool) {
+				tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+				fmt.Fprintln(tw, "PATH\tUSER\tACTION\tSTATE")
+				for _, pm := range permissions {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", pm.Path, pm.User, pm.Action, pm.State)
+				}
+				tw.Flush()
+			})
 		}),
 	}
+}
 
-	authAllowCmd = &cobra.Command{
-		Use:   "allow path user action",
-		Short: "Modify permissions for a path and user.",
-		Args:  cobra.ExactArgs(3),
-		Run:   runAndHandle(authModifyWithState("allow")),
+func newAuthAllowCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:               "allow path user action",
+		Short:             "Modify permissions for a path and user.",
+		Args:              cobra.ExactArgs(3),
+		ValidArgsFunction: completePermissionArgs,
+		Run:               c.runAndHandle(c.authModifyWithState("allow")),
 	}
-	authForbidCmd = &cobra.Command{
-		Use:   "forbid path user action",
-		Short: "Forbid a specific action for a path and user.",
-		Args:  cobra.ExactArgs(3),
-		Run:   runAndHandle(authModifyWithState("forbid")),
+}
+
+func newAuthForbidCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:               "forbid path user action",
+		Short:             "Forbid a specific action for a path and user.",
+		Args:              cobra.ExactArgs(3),
+		ValidArgsFunction: completePermissionArgs,
+		Run:               c.runAndHandle(c.authModifyWithState("forbid")),
 	}
-	authClearCmd = &cobra.Command{
-		Use:   "clear path user action",
-		Short: "Remove the permission for a path and user.",
-		Args:  cobra.ExactArgs(3),
-		Run:   runAndHandle(authModifyWithState("unset")),
+}
+
+func newAuthClearCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:               "clear path user action",
+		Short:             "Remove the permission for a path and user.",
+		Args:              cobra.ExactArgs(3),
+		ValidArgsFunction: completePermissionArgs,
+		Run:               c.runAndHandle(c.authModifyWithState("unset")),
 	}
-	authCheckCmd = &cobra.Command{
-		Use:   "check path user action",
-		Short: "Check if a user can perform an action.",
-		Args:  cobra.ExactArgs(3),
-		Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-			client, err := globalConfiguration.APIClient()
+}
+
+func newAuthCheckCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:               "check path user action",
+		Short:             "Check if a user can perform an action.",
+		Args:              cobra.ExactArgs(3),
+		ValidArgsFunction: completePermissionArgs,
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			client, err := c.apiClient(cmd.Context())
 			if err != nil {
 				return err
 			}
-			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, globalConfiguration)
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, c.config)
 			if err != nil {
 				return err
 			}
@@ -92,53 +108,66 @@ var (
 				User:   user,
 				Action: args[2],
 			}
-			allowed, err := client.CheckPermission(cfg.Project(), permission)
+			allowed, err := client.CheckPermissionContext(cmd.Context(), cfg.Project(), permission)
 			if err != nil {
 				return err
 			}
 			if allowed {
-				fmt.Println("allowed")
+				fmt.Fprintln(cmd.OutOrStdout(), "allowed")
 			} else {
-				fmt.Println("forbidden")
+				fmt.Fprintln(cmd.OutOrStdout(), "forbidden")
 			}
 			return nil
 		}),
 	}
+}
 
-	authCmd = &cobra.Command{
+func newAuthCmd(c *cli) *cobra.Command {
+	authCmd := &cobra.Command{
 		Use:   "auth",
 		Short: "Manage user and service permissions.",
 	}
+	authCmd.AddCommand(
+		newAuthListCmd(c),
+		newAuthAllowCmd(c),
+		newAuthForbidCmd(c),
+		newAuthClearCmd(c),
+		newAuthCheckCmd(c),
+	)
+	return authCmd
+}
 
-	whoamiCmd = &cobra.Command{
+func newWhoamiCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
 		Use:   "whoami",
 		Short: "Show the currently authenticated user.",
 		Args:  cobra.NoArgs,
-		Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-			client, err := globalConfiguration.APIClient()
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			client, err := c.apiClient(cmd.Context())
 			if err != nil {
 				return err
 			}
-			info, err := client.UserInfo()
+			info, err := client.UserInfoContext(cmd.Context())
 			if err != nil {
 				return err
 			}
-			tw := ansiterm.NewTabWriter(os.Stdout, 6, 0, 1, ' ', 0)
-			fmt.Fprintf(tw, "User:\t%s\n", info.Name)
-			fmt.Fprintf(tw, "Projects:\t%s\n", strings.Join(info.Projects, ", "))
-			tw.Flush()
-			return nil
+			return c.printResult(cmd.OutOrStdout(), info, func(w io.Writer, wide bool) {
+				tw := ansiterm.NewTabWriter(w, 6, 0, 1, ' ', 0)
+				fmt.Fprintf(tw, "User:\t%s\n", info.Name)
+				fmt.Fprintf(tw, "Projects:\t%s\n", strings.Join(info.Projects, ", "))
+				tw.Flush()
+			})
 		}),
 	}
-)
+}
 
-func authModifyWithState(state string) func(cmd *cobra.Command, args []string) error {
+func (c *cli) authModifyWithState(state string) func(cmd *cobra.Command, args []string) error {
 	return func(cmd *cobra.Command, args []string) error {
-		client, err := globalConfiguration.APIClient()
+		client, err := c.apiClient(cmd.Context())
 		if err != nil {
 			return err
 		}
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, globalConfiguration)
+		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, c.config)
 		if err != nil {
 			return err
 		}
@@ -156,21 +185,15 @@ func authModifyWithState(state string) func(cmd *cobra.Command, args []string) e
 			Action: args[2],
 			State:  state,
 		}
-		modified, err := client.ModifyPermission(cfg.Project(), permission)
+		modified, err := client.ModifyPermissionContext(cmd.Context(), cfg.Project(), permission)
 		if err != nil {
 			return err
 		}
 		if modified {
-			fmt.Println("modified")
+			fmt.Fprintln(cmd.OutOrStdout(), "modified")
 		} else {
-			fmt.Println("unchanged")
+			fmt.Fprintln(cmd.OutOrStdout(), "unchanged")
 		}
 		return nil
 	}
 }
-
-func initProjectsCmd() {
-	authCmd.AddCommand(authListCmd, authAllowCmd, authForbidCmd, authClearCmd, authCheckCmd)
-	rootCmd.AddCommand(authCmd)
-	rootCmd.AddCommand(whoamiCmd)
-}
@@ -1,67 +1,261 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/valar/cli/api"
 	"github.com/valar/cli/config"
 )
 
 var version string
 
-var rootCmd = &cobra.Command{
-	Use:   "valar",
-	Short: "Valar is a next-generation serverless platform.",
-	Long: `Valar is a next-generation serverless platform.
+// Options are the dependencies of a command tree. Fields left empty fall
+// back to the ones of the valar executable.
+type Options struct {
+	// LoadConfig loads the CLI configuration before a command runs, writing
+	// warnings to stderr.
+	LoadConfig func(stderr io.Writer) (*config.CLIConfig, error)
+	// NewClient creates an API client for the loaded configuration.
+	NewClient func(ctx context.Context, cfg *config.CLIConfig) (*api.Client, error)
+	// In, Out and Err replace the standard streams.
+	In  io.Reader
+	Out io.Writer
+	Err io.Writer
+	// Now tells the current time, e.g. to humanize timestamps or to tell
+	// whether a token expires soon.
+	Now func() time.Time
+	// Exit ends the program with the given status code.
+	Exit func(code int)
+	// OpenURL opens a URL in the browser of the user.
+	OpenURL func(url string) error
+	// Version is reported by the --version flag.
+	Version string
+}
 
-You code. We do the rest.
-We take care while you do what you do best.`,
-	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-		var err error
-		globalConfiguration, err = config.NewCLIConfigFromEnvironment()
-		if err != nil {
-			return fmt.Errorf("configure interface: %w", err)
+// cli is the state shared by the commands of one tree.
+type cli struct {
+	Options
+	config    *config.CLIConfig
+	overrides config.Overrides
+	retries   int
+	debug     bool
+	output    string
+}
+
+// NewRootCommand returns a fresh valar command tree. Trees do not share any
+// state, so several of them may be used in one process.
+func NewRootCommand(opts Options) *cobra.Command {
+	return newCLI(opts).rootCommand()
+}
+
+// newCLI fills in the defaults of the options.
+func newCLI(opts Options) *cli {
+	if opts.LoadConfig == nil {
+		opts.LoadConfig = config.NewCLIConfigFromEnvironment
+	}
+	if opts.NewClient == nil {
+		opts.NewClient = func(ctx context.Context, cfg *config.CLIConfig) (*api.Client, error) {
+			return cfg.APIClientContext(ctx)
 		}
-		return nil
-	},
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Exit == nil {
+		opts.Exit = os.Exit
+	}
+	if opts.OpenURL == nil {
+		opts.OpenURL = openBrowser
+	}
+	return &cli{Options: opts}
 }
 
-var globalConfiguration *config.CLIConfig
+func (c *cli) rootCommand() *cobra.Command {
+	rootCmd := &cobra.Command{
+		Use:   "valar",
+		Short: "Valar is a next-generation serverless platform.",
+		Long: `Valar is a next-generation serverless platform.
 
-func init() {
-	// Try to load config, if not found we're fine
+You code. We do the rest.
+We take care while you do what you do best.`,
+		Version:           c.Version,
+		PersistentPreRunE: c.configure,
+	}
+	rootCmd.SetIn(c.In)
+	rootCmd.SetOut(c.Out)
+	rootCmd.SetErr(c.Err)
 	rootCmd.SetVersionTemplate("Valar CLI {{.Version}}\n")
-	rootCmd.Version = version
-	// Configure projects.go
-	initProjectsCmd()
-	// Configure deployments.go
-	initDeploymentsCmd()
-	// Configure builds.go
-	initBuildsCmd()
-	// Configure services.go
-	initServicesCmd()
-	// Configure env.go
-	initEnvCmd()
-	// Configure domains.go
-	initDomainsCmd()
-	// Configure config.go
-	initConfigCmd()
-	// Configure cron.go
-	initCronCmd()
+	rootCmd.PersistentFlags().IntVar(&c.retries, "retries", 0, "Number of times a failed API call is retried (defaults to the endpoint configuration)")
+	rootCmd.PersistentFlags().BoolVar(&c.debug, "debug", false, "Print diagnostic output, e.g. on retries")
+	rootCmd.PersistentFlags().StringVarP(&c.output, "output", "o", outputTable, "Output format of listings (table|wide|json|yaml|go-template=...|jsonpath=...)")
+	rootCmd.RegisterFlagCompletionFunc("output", completeStatic(outputTable, outputWide, outputJSON, outputYAML, outputTemplatePrefix))
+	rootCmd.PersistentFlags().StringVar(&c.overrides.Context, "context", "", "Context to use instead of the active one")
+	rootCmd.PersistentFlags().StringVar(&c.overrides.Project, "project", "", "Project to use instead of the one of the context or .valar.yml")
+	rootCmd.PersistentFlags().StringVar(&c.overrides.Endpoint, "endpoint", "", "Name or URL of the API endpoint to use instead of the one of the context")
+	rootCmd.PersistentFlags().StringVar(&c.overrides.Token, "token", "", "API token to use instead of the one of the endpoint")
+	rootCmd.PersistentFlags().StringVar(&c.overrides.Profile, "profile", "", "Profile of .valar.yml to apply instead of the one of the context")
+	rootCmd.RegisterFlagCompletionFunc("context", c.completeConfigNames(contextNames))
+	rootCmd.RegisterFlagCompletionFunc("endpoint", c.completeConfigNames(endpointNames))
+	rootCmd.RegisterFlagCompletionFunc("profile", completeProfiles)
+	rootCmd.AddCommand(
+		newAuthCmd(c),
+		newWhoamiCmd(c),
+		newLoginCmd(c),
+		newLogoutCmd(c),
+		newDeploymentCmd(c),
+		newBuildCmd(c),
+		newServiceCmd(c),
+		newEnvCmd(c),
+		newDomainCmd(c),
+		newConfigCmd(c),
+		newCronCmd(c),
+		newDevCmd(c),
+		newPluginCmd(c),
+		newDoctorCmd(c),
+	)
+	return rootCmd
 }
 
-func runAndHandle(f func(*cobra.Command, []string) error) func(*cobra.Command, []string) {
+// configure loads the configuration before any command runs.
+func (c *cli) configure(cmd *cobra.Command, args []string) error {
+	if err := checkOutputFormat(c.output); err != nil {
+		return err
+	}
+	cfg, err := c.loadConfig()
+	if err != nil {
+		return fmt.Errorf("configure interface: %w", err)
+	}
+	c.applyFlags(cmd, cfg)
+	cfg.Fatal = func(message string) {
+		fmt.Fprintln(cmd.ErrOrStderr(), message)
+		c.Exit(1)
+	}
+	c.config = cfg
+	return nil
+}
+
+// applyFlags applies the global flags tuning API calls to the configuration.
+func (c *cli) applyFlags(cmd *cobra.Command, cfg *config.CLIConfig) {
+	if cmd.Flags().Changed("retries") {
+		cfg.Retries = &c.retries
+	}
+	if c.debug {
+		cfg.Debug = cmd.ErrOrStderr()
+	}
+}
+
+// loadConfig loads the configuration and applies the overrides given by
+// flags, which take precedence over the environment variables.
+func (c *cli) loadConfig() (*config.CLIConfig, error) {
+	cfg, err := c.LoadConfig(c.Err)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Stderr = c.Err
+	cfg.Now = c.Now
+	cfg.Overrides.Merge(c.overrides)
+	if err := cfg.CheckOverrides(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// apiClient creates a client for the configured endpoint.
+func (c *cli) apiClient(ctx context.Context) (*api.Client, error) {
+	return c.NewClient(ctx, c.config)
+}
+
+func (c *cli) humanizeTime(t time.Time) string {
+	return humanize.RelTime(t, c.Now(), "ago", "from now")
+}
+
+func (c *cli) runAndHandle(f func(*cobra.Command, []string) error) func(*cobra.Command, []string) {
 	return func(cmd *cobra.Command, args []string) {
 		if err := f(cmd, args); err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+			c.Exit(reportError(cmd.ErrOrStderr(), err))
 		}
 	}
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+	// Cancel the command context on the first interrupt, so that streams and
+	// uploads can wind down. A second interrupt kills the process as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	c := newCLI(Options{Version: version})
+	if err := c.execute(ctx, c.rootCommand(), os.Args[1:]); err != nil {
+		code, _ := classifyError(err)
+		os.Exit(code)
+	}
+}
+
+// execute runs the command tree, or a plugin if the first argument after the
+// global flags does not name a built-in command.
+func (c *cli) execute(ctx context.Context, root *cobra.Command, args []string) error {
+	flags := root.PersistentFlags()
+	if i := commandIndex(flags, args); i >= 0 && !strings.HasPrefix(args[i], "__") {
+		root.InitDefaultHelpCmd()
+		root.InitDefaultCompletionCmd()
+		if _, _, err := root.Find(args[i:]); err != nil {
+			if path, ok := lookupPlugin(args[i]); ok {
+				// The global flags apply to the environment of the plugin.
+				if err := flags.Parse(args[:i]); err != nil {
+					return err
+				}
+				return c.runPlugin(ctx, path, args[i+1:])
+			}
+		}
+	}
+	root.SetArgs(args)
+	return root.ExecuteContext(ctx)
+}
+
+// commandIndex returns the index of the first argument that is neither one
+// of the flags nor the value of one, or -1 if there is none or an argument
+// is an unknown flag.
+func commandIndex(flags *pflag.FlagSet, args []string) int {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return -1
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			return i
+		}
+		var flag *pflag.Flag
+		if name, ok := strings.CutPrefix(arg, "--"); ok {
+			name, _, inline := strings.Cut(name, "=")
+			if flag = flags.Lookup(name); flag == nil {
+				return -1
+			}
+			if inline {
+				continue
+			}
+		} else {
+			if flag = flags.ShorthandLookup(arg[1:2]); flag == nil {
+				return -1
+			}
+			if len(arg) > 2 {
+				continue
+			}
+		}
+		// Flags other than booleans take the next argument as their value.
+		if flag.NoOptDefVal == "" {
+			i++
+		}
 	}
+	return -1
 }
@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/valar/cli/config"
+)
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  string
+		token string
+	}{
+		{name: "service_list", args: "service list"},
+		{name: "build_list", args: "build list"},
+		{name: "build_inspect", args: "build inspect b0a1"},
+		{name: "build_logs", args: "build logs b0a1"},
+		{name: "build_logs_raw", args: "build logs --raw"},
+		{name: "build_status_failed", args: "build status f1e2"},
+		{name: "deployment_list", args: "deployment list"},
+		{name: "deployment_rollback", args: "deployment rollback"},
+		{name: "domain_add", args: "domain add shop.example"},
+		{name: "domain_add_conflict", args: "domain add acme.example"},
+		{name: "domain_list", args: "domain list"},
+		{name: "cron_inspect", args: "cron inspect nightly"},
+		{name: "cron_inspect_missing", args: "cron inspect weekly"},
+		{name: "auth_list", args: "auth list"},
+		{name: "env_list", args: "env list"},
+		{name: "env_list_build", args: "env list --build"},
+		{name: "service_list_json", args: "service list -o json"},
+		{name: "build_list_wide", args: "build list -o wide"},
+		{name: "build_inspect_yaml", args: "build inspect b0a1 -o yaml"},
+		{name: "cron_inspect_json", args: "cron inspect nightly -o json"},
+		{name: "auth_list_yaml", args: "auth list -o yaml"},
+		{name: "whoami_template", args: "whoami -o go-template={{.name}}:{{range.projects}}{{.}}{{end}}"},
+		{name: "build_list_jsonpath", args: "build list -o jsonpath={range[*]}{.id}:{.status}{\"\\n\"}{end}"},
+		{name: "output_jsonpath_invalid", args: "service list -o jsonpath={.[}"},
+		{name: "output_unknown", args: "service list -o xml"},
+		{name: "invalid_token", args: "service list", token: "wrong"},
+		{name: "missing_token", args: "service list", token: "-"},
+		{name: "context_unknown", args: "service list --context staging"},
+		{name: "endpoint_unknown", args: "service list --endpoint prdo"},
+		{name: "token_flag", args: "service list --token wrong"},
+		{name: "complete_build", args: "__complete build inspect b0"},
+		{name: "complete_schedule", args: "__complete cron trigger n"},
+		{name: "complete_service_flag", args: "__complete deployment list --service w"},
+		{name: "complete_permission_action", args: "__complete auth allow service:acme/web user:bob i"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := "secret"
+			switch tt.token {
+			case "":
+			case "-":
+				token = ""
+			default:
+				token = tt.token
+			}
+			golden, err := filepath.Abs(filepath.Join("testdata", tt.name+".golden"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			setupWorkspace(t, token)
+			stdout, stderr, code := runCommand(t, strings.Fields(tt.args)...)
+			got := fmt.Sprintf("$ valar %s\n-- stdout --\n%s-- stderr --\n%s-- exit code --\n%d\n", tt.args, stdout, stderr, code)
+
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("read golden file (run with -update to create it): %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("output of valar %s differs from %s:\n--- got ---\n%s--- want ---\n%s", tt.args, golden, got, want)
+			}
+		})
+	}
+}
+
+func TestNewRootCommandIsolation(t *testing.T) {
+	srv := newFakeAPI().Start()
+	defer srv.Close()
+	t.Chdir(t.TempDir())
+
+	var loads int
+	newRoot := func(out *bytes.Buffer) *cobra.Command {
+		return NewRootCommand(Options{
+			LoadConfig: func(io.Writer) (*config.CLIConfig, error) {
+				loads++
+				return &config.CLIConfig{
+					ActiveContext: "test",
+					Endpoints:     map[string]config.APIEndpoint{"test": {URL: srv.URL, Token: "secret"}},
+					Contexts:      map[string]config.CLIContext{"test": {Endpoint: "test", Project: "acme"}},
+				}, nil
+			},
+			Out:  out,
+			Err:  out,
+			Now:  func() time.Time { return testNow },
+			Exit: func(code int) { panic(exitSignal(code)) },
+		})
+	}
+
+	var first, second bytes.Buffer
+	firstRoot, secondRoot := newRoot(&first), newRoot(&second)
+	firstRoot.SetArgs([]string{"build", "list", "--service", "web"})
+	if err := firstRoot.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(first.String(), "b0a1c2d3e4f50617") {
+		t.Errorf("first tree did not list the builds of web:\n%s", first.String())
+	}
+
+	// The second tree must not see the --service flag of the first one.
+	secondRoot.SetArgs([]string{"build", "list"})
+	func() {
+		defer func() {
+			if r := recover(); r != exitSignal(1) {
+				t.Errorf("second tree exited with %v, want 1", r)
+			}
+		}()
+		secondRoot.Execute()
+	}()
+	if !strings.Contains(second.String(), "requires service reference") {
+		t.Errorf("second tree did not complain about the missing service:\n%s", second.String())
+	}
+	if loads != 2 {
+		t.Errorf("configuration was loaded %d times, want 2", loads)
+	}
+}
+
+func TestOverridePrecedence(t *testing.T) {
+	setupWorkspace(t, "secret")
+	t.Setenv("VALAR_TOKEN", "wrong")
+	if _, _, code := runCommand(t, "service", "list"); code != exitUnauthorized {
+		t.Errorf("VALAR_TOKEN: exit code %d, want %d", code, exitUnauthorized)
+	}
+	if _, _, code := runCommand(t, "service", "list", "--token", "secret"); code != 0 {
+		t.Errorf("--token over VALAR_TOKEN: exit code %d, want 0", code)
+	}
+	// A project given explicitly takes precedence over the one of .valar.yml.
+	t.Setenv("VALAR_PROJECT", "other")
+	if _, _, code := runCommand(t, "service", "list", "--token", "secret"); code != exitNotFound {
+		t.Errorf("VALAR_PROJECT: exit code %d, want %d", code, exitNotFound)
+	}
+}
+
+func TestConfigDiagnostics(t *testing.T) {
+	setupWorkspace(t, "secret")
+	missing := filepath.Join(t.TempDir(), "missing")
+	t.Setenv("VALARCONFIG", missing+";"+os.Getenv("VALARCONFIG"))
+	if err := os.Remove(functionConfiguration); err != nil {
+		t.Fatal(err)
+	}
+	// Warnings and missing values are reported on the error stream of the
+	// command.
+	_, stderr, code := runCommand(t, "build", "list")
+	if code != 1 || !strings.Contains(stderr, "Warning: Could not read config file "+missing) || !strings.Contains(stderr, "Operation requires service reference") {
+		t.Errorf("build list without a service exited with %d: %s", code, stderr)
+	}
+}
+
+func TestPlugins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugins are shell scripts")
+	}
+	setupWorkspace(t, "secret")
+	home, bin := t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("PATH", bin)
+	script := "#!/bin/sh\necho \"$0 $*\"\necho \"$VALAR_TOKEN $VALAR_PROJECT $VALAR_SERVICE ${VALAR_SERVICE_CONFIG##*/}\"\nexit 3\n"
+	for _, path := range []string{
+		filepath.Join(home, ".valar", "plugins", "valar-promote"),
+		filepath.Join(bin, "valar-promote"),
+		filepath.Join(bin, "valar-build"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stdout, _, code := runCommand(t, "promote", "--to", "production")
+	want := filepath.Join(home, ".valar", "plugins", "valar-promote") + " --to production\nsecret acme web .valar.yml\n"
+	if stdout != want || code != 3 {
+		t.Errorf("valar promote = %q, exit code %d, want %q, exit code 3", stdout, code, want)
+	}
+
+	// Global flags before the name of the plugin apply to its environment.
+	stdout, _, code = runCommand(t, "--project", "other", "-o", "json", "--debug", "promote", "now")
+	want = filepath.Join(home, ".valar", "plugins", "valar-promote") + " now\nsecret other web .valar.yml\n"
+	if stdout != want || code != 3 {
+		t.Errorf("valar --project other promote = %q, exit code %d, want %q, exit code 3", stdout, code, want)
+	}
+	if _, stderr, code := runCommand(t, "--unknown", "promote"); code == 0 || !strings.Contains(stderr, "unknown flag: --unknown") {
+		t.Errorf("valar --unknown promote exited with %d: %s", code, stderr)
+	}
+
+	stdout, _, code = runCommand(t, "plugin", "list", "-o", "json")
+	if code != 0 {
+		t.Fatalf("valar plugin list exited with %d", code)
+	}
+	for _, want := range []string{`"warning": "shadowed by `, `"warning": "overridden by built-in command"`} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("valar plugin list = %s, want it to contain %s", stdout, want)
+		}
+	}
+}
+
+func TestCommandIndex(t *testing.T) {
+	flags := pflag.NewFlagSet("valar", pflag.ContinueOnError)
+	flags.StringP("context", "c", "", "")
+	flags.BoolP("verbose", "v", false, "")
+	for _, tt := range []struct {
+		args string
+		want int
+	}{
+		{"build push", 0},
+		{"--context prod build", 2},
+		{"--context=prod build", 1},
+		{"-c prod -v build", 3},
+		{"-cprod build", 1},
+		{"--verbose build", 1},
+		{"--unknown build", -1},
+		{"-x build", -1},
+		{"--context prod -- build", -1},
+		{"-v - build", 1},
+		{"--context", -1},
+	} {
+		if got := commandIndex(flags, strings.Fields(tt.args)); got != tt.want {
+			t.Errorf("commandIndex(%q) = %d, want %d", tt.args, got, tt.want)
+		}
+	}
+}
@@ -1,171 +1,304 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"text/tabwriter"
 
-	"github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
 	"github.com/valar/cli/api"
 	"github.com/valar/cli/config"
+	"gopkg.in/yaml.v3"
 )
 
 const functionConfiguration = ".valar.yml"
 
-var initIgnore []string
-var initProject, initConstructor string
-var initForce bool
+func newServiceCmd(c *cli) *cobra.Command {
+	serviceCmd := &cobra.Command{
+		Use:     "service",
+		Short:   "Manage individual services.",
+		Aliases: []string{"svc", "services"},
+	}
+	serviceCmd.AddCommand(
+		newServiceListCmd(c),
+		newServiceLogsCmd(c),
+		newServiceInitCmd(c),
+		newServiceEnableCmd(c),
+		newServiceDisableCmd(c),
+		newServiceConfigCmd(c),
+		newServiceValidateCmd(c),
+		newServiceSchemaCmd(c),
+	)
+	return serviceCmd
+}
 
-var serviceCmd = &cobra.Command{
-	Use:     "service",
-	Short:   "Manage individual services.",
-	Aliases: []string{"svc", "services"},
+func newServiceInitCmd(c *cli) *cobra.Command {
+	var (
+		ignore               []string
+		project, constructor string
+		force                bool
+	)
+	serviceInitCmd := &cobra.Command{
+		Use:   "init service",
+		Short: "Configure a new service.",
+		Args:  cobra.ExactArgs(1),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			if project == "" {
+				project = c.config.Project()
+			}
+			if err := api.VerifyNames(project, args[0]); err != nil {
+				return fmt.Errorf("bad naming scheme: %w", err)
+			}
+			cfg := &config.ServiceConfigYAML{
+				Project: project,
+				Service: args[0],
+				Build: &config.BuildConfig{
+					Constructor: constructor,
+					Ignore:      ignore,
+				},
+				Deployment: &config.DeploymentConfig{},
+			}
+			if _, err := os.Stat(functionConfiguration); err == nil && !force {
+				return fmt.Errorf("configuration already exists, please use --force flag to override")
+			}
+			return cfg.WriteToFile(functionConfiguration)
+		}),
+	}
+	initPf := serviceInitCmd.PersistentFlags()
+	initPf.StringArrayVarP(&ignore, "ignore", "i", []string{".valar.yml", ".git", "node_modules"}, "Ignore files on push")
+	initPf.StringVarP(&constructor, "type", "t", "", "Build constructor type")
+	initPf.StringVarP(&project, "project", "p", "", "Project to deploy service to, defaults to project set in global config")
+	initPf.BoolVarP(&force, "force", "f", false, "Allow configuration override")
+	cobra.MarkFlagRequired(initPf, "type")
+	return serviceInitCmd
 }
 
-var serviceInitCmd = &cobra.Command{
-	Use:   "init service",
-	Short: "Configure a new service.",
-	Args:  cobra.ExactArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		if initProject == "" {
-			initProject = globalConfiguration.Project()
-		}
-		if err := api.VerifyNames(initProject, args[0]); err != nil {
-			return fmt.Errorf("bad naming scheme: %w", err)
-		}
-		cfg := &config.ServiceConfigYAML{
-			Project: initProject,
-			Service: args[0],
-			Build: &config.BuildConfig{
-				Constructor: initConstructor,
-				Ignore:      initIgnore,
-			},
-			Deployment: &config.DeploymentConfig{},
-		}
-		if _, err := os.Stat(functionConfiguration); err == nil && !initForce {
-			return fmt.Errorf("configuration already exists, please use --force flag to override")
-		}
-		return cfg.WriteToFile(functionConfiguration)
-	}),
+func newServiceListCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list [prefix]",
+		Short: "Show services in the current project.",
+		Args:  cobra.MaximumNArgs(1),
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			serviceCfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, c.config)
+			if err != nil {
+				return err
+			}
+			prefix := ""
+			if len(args) == 1 {
+				prefix = args[0]
+			}
+			services, err := client.ListServicesContext(cmd.Context(), serviceCfg.Project(), prefix)
+			if err != nil {
+				return fmt.Errorf("listing services: %w", err)
+			}
+			sort.Slice(services, func(i, j int) bool {
+				return services[i].DeployedAt.Before(services[j].DeployedAt)
+			})
+			return c.printResult(cmd.OutOrStdout(), services, func(w io.Writer, wide bool) {
+				tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+				if wide {
+					fmt.Fprintln(tw, "NAME\tVERSION\tCREATED\tLAST DEPLOYED\tDOMAINS\tID")
+				} else {
+					fmt.Fprintln(tw, "NAME\tVERSION\tCREATED\tLAST DEPLOYED\tDOMAINS")
+				}
+				for _, svc := range services {
+					row := []string{
+						svc.Name,
+						strconv.FormatInt(svc.Deployment, 10),
+						c.humanizeTime(svc.CreatedAt),
+						c.humanizeTime(svc.DeployedAt),
+						strings.Join(svc.Domains, " "),
+					}
+					if wide {
+						row = append(row, svc.ID)
+					}
+					fmt.Fprintln(tw, strings.Join(row, "\t"))
+				}
+				tw.Flush()
+			})
+		}),
+	}
 }
 
-var serviceListCmd = &cobra.Command{
-	Use:   "list [prefix]",
-	Short: "Show services in the current project.",
-	Args:  cobra.MaximumNArgs(1),
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		serviceCfg, err := config.NewServiceConfigWithFallback(functionConfiguration, nil, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		prefix := ""
-		if len(args) == 1 {
-			prefix = args[0]
-		}
-		services, err := client.ListServices(serviceCfg.Project(), prefix)
-		if err != nil {
-			return fmt.Errorf("listing services: %w", err)
-		}
-		sort.Slice(services, func(i, j int) bool {
-			return services[i].DeployedAt.Before(services[j].DeployedAt)
-		})
-		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
-		fmt.Fprintln(tw, "NAME\tVERSION\tCREATED\tLAST DEPLOYED\tDOMAINS")
-		for _, svc := range services {
-			fmt.Fprintln(tw, strings.Join([]string{
-				svc.Name,
-				strconv.FormatInt(svc.Deployment, 10),
-				humanize.Time(svc.CreatedAt),
-				humanize.Time(svc.DeployedAt),
-				strings.Join(svc.Domains, " "),
-			}, "\t"))
-		}
-		tw.Flush()
-		return nil
-	}),
+func newServiceLogsCmd(c *cli) *cobra.Command {
+	var (
+		follow  bool
+		tail    bool
+		lines   int
+		service string
+	)
+	serviceLogsCmd := &cobra.Command{
+		Use:   "logs [--service service]",
+		Short: "Show the logs of the latest deployment.",
+		Args:  cobra.NoArgs,
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &service, c.config)
+			if err != nil {
+				return err
+			}
+			return client.StreamServiceLogsContext(cmd.Context(), cfg.Project(), cfg.Service(), cmd.OutOrStdout(), follow, tail, lines)
+		}),
+	}
+	serviceLogsCmd.Flags().BoolVarP(&follow, "follow", "f", false, "Follow logs")
+	serviceLogsCmd.Flags().BoolVarP(&tail, "tail", "t", false, "Jump to end of logs")
+	serviceLogsCmd.Flags().IntVarP(&lines, "skip", "n", 0, "Lines to skip/rewind when reading logs")
+	serviceLogsCmd.Flags().StringVarP(&service, "service", "s", "", "The service to target")
+	serviceLogsCmd.RegisterFlagCompletionFunc("service", c.completeServices())
+	return serviceLogsCmd
 }
 
-var (
-	serviceLogsFollow  bool
-	serviceLogsTail    bool
-	serviceLogsLines   int
-	serviceLogsService string
-)
+func newServiceDisableCmd(c *cli) *cobra.Command {
+	var service string
+	serviceDisableCmd := &cobra.Command{
+		Use:   "disable [--service service]",
+		Short: "Disables the service.",
+		Args:  cobra.NoArgs,
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &service, c.config)
+			if err != nil {
+				return err
+			}
+			return client.ChangeServiceStatusContext(cmd.Context(), cfg.Project(), cfg.Service(), false)
+		}),
+	}
+	serviceDisableCmd.Flags().StringVarP(&service, "service", "s", "", "The service to target")
+	serviceDisableCmd.RegisterFlagCompletionFunc("service", c.completeServices())
+	return serviceDisableCmd
+}
 
-var serviceLogsCmd = &cobra.Command{
-	Use:   "logs [--service service]",
-	Short: "Show the logs of the latest deployment.",
-	Args:  cobra.NoArgs,
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &serviceLogsService, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		return client.StreamServiceLogs(cfg.Project(), cfg.Service(), os.Stdout, serviceLogsFollow, serviceLogsTail, serviceLogsLines)
-	}),
+func newServiceEnableCmd(c *cli) *cobra.Command {
+	var service string
+	serviceEnableCmd := &cobra.Command{
+		Use:   "enable [--service service]",
+		Short: "Enables the service.",
+		Args:  cobra.NoArgs,
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			client, err := c.apiClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &service, c.config)
+			if err != nil {
+				return err
+			}
+			return client.ChangeServiceStatusContext(cmd.Context(), cfg.Project(), cfg.Service(), true)
+		}),
+	}
+	serviceEnableCmd.Flags().StringVarP(&service, "service", "s", "", "The service to target")
+	serviceEnableCmd.RegisterFlagCompletionFunc("service", c.completeServices())
+	return serviceEnableCmd
 }
 
-var serviceDisableService string
+func newServiceConfigCmd(c *cli) *cobra.Command {
+	var service string
+	serviceConfigCmd := &cobra.Command{
+		Use:   "config [--service service]",
+		Short: "Print the resolved configuration of the service.",
+		Long: `Print the resolved configuration of the service as YAML.
 
-var serviceDisableCmd = &cobra.Command{
-	Use:   "disable [--service service]",
-	Short: "Disables the service.",
-	Args:  cobra.NoArgs,
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &serviceDisableService, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		return client.ChangeServiceStatus(cfg.Project(), cfg.Service(), false)
-	}),
+The configuration is read from .valar.yml, with the service of a monorepo
+selected and the profile given by --profile or the context applied.`,
+		Args: cobra.NoArgs,
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &service, c.config)
+			if err != nil {
+				return err
+			}
+			enc := yaml.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent(2)
+			return enc.Encode(cfg.Resolved())
+		}),
+	}
+	serviceConfigCmd.Flags().StringVarP(&service, "service", "s", "", "The service to target")
+	serviceConfigCmd.RegisterFlagCompletionFunc("service", c.completeServices())
+	return serviceConfigCmd
 }
 
-var serviceEnableService string
+func newServiceValidateCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate [file]",
+		Short: "Check the service configuration for mistakes.",
+		Long: `Check the service configuration for mistakes.
 
-var serviceEnableCmd = &cobra.Command{
-	Use:   "enable [--service service]",
-	Short: "Enables the service.",
-	Args:  cobra.NoArgs,
-	Run: runAndHandle(func(cmd *cobra.Command, args []string) error {
-		client, err := globalConfiguration.APIClient()
-		if err != nil {
-			return err
-		}
-		cfg, err := config.NewServiceConfigWithFallback(functionConfiguration, &serviceEnableService, globalConfiguration)
-		if err != nil {
-			return err
-		}
-		return client.ChangeServiceStatus(cfg.Project(), cfg.Service(), true)
-	}),
+Validates .valar.yml, or the given file, strictly: unknown fields, values of
+the wrong type, invalid project and service names, duplicate variables,
+builds without a constructor and malformed ignore patterns are reported with
+their location as file:line:column. Exits with status 1 if any are found.`,
+		Args: cobra.MaximumNArgs(1),
+		// Validation works without a CLI configuration.
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return checkOutputFormat(c.output)
+		},
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			path := ""
+			if len(args) > 0 {
+				path = args[0]
+			} else {
+				found, err := config.FindServiceConfig(functionConfiguration)
+				if err != nil {
+					return fmt.Errorf("find %s: %w", functionConfiguration, err)
+				}
+				path = found
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("read service config: %w", err)
+			}
+			problems := config.ValidateServiceConfig(path, data)
+			err = c.printResult(cmd.OutOrStdout(), problems, func(w io.Writer, wide bool) {
+				if len(problems) == 0 {
+					fmt.Fprintf(w, "%s is valid\n", path)
+				}
+				for _, problem := range problems {
+					fmt.Fprintln(w, problem)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			if len(problems) > 0 {
+				return fmt.Errorf("%s has %d problems", path, len(problems))
+			}
+			return nil
+		}),
+	}
 }
 
-func initServicesCmd() {
-	initPf := serviceInitCmd.PersistentFlags()
-	initPf.StringArrayVarP(&initIgnore, "ignore", "i", []string{".valar.yml", ".git", "node_modules"}, "Ignore files on push")
-	initPf.StringVarP(&initConstructor, "type", "t", "", "Build constructor type")
-	initPf.StringVarP(&initProject, "project", "p", "", "Project to deploy service to, defaults to project set in global config")
-	initPf.BoolVarP(&initForce, "force", "f", false, "Allow configuration override")
-	cobra.MarkFlagRequired(initPf, "type")
-	serviceLogsCmd.Flags().BoolVarP(&serviceLogsFollow, "follow", "f", false, "Follow logs")
-	serviceLogsCmd.Flags().BoolVarP(&serviceLogsTail, "tail", "t", false, "Jump to end of logs")
-	serviceLogsCmd.Flags().IntVarP(&serviceLogsLines, "skip", "n", 0, "Lines to skip/rewind when reading logs")
-	serviceLogsCmd.Flags().StringVarP(&serviceLogsService, "service", "s", "", "The service to target")
-	serviceCmd.AddCommand(serviceListCmd, serviceLogsCmd, serviceInitCmd, serviceEnableCmd, serviceDisableCmd)
-	rootCmd.AddCommand(serviceCmd)
+func newServiceSchemaCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of the service configuration.",
+		Long: `Print the JSON Schema of the service configuration.
+
+Editors use the schema to complete and check .valar.yml, e.g. the YAML
+language server when the file starts with
+# yaml-language-server: $schema=<path to the schema>`,
+		Args: cobra.NoArgs,
+		// The schema does not depend on any configuration.
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+		Run: c.runAndHandle(func(cmd *cobra.Command, args []string) error {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			return enc.Encode(config.ServiceConfigSchema())
+		}),
+	}
 }
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	setupWorkspace(t, "secret")
+	cfg := testServiceConfig + `profiles:
+  production:
+    project: acme-prod
+    build:
+      constructor: go1.23
+    deployment:
+      environment:
+      - LOG_LEVEL=warn
+      - REGION=eu
+  staging:
+    deployment:
+      skip: true
+`
+	if err := os.WriteFile(functionConfiguration, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code := runCommand(t, "service", "config", "--profile", "production")
+	want := `project: acme-prod
+service: web
+build:
+  constructor: go1.23
+  ignore: []
+  environment:
+    - GOFLAGS=-mod=mod
+deployment:
+  skip: false
+  environment:
+    - LOG_LEVEL=warn
+    - key: API_KEY
+      value: encrypted:c2VjcmV0
+      secret: true
+    - REGION=eu
+`
+	if code != 0 || stdout != want {
+		t.Errorf("service config --profile production = %q (%s), want %q", stdout, stderr, want)
+	}
+	if _, stderr, code := runCommand(t, "service", "config", "--profile", "preview"); code == 0 || !strings.Contains(stderr, "profile preview is not defined") {
+		t.Errorf("service config with an unknown profile exited with %d: %s", code, stderr)
+	}
+
+	// The context selects a profile unless one is given explicitly.
+	if _, stderr, code := runCommand(t, "config", "context", "set", "default", "--set-profile", "staging"); code != 0 {
+		t.Fatalf("config context set --set-profile exited with %d: %s", code, stderr)
+	}
+	if stdout, _, _ := runCommand(t, "service", "config"); !strings.Contains(stdout, "skip: true") {
+		t.Errorf("service config with the staging profile of the context = %q", stdout)
+	}
+	if stdout, _, _ := runCommand(t, "service", "config", "--profile", "production", "--project", "acme-test"); !strings.HasPrefix(stdout, "project: acme-test\n") || strings.Contains(stdout, "skip: true") {
+		t.Errorf("service config --profile production --project acme-test = %q", stdout)
+	}
+}
+
+func TestServiceValidate(t *testing.T) {
+	// The published schema has to be regenerated along with the types.
+	published, err := os.ReadFile(filepath.Join("..", "valar.schema.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupWorkspace(t, "secret")
+	if stdout, _, code := runCommand(t, "service", "schema"); code != 0 || stdout != string(published) {
+		t.Errorf("service schema differs from valar.schema.json, run go generate")
+	}
+
+	if stdout, stderr, code := runCommand(t, "service", "validate"); code != 0 || !strings.HasSuffix(stdout, functionConfiguration+" is valid\n") {
+		t.Errorf("service validate = %q (%s), exit code %d", stdout, stderr, code)
+	}
+	// The problems themselves are covered by the config package.
+	if err := os.WriteFile("broken.yml", []byte("project: acme\nservice: web-app\nbuild:\n  constructor: go1.22\ndeployment:\n  skip: maybe\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code := runCommand(t, "service", "validate", "broken.yml")
+	want := `broken.yml:2:10: invalid service name: may only contain alphanumerics
+broken.yml:6:9: expected true or false, got "maybe"
+`
+	if code != 1 || stdout != want || !strings.Contains(stderr, "broken.yml has 2 problems") {
+		t.Errorf("service validate broken.yml = %q (%s), exit code %d, want %q", stdout, stderr, code, want)
+	}
+}
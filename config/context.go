@@ -1,16 +1,24 @@
 package config
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/valar/cli/api"
-	"gopkg.in/yaml.v3"
 )
 
-func NewCLIConfigFromEnvironment() (*CLIConfig, error) {
+// NewCLIConfigFromEnvironment merges the configuration files listed in
+// VALARCONFIG, or reads ~/.valar/config. Warnings about missing files and the
+// diagnostic output of credential helpers go to stderr, or to os.Stderr if it
+// is nil.
+func NewCLIConfigFromEnvironment(stderr io.Writer) (*CLIConfig, error) {
 	cfgpath, ok := os.LookupEnv("VALARCONFIG")
 	if !ok {
 		homedir, err := os.UserHomeDir()
@@ -30,92 +38,480 @@ func NewCLIConfigFromEnvironment() (*CLIConfig, error) {
 	cfg := &CLIConfig{
 		Endpoints: map[string]APIEndpoint{},
 		Contexts:  map[string]CLIContext{},
+		Stderr:    stderr,
+		origins:   map[string]Origin{},
 	}
 	for _, path := range cfgpaths {
-		data, err := os.ReadFile(path)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: Could not read config file %s: %s\n", path, err)
+		file, err := readConfigFile(path)
+		if os.IsNotExist(err) {
+			fmt.Fprintf(cfg.stderr(), "Warning: Could not read config file %s: %s\n", path, err)
 			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("load config: %w", err)
 		}
-		// Decode config
-		var subcfg CLIConfig
-		if err := yaml.Unmarshal(data, &subcfg); err != nil {
-			return nil, fmt.Errorf("unmarshal config: %w", err)
-		}
+		cfg.files = append(cfg.files, file.path)
 		// Override cfg
-		if subcfg.ActiveContext != "" {
-			cfg.ActiveContext = subcfg.ActiveContext
+		if file.config.ActiveContext != "" {
+			cfg.ActiveContext = file.config.ActiveContext
+			cfg.origins[activeContextKey] = Origin{File: path, Line: file.lines[activeContextKey]}
 		}
-		for name, ctx := range subcfg.Contexts {
+		for name, ctx := range file.config.Contexts {
 			cfg.Contexts[name] = ctx
+			cfg.origins[contextKey(name)] = Origin{File: path, Line: file.lines[contextKey(name)]}
 		}
-		for name, ep := range subcfg.Endpoints {
+		for name, ep := range file.config.Endpoints {
 			cfg.Endpoints[name] = ep
+			cfg.origins[endpointKey(name)] = Origin{File: path, Line: file.lines[endpointKey(name)]}
 		}
 	}
 	// Extract project, token, endpoint
 	cfg.Path = cfgpaths[len(cfgpaths)-1]
+	cfg.Overrides = OverridesFromEnvironment()
+	cfg.loaded = cfg.snapshot()
 	return cfg, nil
 }
 
+// Overrides replace values of the configuration for a single invocation,
+// e.g. to target another context without switching the active one. Empty
+// fields keep the configured values.
+type Overrides struct {
+	// Context selects another context than the active one.
+	Context string
+	// Project replaces the project of the context and of .valar.yml files.
+	Project string
+	// Endpoint is either the name of a configured endpoint or a URL.
+	Endpoint string
+	// Token replaces the token of the endpoint.
+	Token string
+	// Profile selects a profile of .valar.yml files instead of the one of
+	// the context.
+	Profile string
+}
+
+// OverridesFromEnvironment reads overrides from the VALAR_CONTEXT,
+// VALAR_PROJECT, VALAR_ENDPOINT_URL, VALAR_TOKEN and VALAR_PROFILE
+// environment variables.
+func OverridesFromEnvironment() Overrides {
+	return Overrides{
+		Context:  os.Getenv("VALAR_CONTEXT"),
+		Project:  os.Getenv("VALAR_PROJECT"),
+		Endpoint: os.Getenv("VALAR_ENDPOINT_URL"),
+		Token:    os.Getenv("VALAR_TOKEN"),
+		Profile:  os.Getenv("VALAR_PROFILE"),
+	}
+}
+
+// Merge applies the non-empty fields of other on top of o.
+func (o *Overrides) Merge(other Overrides) {
+	if other.Context != "" {
+		o.Context = other.Context
+	}
+	if other.Project != "" {
+		o.Project = other.Project
+	}
+	if other.Endpoint != "" {
+		o.Endpoint = other.Endpoint
+	}
+	if other.Token != "" {
+		o.Token = other.Token
+	}
+	if other.Profile != "" {
+		o.Profile = other.Profile
+	}
+}
+
 type CLIConfig struct {
-	Version       string                 `yaml:"version"`
+	Version       string                 `yaml:"version,omitempty"`
 	ActiveContext string                 `yaml:"activeContext"`
 	Endpoints     map[string]APIEndpoint `yaml:"endpoints"`
 	Contexts      map[string]CLIContext  `yaml:"contexts"`
 
 	Path string `yaml:"-"`
+	// Overrides take precedence over the values of the configuration files.
+	Overrides Overrides `yaml:"-"`
+	// Retries overrides the number of retries configured for the endpoint.
+	Retries *int `yaml:"-"`
+	// Debug receives diagnostic output of the API client, if set.
+	Debug io.Writer `yaml:"-"`
+	// Stderr receives warnings, fatal messages and the diagnostic output of
+	// credential helpers instead of os.Stderr, if set.
+	Stderr io.Writer `yaml:"-"`
+	// Now tells the current time, e.g. to decide whether a token expires
+	// soon. It defaults to time.Now.
+	Now func() time.Time `yaml:"-"`
+	// ReadOnly keeps API clients from refreshing expiring tokens, which
+	// writes the configuration, e.g. while completing.
+	ReadOnly bool `yaml:"-"`
+	// Fatal reports a missing mandatory value and ends the program. By default,
+	// it prints the message to Stderr and exits with status 1.
+	Fatal func(message string) `yaml:"-"`
+
+	// helperTokens caches the tokens returned by credential helpers by URL.
+	helperTokens map[string]string
+	// files lists the files the configuration has been merged from, origins
+	// tells where the active context and each context and endpoint came
+	// from, and loaded is the state as loaded.
+	files   []string
+	origins map[string]Origin
+	loaded  snapshot
+}
+
+// Files returns the files the configuration is merged from, the last of which
+// receives new contexts and endpoints.
+func (cfg *CLIConfig) Files() []string {
+	files := append([]string{}, cfg.files...)
+	if !slices.Contains(files, cfg.Path) {
+		files = append(files, cfg.Path)
+	}
+	return files
+}
+
+// ActiveContextOrigin tells which file sets the active context.
+func (cfg *CLIConfig) ActiveContextOrigin() (Origin, bool) {
+	origin, ok := cfg.origins[activeContextKey]
+	return origin, ok
+}
+
+// ContextOrigin tells which file defines the named context. If several files
+// define it, this is the last one, whose definition is in effect.
+func (cfg *CLIConfig) ContextOrigin(name string) (Origin, bool) {
+	origin, ok := cfg.origins[contextKey(name)]
+	return origin, ok
+}
+
+// EndpointOrigin tells which file defines the named endpoint.
+func (cfg *CLIConfig) EndpointOrigin(name string) (Origin, bool) {
+	origin, ok := cfg.origins[endpointKey(name)]
+	return origin, ok
+}
+
+// SetContextFile makes Write store the named context in the given file, which
+// has to be one of Files, and remove it from the file it came from.
+func (cfg *CLIConfig) SetContextFile(name, path string) error {
+	return cfg.setOrigin(contextKey(name), path)
+}
+
+// SetEndpointFile is like SetContextFile for endpoints.
+func (cfg *CLIConfig) SetEndpointFile(name, path string) error {
+	return cfg.setOrigin(endpointKey(name), path)
+}
+
+func (cfg *CLIConfig) setOrigin(key, path string) error {
+	files := cfg.Files()
+	if !slices.Contains(files, path) {
+		return fmt.Errorf("%s is not one of the configuration files %s", path, strings.Join(files, ", "))
+	}
+	if cfg.origins[key].File == path {
+		return nil
+	}
+	if cfg.origins == nil {
+		cfg.origins = map[string]Origin{}
+	}
+	cfg.origins[key] = Origin{File: path}
+	return nil
+}
+
+func (cfg *CLIConfig) fatal(message string) {
+	if cfg.Fatal != nil {
+		cfg.Fatal(message)
+		return
+	}
+	fmt.Fprintln(cfg.stderr(), message)
+	os.Exit(1)
+}
+
+func (cfg *CLIConfig) stderr() io.Writer {
+	if cfg.Stderr != nil {
+		return cfg.Stderr
+	}
+	return os.Stderr
+}
+
+func (cfg *CLIConfig) now() time.Time {
+	if cfg.Now != nil {
+		return cfg.Now()
+	}
+	return time.Now()
+}
+
+func defaultFatal(message string) {
+	fmt.Fprintln(os.Stderr, message)
+	os.Exit(1)
+}
+
+// ContextName returns the name of the context in use.
+func (cfg *CLIConfig) ContextName() string {
+	if cfg.Overrides.Context != "" {
+		return cfg.Overrides.Context
+	}
+	return cfg.ActiveContext
+}
+
+// CheckOverrides makes sure the overrides refer to existing configuration.
+func (cfg *CLIConfig) CheckOverrides() error {
+	if name := cfg.Overrides.Context; name != "" {
+		if _, ok := cfg.Contexts[name]; !ok {
+			return fmt.Errorf("context %s does not exist", name)
+		}
+	}
+	// Mistyped names must not be taken for the host of a URL.
+	if name := cfg.Overrides.Endpoint; name != "" {
+		if _, ok := cfg.Endpoints[name]; !ok {
+			u, err := url.Parse(name)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("endpoint %s does not exist and is no http:// or https:// URL", name)
+			}
+		}
+	}
+	return nil
+}
+
+// Profile returns the profile of .valar.yml files in use, and whether it has
+// been selected explicitly rather than by the context.
+func (cfg *CLIConfig) Profile() (string, bool) {
+	if cfg.Overrides.Profile != "" {
+		return cfg.Overrides.Profile, true
+	}
+	return cfg.Contexts[cfg.ContextName()].Profile, false
+}
+
+// EndpointName returns the name of the endpoint in use, or an empty string if
+// it has been replaced by a URL.
+func (cfg *CLIConfig) EndpointName() string {
+	if _, ok := cfg.Endpoints[cfg.Overrides.Endpoint]; ok {
+		return cfg.Overrides.Endpoint
+	}
+	name := cfg.Contexts[cfg.ContextName()].Endpoint
+	if url := cfg.Overrides.Endpoint; url != "" && url != cfg.Endpoints[name].URL {
+		return ""
+	}
+	return name
+}
+
+// endpoint returns the endpoint in use with the overrides applied.
+func (cfg *CLIConfig) endpoint() APIEndpoint {
+	ep, ok := cfg.Endpoints[cfg.Overrides.Endpoint]
+	if !ok {
+		ep = cfg.Endpoints[cfg.Contexts[cfg.ContextName()].Endpoint]
+		if url := cfg.Overrides.Endpoint; url != "" && url != ep.URL {
+			// Never send the token of the configured endpoint elsewhere.
+			ep = APIEndpoint{URL: url, Retry: ep.Retry}
+		}
+	}
+	if cfg.Overrides.Token != "" {
+		ep.Token = cfg.Overrides.Token
+	}
+	return ep
 }
 
 func (cfg *CLIConfig) Token() string {
-	token := cfg.Endpoints[cfg.Contexts[cfg.ActiveContext].Endpoint].Token
+	ep := cfg.endpoint()
+	token := ep.Token
+	if token == "" && ep.CredentialHelper != "" {
+		var err error
+		if token, err = cfg.helperToken(ep); err != nil {
+			cfg.fatal(fmt.Sprintf("Operation requires valid endpoint token: %v.", err))
+			return ""
+		}
+	}
 	if len(token) == 0 {
-		fmt.Fprintln(os.Stderr, "Operation requires valid endpoint token.")
-		os.Exit(1)
+		cfg.fatal("Operation requires valid endpoint token.")
 	}
 	return token
 }
 
+func (cfg *CLIConfig) helperToken(ep APIEndpoint) (string, error) {
+	if token, ok := cfg.helperTokens[ep.URL]; ok {
+		return token, nil
+	}
+	token, err := ep.CredentialHelper.Get(context.Background(), cfg.Stderr, ep.URL, CredentialToken)
+	if err != nil {
+		return "", err
+	}
+	if cfg.helperTokens == nil {
+		cfg.helperTokens = map[string]string{}
+	}
+	cfg.helperTokens[ep.URL] = token
+	return token, nil
+}
+
+// SetToken changes the token of the named endpoint. If the endpoint has a
+// credential helper, the token is handed to it, otherwise it is kept in the
+// configuration, which has to be written afterwards.
+func (cfg *CLIConfig) SetToken(ctx context.Context, name, token string) error {
+	ep := cfg.Endpoints[name]
+	if ep.CredentialHelper != "" {
+		if err := ep.CredentialHelper.Store(ctx, cfg.Stderr, ep.URL, CredentialToken, token); err != nil {
+			return err
+		}
+		delete(cfg.helperTokens, ep.URL)
+		token = ""
+	}
+	ep.Token = token
+	cfg.Endpoints[name] = ep
+	return nil
+}
+
+// Masked returns a copy of the configuration with all tokens masked.
+func (cfg *CLIConfig) Masked() *CLIConfig {
+	masked := *cfg
+	masked.Endpoints = make(map[string]APIEndpoint, len(cfg.Endpoints))
+	for name, ep := range cfg.Endpoints {
+		ep.Token = MaskToken(ep.Token)
+		ep.RefreshToken = MaskToken(ep.RefreshToken)
+		masked.Endpoints[name] = ep
+	}
+	return &masked
+}
+
 func (cfg *CLIConfig) Endpoint() string {
-	url := cfg.Endpoints[cfg.Contexts[cfg.ActiveContext].Endpoint].URL
+	url := cfg.endpoint().URL
 	if len(url) == 0 {
-		fmt.Fprintln(os.Stderr, "Operation requires valid endpoint URL.")
-		os.Exit(1)
+		cfg.fatal("Operation requires valid endpoint URL.")
 	}
 	return url
 }
 
 func (cfg *CLIConfig) Project() string {
-	project := cfg.Contexts[cfg.ActiveContext].Project
+	project := cfg.Overrides.Project
+	if project == "" {
+		project = cfg.Contexts[cfg.ContextName()].Project
+	}
 	if len(project) == 0 {
-		fmt.Fprintln(os.Stderr, "Operation requires valid context project.")
-		os.Exit(1)
+		cfg.fatal("Operation requires valid context project.")
 	}
 	return project
 }
 
 func (cfg *CLIConfig) APIClient() (*api.Client, error) {
-	return api.NewClient(cfg.Endpoint(), cfg.Token())
+	return cfg.APIClientContext(context.Background())
+}
+
+// APIClientContext is like APIClient but carries a context for the initial
+// endpoint check.
+func (cfg *CLIConfig) APIClientContext(ctx context.Context) (*api.Client, error) {
+	opts := []api.ClientOption{api.WithRetryPolicy(cfg.RetryPolicy())}
+	if cfg.Debug != nil {
+		opts = append(opts, api.WithDebugLog(cfg.Debug))
+	}
+	if err := cfg.refreshExpiringToken(ctx); err != nil {
+		return nil, err
+	}
+	return api.NewClientContext(ctx, cfg.Endpoint(), cfg.Token(), opts...)
+}
+
+// RetryPolicy returns the retry policy of the active endpoint.
+func (cfg *CLIConfig) RetryPolicy() api.RetryPolicy {
+	policy := api.DefaultRetryPolicy
+	if retry := cfg.endpoint().Retry; retry != nil {
+		if retry.MaxAttempts > 0 {
+			policy.MaxAttempts = retry.MaxAttempts
+		}
+		if retry.InitialBackoff > 0 {
+			policy.InitialBackoff = retry.InitialBackoff
+		}
+		if retry.MaxBackoff > 0 {
+			policy.MaxBackoff = retry.MaxBackoff
+		}
+	}
+	if cfg.Retries != nil {
+		policy.MaxAttempts = *cfg.Retries + 1
+	}
+	return policy
 }
 
+// Write stores the changes made since loading the configuration. Changed
+// contexts and endpoints are written back to the file they came from, or the
+// one chosen with SetContextFile or SetEndpointFile, new ones to the file at
+// Path. Changes made by others in the meantime are preserved.
 func (cfg *CLIConfig) Write() error {
-	f, err := os.Create(cfg.Path)
-	if err != nil {
-		return fmt.Errorf("create config: %w", err)
+	return cfg.write("")
+}
+
+// write is like Write while the caller holds the lock of the file at held.
+func (cfg *CLIConfig) write(held string) error {
+	if cfg.origins == nil {
+		cfg.origins = map[string]Origin{}
+	}
+	current := cfg.snapshot()
+	target := func(key string) string {
+		if origin, ok := cfg.origins[key]; ok {
+			return origin.File
+		}
+		return cfg.Path
 	}
-	defer f.Close()
-	encoder := yaml.NewEncoder(f)
-	defer encoder.Close()
-	encoder.SetIndent(2)
-	return encoder.Encode(cfg)
+	previous := func(key string) string {
+		return cfg.loaded.origins[key].File
+	}
+	for _, path := range cfg.Files() {
+		err := updateConfigFile(path, path == held, func(file *CLIConfig) {
+			if current.activeContext != cfg.loaded.activeContext && target(activeContextKey) == path {
+				file.ActiveContext = current.activeContext
+			}
+			applyChanges(path, file.Contexts, cfg.loaded.contexts, current.contexts,
+				func(name string) string { return target(contextKey(name)) },
+				func(name string) string { return previous(contextKey(name)) })
+			applyChanges(path, file.Endpoints, cfg.loaded.endpoints, current.endpoints,
+				func(name string) string { return target(endpointKey(name)) },
+				func(name string) string { return previous(endpointKey(name)) })
+		})
+		if err != nil {
+			return err
+		}
+	}
+	origins := map[string]Origin{}
+	for _, key := range current.keys() {
+		origin, ok := cfg.origins[key]
+		if !ok {
+			origin = Origin{File: cfg.Path}
+		}
+		origins[key] = origin
+	}
+	cfg.origins = origins
+	if !slices.Contains(cfg.files, cfg.Path) {
+		cfg.files = append(cfg.files, cfg.Path)
+	}
+	cfg.loaded = cfg.snapshot()
+	return nil
+}
+
+// keys returns the origin keys of the values of the snapshot.
+func (s snapshot) keys() []string {
+	var keys []string
+	if s.activeContext != "" {
+		keys = append(keys, activeContextKey)
+	}
+	for name := range s.contexts {
+		keys = append(keys, contextKey(name))
+	}
+	for name := range s.endpoints {
+		keys = append(keys, endpointKey(name))
+	}
+	return keys
 }
 
 type APIEndpoint struct {
-	Token string `yaml:"token"`
-	URL   string `yaml:"url"`
+	Token string       `yaml:"token,omitempty"`
+	URL   string       `yaml:"url"`
+	Retry *RetryConfig `yaml:"retry,omitempty"`
+	// CredentialHelper keeps the token if it is not stored in the configuration.
+	CredentialHelper CredentialHelper `yaml:"credentialHelper,omitempty"`
+	// RefreshToken and TokenExpiry are set for tokens obtained by logging in.
+	RefreshToken string    `yaml:"refreshToken,omitempty"`
+	TokenExpiry  time.Time `yaml:"tokenExpiry,omitempty"`
+}
+
+// RetryConfig tunes how calls to an endpoint are retried. Unset values
+// fall back to the defaults of the API client.
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"maxAttempts,omitempty"`
+	InitialBackoff time.Duration `yaml:"initialBackoff,omitempty"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff,omitempty"`
 }
 
 type CLIContext struct {
 	Endpoint string `yaml:"endpoint"`
 	Project  string `yaml:"project"`
+	// Profile selects the profile of .valar.yml files used in the context.
+	Profile string `yaml:"profile,omitempty"`
 }
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckOverrides(t *testing.T) {
+	cfg := &CLIConfig{
+		Endpoints: map[string]APIEndpoint{"prod": {URL: "https://api.valar.dev/v2"}},
+		Contexts:  map[string]CLIContext{"default": {Endpoint: "prod", Project: "acme"}},
+	}
+	for _, tt := range []struct {
+		overrides Overrides
+		err       string
+	}{
+		{Overrides{}, ""},
+		{Overrides{Context: "default"}, ""},
+		{Overrides{Context: "staging"}, "context staging does not exist"},
+		{Overrides{Endpoint: "prod"}, ""},
+		{Overrides{Endpoint: "https://staging.valar.dev/v2"}, ""},
+		{Overrides{Endpoint: "http://localhost:8080"}, ""},
+		{Overrides{Endpoint: "prdo"}, "endpoint prdo does not exist"},
+		{Overrides{Endpoint: "api.valar.dev"}, "endpoint api.valar.dev does not exist"},
+		{Overrides{Endpoint: "ftp://api.valar.dev"}, "is no http:// or https:// URL"},
+		{Overrides{Endpoint: "https://"}, "is no http:// or https:// URL"},
+	} {
+		cfg.Overrides = tt.overrides
+		err := cfg.CheckOverrides()
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("CheckOverrides(%+v) = %v, want %q", tt.overrides, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// CredentialHelper keeps endpoint tokens outside of the configuration file,
+// e.g. in the keyring of the operating system or in a password manager. It
+// speaks the protocol of git credential helpers: the helper is run with the
+// action get, store or erase and exchanges key=value lines on stdin and
+// stdout, the token being the password.
+//
+// A helper is either a name, which is looked up as valar-credential-<name>
+// and then as git-credential-<name> on the PATH, an absolute path, or a shell
+// command prefixed with an exclamation mark. Names and paths may be followed
+// by arguments.
+type CredentialHelper string
+
+func (h CredentialHelper) command(ctx context.Context, action string) (*exec.Cmd, error) {
+	helper := strings.TrimSpace(string(h))
+	if strings.HasPrefix(helper, "!") {
+		return exec.CommandContext(ctx, "sh", "-c", helper[1:]+" \"$@\"", helper, action), nil
+	}
+	fields := strings.Fields(helper)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty credential helper")
+	}
+	path := fields[0]
+	if !filepath.IsAbs(path) {
+		var err error
+		if path, err = exec.LookPath("valar-credential-" + fields[0]); err != nil {
+			if path, err = exec.LookPath("git-credential-" + fields[0]); err != nil {
+				return nil, fmt.Errorf("credential helper %s not found", fields[0])
+			}
+		}
+	}
+	args := append(fields[1:], action)
+	return exec.CommandContext(ctx, path, args...), nil
+}
+
+// run performs an action for the endpoint, returning the attributes printed
+// by the helper. Its diagnostic output is written to stderr.
+func (h CredentialHelper) run(ctx context.Context, stderr io.Writer, action, endpointURL string, attrs ...string) (map[string]string, error) {
+	u, err := url.Parse(endpointURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint url: %w", err)
+	}
+	cmd, err := h.command(ctx, action)
+	if err != nil {
+		return nil, err
+	}
+	var in, out bytes.Buffer
+	fmt.Fprintf(&in, "protocol=%s\nhost=%s\n", u.Scheme, u.Host)
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		fmt.Fprintf(&in, "path=%s\n", path)
+	}
+	for _, attr := range attrs {
+		fmt.Fprintln(&in, attr)
+	}
+	fmt.Fprintln(&in)
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = &in, &out, stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("credential helper %s %s: %w", h, action, err)
+	}
+	result := map[string]string{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), "="); ok {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+// Kinds of secrets kept by a credential helper, passed on as the username.
+const (
+	CredentialToken        = "token"
+	CredentialRefreshToken = "refresh-token"
+)
+
+// Get returns the secret of the given kind stored for the endpoint. The
+// diagnostic output of the helper is written to stderr, or to os.Stderr if it
+// is nil.
+func (h CredentialHelper) Get(ctx context.Context, stderr io.Writer, endpointURL, kind string) (string, error) {
+	attrs, err := h.run(ctx, stderr, "get", endpointURL, "username="+kind)
+	if err != nil {
+		return "", err
+	}
+	secret := attrs["password"]
+	if secret == "" {
+		return "", fmt.Errorf("credential helper %s has no %s for %s", h, kind, endpointURL)
+	}
+	return secret, nil
+}
+
+// Store saves a secret of the given kind for the endpoint.
+func (h CredentialHelper) Store(ctx context.Context, stderr io.Writer, endpointURL, kind, secret string) error {
+	_, err := h.run(ctx, stderr, "store", endpointURL, "username="+kind, "password="+secret)
+	return err
+}
+
+// Erase deletes the secret of the given kind stored for the endpoint.
+func (h CredentialHelper) Erase(ctx context.Context, stderr io.Writer, endpointURL, kind string) error {
+	_, err := h.run(ctx, stderr, "erase", endpointURL, "username="+kind)
+	return err
+}
+
+// MaskToken hides all but the last characters of a token.
+func MaskToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	if len(token) < 16 {
+		return "********"
+	}
+	return "********" + token[len(token)-4:]
+}
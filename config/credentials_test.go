@@ -0,0 +1,18 @@
+package config
+
+import "testing"
+
+func TestMaskToken(t *testing.T) {
+	for _, tt := range []struct {
+		token, want string
+	}{
+		{"", ""},
+		{"short", "********"},
+		{"0123456789abcde", "********"},
+		{"0123456789abcdef", "********cdef"},
+	} {
+		if got := MaskToken(tt.token); got != tt.want {
+			t.Errorf("MaskToken(%q) = %q, want %q", tt.token, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Prefixes of the sources a value can be read from.
+const (
+	valueFromFile    = "file:"
+	valueFromCommand = "command:"
+)
+
+// Resolve returns the value of the variable to push or deploy. Values read
+// from a source are taken as they are, others have references to variables
+// of the local environment interpolated. Sources are resolved relative to
+// the directory of the file defining the variable. External tells whether
+// the value has been obtained from outside of the file, i.e. read from a
+// source or interpolated from at least one reference.
+func (e *EnvironmentConfig) Resolve(ctx context.Context) (value string, external bool, err error) {
+	switch {
+	case e.ValueFrom == "":
+		return interpolate(e.Value)
+	case strings.HasPrefix(e.ValueFrom, valueFromFile):
+		path := filepath.FromSlash(strings.TrimPrefix(e.ValueFrom, valueFromFile))
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(e.dir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", true, fmt.Errorf("read value: %w", err)
+		}
+		return trimNewline(string(data)), true, nil
+	case strings.HasPrefix(e.ValueFrom, valueFromCommand):
+		command := exec.CommandContext(ctx, "sh", "-c", strings.TrimPrefix(e.ValueFrom, valueFromCommand))
+		command.Dir = e.dir
+		var stderr bytes.Buffer
+		command.Stderr = &stderr
+		out, err := command.Output()
+		if err != nil {
+			return "", true, fmt.Errorf("run value command: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return trimNewline(string(out)), true, nil
+	}
+	return "", true, fmt.Errorf("unknown value source %q, expected %s<path> or %s<command>", e.ValueFrom, valueFromFile, valueFromCommand)
+}
+
+func trimNewline(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return strings.TrimSuffix(s, "\r")
+}
+
+// interpolate replaces ${NAME} by the value of the environment variable.
+// ${NAME:-default} falls back to the default if the variable is unset or
+// empty, ${NAME-default} only if it is unset. $${ is kept as a literal ${.
+// Replaced tells whether there was any reference to replace.
+func interpolate(value string) (result string, replaced bool, err error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(value, "${")
+		if i < 0 {
+			b.WriteString(value)
+			return b.String(), replaced, nil
+		}
+		if i > 0 && value[i-1] == '$' {
+			b.WriteString(value[:i-1] + "${")
+			value = value[i+2:]
+			continue
+		}
+		end := strings.IndexByte(value[i:], '}')
+		if end < 0 {
+			return "", false, fmt.Errorf("unterminated variable reference in %q", value)
+		}
+		b.WriteString(value[:i])
+		replaced = true
+		reference := value[i+2 : i+end]
+		value = value[i+end+1:]
+		name, fallback, hasFallback := reference, "", false
+		emptyFallback := false
+		if n, f, ok := strings.Cut(reference, ":-"); ok {
+			name, fallback, hasFallback, emptyFallback = n, f, true, true
+		} else if n, f, ok := strings.Cut(reference, "-"); ok {
+			name, fallback, hasFallback = n, f, true
+		}
+		resolved, set := os.LookupEnv(name)
+		switch {
+		case set && !(emptyFallback && resolved == ""):
+			b.WriteString(resolved)
+		case hasFallback:
+			b.WriteString(fallback)
+		default:
+			return "", false, fmt.Errorf("environment variable %s is not set", name)
+		}
+	}
+}
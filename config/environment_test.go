@@ -0,0 +1,101 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("VALAR_TEST_SET", "value")
+	t.Setenv("VALAR_TEST_EMPTY", "")
+	os.Unsetenv("VALAR_TEST_UNSET")
+	for _, tt := range []struct {
+		value, want, err string
+	}{
+		{value: "plain", want: "plain"},
+		{value: "${VALAR_TEST_SET}", want: "value"},
+		{value: "a-${VALAR_TEST_SET}-b", want: "a-value-b"},
+		{value: "${VALAR_TEST_EMPTY}", want: ""},
+		{value: "${VALAR_TEST_EMPTY:-default}", want: "default"},
+		{value: "${VALAR_TEST_EMPTY-default}", want: ""},
+		{value: "${VALAR_TEST_UNSET:-default}", want: "default"},
+		{value: "${VALAR_TEST_UNSET-default}", want: "default"},
+		{value: "$${VALAR_TEST_SET}", want: "${VALAR_TEST_SET}"},
+		{value: "$$VALAR_TEST_SET", want: "$$VALAR_TEST_SET"},
+		{value: "${VALAR_TEST_UNSET}", err: "environment variable VALAR_TEST_UNSET is not set"},
+		{value: "${VALAR_TEST_SET", err: "unterminated variable reference"},
+	} {
+		got, _, err := interpolate(tt.value)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("interpolate(%q) = %q, %v, want error %q", tt.value, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("interpolate(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	t.Setenv("VALAR_TEST_SET", "value")
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("encrypted:c2VjcmV0\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		yaml     string
+		want     string
+		external bool
+	}{
+		// Values without references are taken from the file, even if they
+		// contain escaped ones.
+		{yaml: `PASSWORD=pa$${ss}word$`, want: "pa${ss}word$"},
+		{yaml: `{key: PASSWORD, value: "encrypted:c2VjcmV0", secret: true}`, want: "encrypted:c2VjcmV0"},
+		{yaml: `{key: URL, value: "https://${VALAR_TEST_SET}/$${PATH}"}`, want: "https://value/${PATH}", external: true},
+		{yaml: `{key: REGION, value: "${VALAR_TEST_UNSET:-eu}", secret: true}`, want: "eu", external: true},
+		// Values read from a source are external even if they look literal.
+		{yaml: `{key: TOKEN, valueFrom: "file:secret.txt", secret: true}`, want: "encrypted:c2VjcmV0", external: true},
+		{yaml: `{key: GREETING, valueFrom: "command:echo hi"}`, want: "hi", external: true},
+	} {
+		var e EnvironmentConfig
+		if err := yaml.Unmarshal([]byte(tt.yaml), &e); err != nil {
+			t.Fatalf("%s: %v", tt.yaml, err)
+		}
+		e.dir = dir
+		got, external, err := e.Resolve(context.Background())
+		if err != nil || got != tt.want || external != tt.external {
+			t.Errorf("Resolve(%s) = %q, %v, %v, want %q, %v", tt.yaml, got, external, err, tt.want, tt.external)
+		}
+	}
+}
+
+func TestUnmarshalEnvironment(t *testing.T) {
+	for _, tt := range []struct {
+		yaml, err string
+	}{
+		{yaml: `{key: A, value: a, valueFrom: "file:a"}`, err: "cannot have both a value and a valueFrom"},
+		{yaml: `A`, err: "KEY=VALUE"},
+	} {
+		var e EnvironmentConfig
+		if err := yaml.Unmarshal([]byte(tt.yaml), &e); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("unmarshal %s = %v, want error %q", tt.yaml, err, tt.err)
+		}
+	}
+	// Sources survive writing the variable back, e.g. by env set.
+	e := EnvironmentConfig{Key: "TOKEN", ValueFrom: "file:token.txt", Secret: true}
+	data, err := yaml.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back EnvironmentConfig
+	if err := yaml.Unmarshal(data, &back); err != nil || back != e {
+		t.Errorf("round trip of %+v = %+v, %v", e, back, err)
+	}
+}
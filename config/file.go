@@ -0,0 +1,287 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaVersion is the version of the configuration file layout written by
+// this CLI. Older layouts are migrated when loaded, newer ones are never
+// overwritten.
+const SchemaVersion = 1
+
+// legacyConfig is the layout of version 0, which predates endpoints and
+// contexts and configured a single endpoint and project.
+type legacyConfig struct {
+	Endpoint string `yaml:"endpoint"`
+	Token    string `yaml:"token"`
+	Project  string `yaml:"project"`
+}
+
+// configFile is one of the files a configuration is merged from.
+type configFile struct {
+	path    string
+	version int
+	config  CLIConfig
+	// lines tells on which line the active context and each context and
+	// endpoint are defined.
+	lines map[string]int
+}
+
+// Origin is the place in a configuration file a value has been loaded from.
+type Origin struct {
+	File string
+	// Line is 0 for values written to the file since loading it.
+	Line int
+}
+
+func (o Origin) String() string {
+	if o.Line == 0 {
+		return o.File
+	}
+	return fmt.Sprintf("%s:%d", o.File, o.Line)
+}
+
+// readConfigFile reads and migrates a configuration file.
+func readConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfigFile(path, data)
+}
+
+func parseConfigFile(path string, data []byte) (*configFile, error) {
+	file := &configFile{path: path}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("unmarshal config %s: %w", path, err)
+	}
+	if doc.Kind != 0 {
+		if err := doc.Decode(&file.config); err != nil {
+			return nil, fmt.Errorf("unmarshal config %s: %w", path, err)
+		}
+	}
+	switch {
+	case file.config.Version != "":
+		version, err := strconv.Atoi(file.config.Version)
+		if err != nil {
+			return nil, fmt.Errorf("config %s: invalid version %q", path, file.config.Version)
+		}
+		file.version = version
+	case file.config.Endpoints != nil || file.config.Contexts != nil || file.config.ActiveContext != "":
+		file.version = 1
+	}
+	if file.version < 1 {
+		var legacy legacyConfig
+		if err := yaml.Unmarshal(data, &legacy); err != nil {
+			return nil, fmt.Errorf("unmarshal config %s: %w", path, err)
+		}
+		file.config.migrateLegacy(legacy)
+	}
+	file.lines = documentLines(&doc, file.version < 1)
+	if file.config.Endpoints == nil {
+		file.config.Endpoints = map[string]APIEndpoint{}
+	}
+	if file.config.Contexts == nil {
+		file.config.Contexts = map[string]CLIContext{}
+	}
+	return file, nil
+}
+
+// migrateLegacy moves the endpoint and project of a version 0 layout into
+// an endpoint and context named default.
+func (cfg *CLIConfig) migrateLegacy(legacy legacyConfig) {
+	if legacy.Endpoint == "" && legacy.Token == "" && legacy.Project == "" {
+		return
+	}
+	cfg.Endpoints = map[string]APIEndpoint{"default": {URL: legacy.Endpoint, Token: legacy.Token}}
+	cfg.Contexts = map[string]CLIContext{"default": {Endpoint: "default", Project: legacy.Project}}
+	cfg.ActiveContext = "default"
+}
+
+// documentLines finds the lines the active context and each context and
+// endpoint are defined on. Values migrated from a legacy layout are
+// attributed to its first key.
+func documentLines(doc *yaml.Node, legacy bool) map[string]int {
+	lines := map[string]int{}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return lines
+	}
+	root := doc.Content[0].Content
+	for i := 0; i+1 < len(root); i += 2 {
+		key, value := root[i], root[i+1]
+		switch {
+		case legacy:
+			if _, ok := lines[activeContextKey]; !ok {
+				lines[activeContextKey] = key.Line
+				lines[contextKey("default")] = key.Line
+				lines[endpointKey("default")] = key.Line
+			}
+		case key.Value == "activeContext":
+			lines[activeContextKey] = key.Line
+		case key.Value == "contexts" && value.Kind == yaml.MappingNode:
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				lines[contextKey(value.Content[j].Value)] = value.Content[j].Line
+			}
+		case key.Value == "endpoints" && value.Kind == yaml.MappingNode:
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				lines[endpointKey(value.Content[j].Value)] = value.Content[j].Line
+			}
+		}
+	}
+	return lines
+}
+
+// lockConfigFile takes the lock guarding the updates of the file and returns
+// a function releasing it.
+func lockConfigFile(path string) (func(), error) {
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("lock config %s: %w", path, err)
+	}
+	return unlock, nil
+}
+
+// updateConfigFile applies changes to the file while holding its lock, so
+// that concurrent invocations do not lose each other's updates. The lock is
+// only taken unless held tells that the caller holds it already. The file is
+// replaced atomically and only if the changes modify it.
+func updateConfigFile(path string, held bool, apply func(cfg *CLIConfig)) error {
+	if !held {
+		unlock, err := lockConfigFile(path)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	file, err := readConfigFile(path)
+	if os.IsNotExist(err) {
+		file, err = parseConfigFile(path, nil)
+	}
+	if err != nil {
+		return err
+	}
+	before, err := file.config.marshal()
+	if err != nil {
+		return err
+	}
+	apply(&file.config)
+	after, err := file.config.marshal()
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(before, after) {
+		return nil
+	}
+	if file.version > SchemaVersion {
+		return fmt.Errorf("config %s has version %d, which is newer than the supported version %d, please upgrade the CLI", path, file.version, SchemaVersion)
+	}
+	file.config.Version = strconv.Itoa(SchemaVersion)
+	data, err := file.config.marshal()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+func (cfg *CLIConfig) marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(cfg); err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileAtomic writes to a temporary file next to path and renames it, so
+// that readers never see a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("create config dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create config: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("create config: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write config: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write config: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replace config: %w", err)
+	}
+	return nil
+}
+
+// Keys of the origins of configuration values.
+const activeContextKey = "activeContext"
+
+func contextKey(name string) string  { return "contexts." + name }
+func endpointKey(name string) string { return "endpoints." + name }
+
+// snapshot is a copy of the values of a configuration stored in files and
+// of their origins.
+type snapshot struct {
+	activeContext string
+	contexts      map[string]CLIContext
+	endpoints     map[string]APIEndpoint
+	origins       map[string]Origin
+}
+
+func (cfg *CLIConfig) snapshot() snapshot {
+	return snapshot{
+		activeContext: cfg.ActiveContext,
+		contexts:      maps.Clone(cfg.Contexts),
+		endpoints:     maps.Clone(cfg.Endpoints),
+		origins:       maps.Clone(cfg.origins),
+	}
+}
+
+// applyChanges applies the differences between the loaded and the current
+// entries to the entries of the file at path. Changed entries are set in the
+// file target names and removed from the file they have been moved from,
+// removed entries are removed from all files.
+func applyChanges[V any](path string, entries, loaded, current map[string]V, target, previous func(name string) string) {
+	for name, value := range current {
+		to, from := target(name), previous(name)
+		if old, ok := loaded[name]; ok && reflect.DeepEqual(old, value) && to == from {
+			continue
+		}
+		if to == path {
+			entries[name] = value
+		} else if from == path {
+			delete(entries, name)
+		}
+	}
+	for name := range loaded {
+		if _, ok := current[name]; !ok {
+			delete(entries, name)
+		}
+	}
+}
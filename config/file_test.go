@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigFileMigratesLegacy(t *testing.T) {
+	file, err := parseConfigFile("config", []byte("endpoint: https://api.example.com\ntoken: secret\nproject: acme\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := file.config
+	if file.version != 0 || cfg.ActiveContext != "default" {
+		t.Errorf("legacy file has version %d and active context %q, want 0 and default", file.version, cfg.ActiveContext)
+	}
+	if ep := cfg.Endpoints["default"]; ep.URL != "https://api.example.com" || ep.Token != "secret" {
+		t.Errorf("migrated endpoint = %+v", ep)
+	}
+	if ctx := cfg.Contexts["default"]; ctx.Endpoint != "default" || ctx.Project != "acme" {
+		t.Errorf("migrated context = %+v", ctx)
+	}
+	for _, key := range []string{activeContextKey, contextKey("default"), endpointKey("default")} {
+		if file.lines[key] != 1 {
+			t.Errorf("%s is attributed to line %d, want 1", key, file.lines[key])
+		}
+	}
+
+	for _, data := range []string{"", "contexts: {}\n", "version: \"1\"\n"} {
+		file, err := parseConfigFile("config", []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := min(len(data), 1); file.version != want || file.config.Endpoints == nil || file.config.Contexts == nil {
+			t.Errorf("file %q has version %d, want %d and empty maps", data, file.version, want)
+		}
+	}
+	if _, err := parseConfigFile("config", []byte("version: next\n")); err == nil || !strings.Contains(err.Error(), `invalid version "next"`) {
+		t.Errorf("invalid version = %v", err)
+	}
+}
+
+func TestUpdateConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte("endpoint: https://api.example.com\ntoken: secret\nproject: acme\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Updates migrate legacy files and keep them private.
+	err := updateConfigFile(path, false, func(cfg *CLIConfig) {
+		cfg.Contexts["staging"] = CLIContext{Endpoint: "default", Project: "acme-staging"}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "version: \"1\"\nactiveContext: default\nendpoints:\n  default:\n    token: secret\n    url: https://api.example.com\ncontexts:\n  default:\n    endpoint: default\n    project: acme\n  staging:\n    endpoint: default\n    project: acme-staging\n"
+	if string(data) != want {
+		t.Errorf("updated file =\n%s\nwant\n%s", data, want)
+	}
+	if info, err := os.Stat(path); err == nil && runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("updated file has mode %v, want 0600", info.Mode().Perm())
+	}
+
+	// Files written by a newer CLI are never overwritten, unless nothing
+	// changes.
+	newer := "version: \"2\"\ncontexts: {}\n"
+	if err := os.WriteFile(path, []byte(newer), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateConfigFile(path, false, func(cfg *CLIConfig) {}); err != nil {
+		t.Errorf("unchanged update of a newer file = %v", err)
+	}
+	err = updateConfigFile(path, false, func(cfg *CLIConfig) {
+		cfg.Endpoints["local"] = APIEndpoint{URL: "http://localhost:7420"}
+	})
+	if err == nil || !strings.Contains(err.Error(), "newer than the supported version") {
+		t.Errorf("update of a newer file = %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != newer {
+		t.Errorf("newer file has been overwritten:\n%s", data)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package config
+
+// lockFile does not lock on platforms without file locks. Writes are still
+// atomic, but concurrent updates may get lost.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
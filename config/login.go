@@ -0,0 +1,130 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/valar/cli/api"
+)
+
+// tokenRefreshMargin is how long before its expiry a token is refreshed.
+const tokenRefreshMargin = time.Minute
+
+// SetOAuthToken stores a token obtained by logging in to the named endpoint,
+// along with its refresh token and expiry. The configuration has to be
+// written afterwards.
+func (cfg *CLIConfig) SetOAuthToken(ctx context.Context, name string, token *api.OAuthToken, issued time.Time) error {
+	if err := cfg.SetToken(ctx, name, token.AccessToken); err != nil {
+		return err
+	}
+	ep := cfg.Endpoints[name]
+	ep.RefreshToken = token.RefreshToken
+	if ep.CredentialHelper != "" && token.RefreshToken != "" {
+		if err := ep.CredentialHelper.Store(ctx, cfg.Stderr, ep.URL, CredentialRefreshToken, token.RefreshToken); err != nil {
+			return err
+		}
+		ep.RefreshToken = ""
+	}
+	ep.TokenExpiry = token.Expiry(issued)
+	cfg.Endpoints[name] = ep
+	return nil
+}
+
+// StoredSecret returns the token or refresh token of the named endpoint, or
+// an empty string if there is none. Unlike Token, overrides do not apply.
+func (cfg *CLIConfig) StoredSecret(ctx context.Context, name, kind string) string {
+	ep := cfg.Endpoints[name]
+	secret := ep.Token
+	if kind == CredentialRefreshToken {
+		secret = ep.RefreshToken
+	}
+	if secret != "" || ep.CredentialHelper == "" {
+		return secret
+	}
+	secret, _ = ep.CredentialHelper.Get(ctx, cfg.Stderr, ep.URL, kind)
+	return secret
+}
+
+// ClearToken deletes the tokens of the named endpoint, including the ones
+// kept by its credential helper. The configuration has to be written
+// afterwards.
+func (cfg *CLIConfig) ClearToken(ctx context.Context, name string) error {
+	ep := cfg.Endpoints[name]
+	if ep.CredentialHelper != "" {
+		for _, kind := range []string{CredentialToken, CredentialRefreshToken} {
+			if err := ep.CredentialHelper.Erase(ctx, cfg.Stderr, ep.URL, kind); err != nil {
+				return err
+			}
+		}
+		delete(cfg.helperTokens, ep.URL)
+	}
+	ep.Token, ep.RefreshToken, ep.TokenExpiry = "", "", time.Time{}
+	cfg.Endpoints[name] = ep
+	return nil
+}
+
+// refreshExpiringToken refreshes the token of the endpoint in use shortly
+// before it expires and writes the new one to the configuration, unless the
+// configuration is read-only. The refresh happens under the lock of the file
+// defining the endpoint, so that concurrent invocations refresh only once.
+func (cfg *CLIConfig) refreshExpiringToken(ctx context.Context) error {
+	name := cfg.EndpointName()
+	ep, ok := cfg.Endpoints[name]
+	if !ok || cfg.ReadOnly || cfg.Overrides.Token != "" || !cfg.expiresSoon(ep) {
+		return nil
+	}
+	path := cfg.Path
+	if origin, ok := cfg.EndpointOrigin(name); ok {
+		path = origin.File
+	}
+	unlock, err := lockConfigFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if cfg.adoptRefreshedToken(path, name) {
+		return nil
+	}
+	refreshToken := cfg.StoredSecret(ctx, name, CredentialRefreshToken)
+	if refreshToken == "" {
+		return nil
+	}
+	issued := cfg.now()
+	token, err := api.NewOAuth(ep.URL).RefreshContext(ctx, refreshToken)
+	if err != nil {
+		return fmt.Errorf("refresh token (log in again using `valar login`): %w", err)
+	}
+	if err := cfg.SetOAuthToken(ctx, name, token, issued); err != nil {
+		return fmt.Errorf("store refreshed token: %w", err)
+	}
+	if err := cfg.write(path); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	return nil
+}
+
+func (cfg *CLIConfig) expiresSoon(ep APIEndpoint) bool {
+	return !ep.TokenExpiry.IsZero() && ep.TokenExpiry.Sub(cfg.now()) <= tokenRefreshMargin
+}
+
+// adoptRefreshedToken takes over the token of the named endpoint if another
+// invocation has refreshed it in the file at path since loading it.
+func (cfg *CLIConfig) adoptRefreshedToken(path, name string) bool {
+	file, err := readConfigFile(path)
+	if err != nil {
+		return false
+	}
+	stored, ok := file.config.Endpoints[name]
+	ep := cfg.Endpoints[name]
+	if !ok || stored.URL != ep.URL || stored.TokenExpiry.Equal(ep.TokenExpiry) || cfg.expiresSoon(stored) {
+		return false
+	}
+	ep.Token, ep.RefreshToken, ep.TokenExpiry = stored.Token, stored.RefreshToken, stored.TokenExpiry
+	cfg.Endpoints[name] = ep
+	if cfg.loaded.endpoints != nil {
+		cfg.loaded.endpoints[name] = ep
+	}
+	delete(cfg.helperTokens, ep.URL)
+	return true
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshExpiringToken(t *testing.T) {
+	var refreshes atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth/token" || r.FormValue("refresh_token") != "refresh" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		n := refreshes.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","refresh_token":"refresh","expires_in":3600}`, n)
+	}))
+	defer srv.Close()
+
+	expiry := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "config")
+	data := fmt.Sprintf(`version: "1"
+activeContext: default
+contexts:
+  default:
+    endpoint: default
+    project: acme
+endpoints:
+  default:
+    url: %s
+    token: token-0
+    refreshToken: refresh
+    tokenExpiry: %s
+`, srv.URL, expiry.Format(time.RFC3339))
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VALARCONFIG", path)
+	load := func(now time.Time) *CLIConfig {
+		t.Helper()
+		cfg, err := NewCLIConfigFromEnvironment(io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Now = func() time.Time { return now }
+		return cfg
+	}
+
+	// A token that is far from expiring is left alone.
+	cfg := load(expiry.Add(-time.Hour))
+	if err := cfg.refreshExpiringToken(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := refreshes.Load(); n != 0 {
+		t.Fatalf("refreshed a valid token %d times", n)
+	}
+
+	// Concurrent invocations refresh an expiring token only once and share
+	// the refreshed one.
+	now := expiry.Add(-30 * time.Second)
+	configs := []*CLIConfig{load(now), load(now), load(now)}
+	var wg sync.WaitGroup
+	for _, cfg := range configs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := cfg.refreshExpiringToken(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := refreshes.Load(); n != 1 {
+		t.Fatalf("refreshed the token %d times, want 1", n)
+	}
+	for _, cfg := range configs {
+		ep := cfg.Endpoints["default"]
+		if ep.Token != "token-1" || !ep.TokenExpiry.Equal(now.Add(time.Hour)) {
+			t.Errorf("token = %s expiring %v, want token-1 expiring %v", ep.Token, ep.TokenExpiry, now.Add(time.Hour))
+		}
+	}
+	stored := load(now).Endpoints["default"]
+	if stored.Token != "token-1" || !stored.TokenExpiry.Equal(now.Add(time.Hour)) {
+		t.Errorf("stored token = %s expiring %v, want token-1 expiring %v", stored.Token, stored.TokenExpiry, now.Add(time.Hour))
+	}
+}
@@ -0,0 +1,302 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schema is a JSON Schema of a part of .valar.yml. It supports the subset of
+// draft 7 needed to describe the file, which is what ValidateServiceConfig
+// checks.
+type Schema struct {
+	Schema      string `json:"$schema,omitempty"`
+	Ref         string `json:"$ref,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type,omitempty"`
+	// Properties are the fields of an object.
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	// AdditionalProperties is either false or the schema of the values of
+	// an object used as a map.
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	Definitions          map[string]*Schema `json:"definitions,omitempty"`
+
+	// pattern is the compiled Pattern and patternMessage explains it to
+	// users of Validate.
+	pattern        *regexp.Regexp
+	patternMessage string
+}
+
+// stringSchema describes strings matching the pattern, which message
+// explains.
+func stringSchema(description, pattern, message string) *Schema {
+	return &Schema{
+		Type:           "string",
+		Description:    description,
+		Pattern:        pattern,
+		pattern:        regexp.MustCompile(pattern),
+		patternMessage: message,
+	}
+}
+
+// schemaDescriptions document the fields of the schema, by type and field.
+var schemaDescriptions = map[string]string{
+	"ServiceConfigYAML.project":           "The project the service belongs to.",
+	"ServiceConfigYAML.service":           "The name of the service.",
+	"ServiceConfigYAML.path":              "The source directory of a service of a monorepo, relative to the file listing it.",
+	"ServiceConfigYAML.build":             "How the service is built.",
+	"ServiceConfigYAML.deployment":        "How the service is deployed.",
+	"ServiceConfigYAML.services":          "The services of a monorepo, which inherit the project of the file unless they name their own.",
+	"ServiceConfigYAML.profiles":          "Overlays of the configuration selected with --profile or by the context.",
+	"BuildConfig.constructor":             "The constructor building the service, e.g. go1.22.",
+	"BuildConfig.ignore":                  "Patterns of files excluded from the pushed source, like in .gitignore.",
+	"BuildConfig.gitignore":               "Whether the files ignored by git are excluded from the pushed source as well.",
+	"BuildConfig.maxSize":                 "The size the pushed files must not exceed, e.g. 500MB, checked by build push.",
+	"BuildConfig.environment":             "The variables set during the build.",
+	"DeploymentConfig.skip":               "Whether builds are pushed without deploying them.",
+	"DeploymentConfig.environment":        "The variables set when the service runs.",
+	"ProfileConfig.project":               "The project to deploy to instead.",
+	"ProfileConfig.build":                 "Overlays the build, merging variables by key.",
+	"ProfileConfig.deployment":            "Overlays the deployment, merging variables by key.",
+	"ProfileBuildConfig.constructor":      "The constructor building the service instead.",
+	"ProfileBuildConfig.ignore":           "Patterns of files excluded from the pushed source instead.",
+	"ProfileBuildConfig.gitignore":        "Whether the files ignored by git are excluded, if set.",
+	"ProfileBuildConfig.maxSize":          "The size the pushed files must not exceed instead.",
+	"ProfileBuildConfig.environment":      "The variables set during the build, replacing the ones with the same key.",
+	"ProfileDeploymentConfig.skip":        "Whether builds are pushed without deploying them, if set.",
+	"ProfileDeploymentConfig.environment": "The variables set when the service runs, replacing the ones with the same key.",
+}
+
+// environmentSchema describes a variable in its raw or structured form.
+var environmentSchema = &Schema{
+	Description: "A variable given as KEY=VALUE or as an object.",
+	OneOf: []*Schema{
+		stringSchema("", "^[^=]+=", "expected KEY=VALUE"),
+		{
+			Type: "object",
+			Properties: map[string]*Schema{
+				"key":   {Type: "string", Description: "The name of the variable."},
+				"value": {Type: "string", Description: "The value, which may refer to local variables as ${NAME} or ${NAME:-default}, with $${ standing for a literal ${."},
+				"valueFrom": stringSchema("Reads the value on push instead, from file:<path> or from the output of command:<command>.",
+					"^("+valueFromFile+"|"+valueFromCommand+")", "expected "+valueFromFile+"<path> or "+valueFromCommand+"<command>"),
+				"secret": {Type: "boolean", Description: "Whether the value is encrypted."},
+			},
+			AdditionalProperties: false,
+			Required:             []string{"key"},
+		},
+	},
+}
+
+// ServiceConfigSchema returns the JSON Schema of .valar.yml, as derived from
+// ServiceConfigYAML.
+func ServiceConfigSchema() *Schema {
+	definitions := map[string]*Schema{}
+	root := objectSchema(reflect.TypeOf(ServiceConfigYAML{}), definitions)
+	root.Schema = "http://json-schema.org/draft-07/schema#"
+	root.Title = ".valar.yml"
+	root.Description = "The configuration of a Valar service or of the services of a monorepo."
+	root.Definitions = definitions
+	return root
+}
+
+func typeSchema(t reflect.Type, definitions map[string]*Schema) *Schema {
+	switch t {
+	case reflect.TypeOf(ServiceConfigYAML{}):
+		return &Schema{Ref: "#"}
+	case reflect.TypeOf(EnvironmentConfig{}):
+		definitions[t.Name()] = environmentSchema
+		return &Schema{Ref: "#/definitions/" + t.Name()}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem(), definitions)
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Slice:
+		return &Schema{Type: "array", Items: typeSchema(t.Elem(), definitions)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: typeSchema(t.Elem(), definitions)}
+	case reflect.Struct:
+		if _, ok := definitions[t.Name()]; !ok {
+			definitions[t.Name()] = objectSchema(t, definitions)
+		}
+		return &Schema{Ref: "#/definitions/" + t.Name()}
+	}
+	panic(fmt.Sprintf("config: no schema for %v", t))
+}
+
+func objectSchema(t reflect.Type, definitions map[string]*Schema) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		property := typeSchema(field.Type, definitions)
+		property.Description = schemaDescriptions[t.Name()+"."+name]
+		schema.Properties[name] = property
+	}
+	return schema
+}
+
+// validate reports the nodes of the document not matching the schema.
+func (s *Schema) validate(root *Schema, node *yaml.Node, report func(node *yaml.Node, format string, args ...interface{})) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if s.Ref != "" {
+		s.resolve(root).validate(root, node, report)
+		return
+	}
+	// Empty values leave the field unset.
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null" {
+		return
+	}
+	if len(s.OneOf) > 0 {
+		for _, alternative := range s.OneOf {
+			if alternative.matchesKind(node) {
+				alternative.validate(root, node, report)
+				return
+			}
+		}
+		var kinds []string
+		for _, alternative := range s.OneOf {
+			kinds = append(kinds, alternative.kindName())
+		}
+		report(node, "expected %s, got %s", strings.Join(kinds, " or "), nodeKindName(node))
+		return
+	}
+	if !s.matchesKind(node) {
+		report(node, "expected %s, got %s", s.kindName(), nodeKindName(node))
+		return
+	}
+	switch s.Type {
+	case "object":
+		seen := map[string]*yaml.Node{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if first, ok := seen[key.Value]; ok {
+				report(key, "field %s is already defined on line %d", key.Value, first.Line)
+				continue
+			}
+			seen[key.Value] = key
+			if property, ok := s.Properties[key.Value]; ok {
+				property.validate(root, value, report)
+				continue
+			}
+			if values, ok := s.AdditionalProperties.(*Schema); ok {
+				values.validate(root, value, report)
+				continue
+			}
+			if suggestion := closestName(key.Value, s.Properties); suggestion != "" {
+				report(key, "unknown field %s, did you mean %s?", key.Value, suggestion)
+				continue
+			}
+			report(key, "unknown field %s", key.Value)
+		}
+		for _, name := range s.Required {
+			if _, ok := seen[name]; !ok {
+				report(node, "missing field %s", name)
+			}
+		}
+	case "array":
+		for _, item := range node.Content {
+			s.Items.validate(root, item, report)
+		}
+	case "string":
+		if s.pattern != nil && !s.pattern.MatchString(node.Value) {
+			report(node, "%s, got %q", s.patternMessage, node.Value)
+		}
+	}
+}
+
+func (s *Schema) resolve(root *Schema) *Schema {
+	if s.Ref == "#" {
+		return root
+	}
+	return root.Definitions[strings.TrimPrefix(s.Ref, "#/definitions/")]
+}
+
+func (s *Schema) matchesKind(node *yaml.Node) bool {
+	switch s.Type {
+	case "object":
+		return node.Kind == yaml.MappingNode
+	case "array":
+		return node.Kind == yaml.SequenceNode
+	case "boolean":
+		return node.Kind == yaml.ScalarNode && node.ShortTag() == "!!bool"
+	case "string":
+		return node.Kind == yaml.ScalarNode
+	}
+	return true
+}
+
+func (s *Schema) kindName() string {
+	switch s.Type {
+	case "object":
+		return "a mapping"
+	case "array":
+		return "a list"
+	case "boolean":
+		return "true or false"
+	}
+	return "a " + s.Type
+}
+
+func nodeKindName(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return fmt.Sprintf("%q", node.Value)
+}
+
+// closestName suggests the field a misspelled one was meant to be.
+func closestName(name string, properties map[string]*Schema) string {
+	best, bestDistance := "", 3
+	for candidate := range properties {
+		distance := editDistance(strings.ToLower(name), strings.ToLower(candidate))
+		if distance < bestDistance || (distance == bestDistance && candidate < best) {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance of a and b.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = slices.Min([]int{previous[j] + 1, current[j-1] + 1, previous[j-1] + cost})
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
@@ -3,28 +3,40 @@ package config
 import (
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
+	"github.com/dustin/go-humanize"
 	"gopkg.in/yaml.v3"
 )
 
 type EnvironmentConfig struct {
 	Key, Value string
 	Secret     bool
+	// ValueFrom reads the value on push instead, either from a file given as
+	// file:<path> or from the output of a shell command given as
+	// command:<command>.
+	ValueFrom string
+
+	// dir is the directory of the file defining the variable.
+	dir string
 }
 
-func (e *EnvironmentConfig) MarshalYAML() (interface{}, error) {
-	if e.Secret {
+func (e EnvironmentConfig) MarshalYAML() (interface{}, error) {
+	if e.Secret || e.ValueFrom != "" {
 		return struct {
-			Key    string `yaml:"key,omitempty"`
-			Value  string `yaml:"value,omitempty"`
-			Secret bool   `yaml:"secret,omitempty"`
+			Key       string `yaml:"key,omitempty"`
+			Value     string `yaml:"value,omitempty"`
+			ValueFrom string `yaml:"valueFrom,omitempty"`
+			Secret    bool   `yaml:"secret,omitempty"`
 		}{
-			Key:    e.Key,
-			Value:  e.Value,
-			Secret: e.Secret,
+			Key:       e.Key,
+			Value:     e.Value,
+			ValueFrom: e.ValueFrom,
+			Secret:    e.Secret,
 		}, nil
 	}
 	return e.Key + "=" + e.Value, nil
@@ -44,30 +56,127 @@ func (e *EnvironmentConfig) UnmarshalYAML(value *yaml.Node) error {
 	}
 	// Try to parse as struct
 	var structured struct {
-		Key    string `yaml:"key,omitempty"`
-		Value  string `yaml:"value,omitempty"`
-		Secret bool   `yaml:"secret,omitempty"`
+		Key       string `yaml:"key,omitempty"`
+		Value     string `yaml:"value,omitempty"`
+		ValueFrom string `yaml:"valueFrom,omitempty"`
+		Secret    bool   `yaml:"secret,omitempty"`
 	}
 	if err := value.Decode(&structured); err != nil {
 		return fmt.Errorf("envvar has to be in raw or structured form")
 	}
+	if structured.Value != "" && structured.ValueFrom != "" {
+		return fmt.Errorf("envvar %s cannot have both a value and a valueFrom", structured.Key)
+	}
 	e.Key = structured.Key
 	e.Value = structured.Value
+	e.ValueFrom = structured.ValueFrom
 	e.Secret = structured.Secret
 	return nil
 }
 
 type BuildConfig struct {
-	Constructor string              `yaml:"constructor,omitempty"`
-	Ignore      []string            `yaml:"ignore"`
+	Constructor string   `yaml:"constructor,omitempty"`
+	Ignore      []string `yaml:"ignore"`
+	// Gitignore excludes the files ignored by git from the pushed source.
+	Gitignore bool `yaml:"gitignore,omitempty"`
+	// MaxSize is the size the pushed files must not exceed, e.g. 500MB.
+	MaxSize     string              `yaml:"maxSize,omitempty"`
 	Environment []EnvironmentConfig `yaml:"environment"`
 }
 
+// ParseSize parses a size like the maximum size of a build, given in bytes
+// or with a unit such as 500MB or 1GiB.
+func ParseSize(size string) (int64, error) {
+	n, err := humanize.ParseBytes(size)
+	if err != nil || n > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. 500MB or 1GiB", size)
+	}
+	return int64(n), nil
+}
+
 type DeploymentConfig struct {
 	Skip        bool                `yaml:"skip"`
 	Environment []EnvironmentConfig `yaml:"environment"`
 }
 
+// ProfileConfig overlays the configuration of a service, e.g. to deploy it
+// to another project. Empty fields keep the values of the service,
+// environment variables are merged by key.
+type ProfileConfig struct {
+	Project    string                   `yaml:"project,omitempty"`
+	Build      *ProfileBuildConfig      `yaml:"build,omitempty"`
+	Deployment *ProfileDeploymentConfig `yaml:"deployment,omitempty"`
+}
+
+// ProfileBuildConfig overlays the build of a service. Unlike in BuildConfig,
+// switches are pointers, so that a profile can turn them off as well.
+type ProfileBuildConfig struct {
+	Constructor string              `yaml:"constructor,omitempty"`
+	Ignore      []string            `yaml:"ignore,omitempty"`
+	Gitignore   *bool               `yaml:"gitignore,omitempty"`
+	MaxSize     string              `yaml:"maxSize,omitempty"`
+	Environment []EnvironmentConfig `yaml:"environment,omitempty"`
+}
+
+// ProfileDeploymentConfig overlays the deployment of a service.
+type ProfileDeploymentConfig struct {
+	Skip        *bool               `yaml:"skip,omitempty"`
+	Environment []EnvironmentConfig `yaml:"environment,omitempty"`
+}
+
+// overlay applies the profile to the configuration of a service.
+func (profile ProfileConfig) overlay(config *ServiceConfigYAML) {
+	if profile.Project != "" {
+		config.Project = profile.Project
+	}
+	if profile.Build != nil {
+		build := BuildConfig{}
+		if config.Build != nil {
+			build = *config.Build
+		}
+		if profile.Build.Constructor != "" {
+			build.Constructor = profile.Build.Constructor
+		}
+		if profile.Build.Ignore != nil {
+			build.Ignore = profile.Build.Ignore
+		}
+		if profile.Build.Gitignore != nil {
+			build.Gitignore = *profile.Build.Gitignore
+		}
+		if profile.Build.MaxSize != "" {
+			build.MaxSize = profile.Build.MaxSize
+		}
+		build.Environment = mergeEnvironment(build.Environment, profile.Build.Environment)
+		config.Build = &build
+	}
+	if profile.Deployment != nil {
+		deployment := DeploymentConfig{}
+		if config.Deployment != nil {
+			deployment = *config.Deployment
+		}
+		if profile.Deployment.Skip != nil {
+			deployment.Skip = *profile.Deployment.Skip
+		}
+		deployment.Environment = mergeEnvironment(deployment.Environment, profile.Deployment.Environment)
+		config.Deployment = &deployment
+	}
+}
+
+// mergeEnvironment replaces the variables of base with the ones of overlay
+// sharing their key and appends the others.
+func mergeEnvironment(base, overlay []EnvironmentConfig) []EnvironmentConfig {
+	merged := append([]EnvironmentConfig{}, base...)
+	for _, env := range overlay {
+		i := slices.IndexFunc(merged, func(e EnvironmentConfig) bool { return e.Key == env.Key })
+		if i < 0 {
+			merged = append(merged, env)
+			continue
+		}
+		merged[i] = env
+	}
+	return merged
+}
+
 type ServiceConfig interface {
 	Project() string
 	Service() string
@@ -76,37 +185,41 @@ type ServiceConfig interface {
 }
 
 type ValidatedServiceConfig struct {
-	yaml ServiceConfigYAML
+	yaml  ServiceConfigYAML
+	fatal func(message string)
+	// project overrides the project of the file without changing it.
+	project string
+	// services are the services of a monorepo, whichever one is selected.
+	services []ServiceConfigYAML
 }
 
 func (w *ValidatedServiceConfig) Project() string {
+	if w.project != "" {
+		return w.project
+	}
 	if w.yaml.Project == "" {
-		fmt.Fprintln(os.Stderr, "Operation requires service project.")
-		os.Exit(1)
+		w.fatal("Operation requires service project.")
 	}
 	return w.yaml.Project
 }
 
 func (w *ValidatedServiceConfig) Service() string {
 	if w.yaml.Service == "" {
-		fmt.Fprintln(os.Stderr, "Operation requires service reference (may be specified using --service).")
-		os.Exit(1)
+		w.fatal("Operation requires service reference (may be specified using --service).")
 	}
 	return w.yaml.Service
 }
 
 func (w *ValidatedServiceConfig) Build() BuildConfig {
 	if w.yaml.Build == nil {
-		fmt.Fprintln(os.Stderr, "Operation requires build specification.")
-		os.Exit(1)
+		w.fatal("Operation requires build specification.")
 	}
 	return *w.yaml.Build
 }
 
 func (w *ValidatedServiceConfig) Deployment() DeploymentConfig {
 	if w.yaml.Deployment == nil {
-		fmt.Fprintln(os.Stderr, "Operation requires deployment specification.")
-		os.Exit(1)
+		w.fatal("Operation requires deployment specification.")
 	}
 	return *w.yaml.Deployment
 }
@@ -115,60 +228,243 @@ func (w *ValidatedServiceConfig) Unwrap() ServiceConfigYAML {
 	return w.yaml
 }
 
+// Resolved returns the configuration of the service with the profile and
+// the project override applied.
+func (w *ValidatedServiceConfig) Resolved() ServiceConfigYAML {
+	resolved := w.yaml
+	if w.project != "" {
+		resolved.Project = w.project
+	}
+	resolved.Services, resolved.Profiles = nil, nil
+	return resolved
+}
+
+// Dir returns the source directory of a service of a monorepo, or an empty
+// string if the file describes a single service.
+func (w *ValidatedServiceConfig) Dir() string {
+	return w.yaml.Dir()
+}
+
+// Services returns the services of a monorepo, or nil if the file describes
+// a single service.
+func (w *ValidatedServiceConfig) Services() []*ValidatedServiceConfig {
+	var services []*ValidatedServiceConfig
+	for _, entry := range w.services {
+		services = append(services, &ValidatedServiceConfig{yaml: entry, fatal: w.fatal, project: w.project})
+	}
+	return services
+}
+
 func NewServiceConfigFromFile(path string) (*ValidatedServiceConfig, error) {
 	cfg := ServiceConfigYAML{}
 	if err := cfg.ReadFromFile(path); err != nil {
 		return nil, err
 	}
-	return &ValidatedServiceConfig{yaml: cfg}, nil
+	return &ValidatedServiceConfig{yaml: cfg, fatal: defaultFatal}, nil
 }
 
+// NewServiceConfigWithFallback reads the service configuration, falling back
+// to the CLI configuration if there is none. A project override of the CLI
+// configuration takes precedence over the one of the file.
 func NewServiceConfigWithFallback(path string, service *string, cli *CLIConfig) (*ValidatedServiceConfig, error) {
 	cfg := ServiceConfigYAML{}
-	if err := cfg.ReadFromFile(path); errors.Is(err, os.ErrNotExist) {
+	err := cfg.ReadFromFile(path)
+	if errors.Is(err, os.ErrNotExist) {
 		if service == nil {
-			return &ValidatedServiceConfig{ServiceConfigYAML{Project: cli.Project()}}, nil
+			return &ValidatedServiceConfig{yaml: ServiceConfigYAML{Project: cli.Project()}, fatal: cli.fatal}, nil
 		}
-		return &ValidatedServiceConfig{ServiceConfigYAML{Project: cli.Project(), Service: *service}}, nil
-	} else if err == nil && service != nil && len(*service) > 0 {
-		return &ValidatedServiceConfig{ServiceConfigYAML{Project: cfg.Project, Service: *service}}, nil
+		return &ValidatedServiceConfig{yaml: ServiceConfigYAML{Project: cli.Project(), Service: *service}, fatal: cli.fatal}, nil
 	}
-	return &ValidatedServiceConfig{yaml: cfg}, nil
+	validated := &ValidatedServiceConfig{yaml: cfg, fatal: cli.fatal, project: cli.Overrides.Project}
+	if err == nil && len(cfg.Services) > 0 {
+		name := ""
+		if service != nil {
+			name = *service
+		}
+		validated.yaml = cfg.SelectService(name)
+		validated.services = cfg.ServiceEntries()
+	} else if err == nil && service != nil && len(*service) > 0 && *service != cfg.Service {
+		validated.yaml = ServiceConfigYAML{Project: cfg.Project, Service: *service, filePath: cfg.filePath, inherited: cfg.Profiles}
+	}
+	// Profiles selected by the context only apply where they are defined.
+	if profile, explicit := cli.Profile(); err == nil && profile != "" {
+		if err := validated.applyProfile(profile); err != nil && explicit {
+			return nil, err
+		}
+	}
+	return validated, nil
+}
+
+// applyProfile overlays the selected service and all services of a monorepo
+// with the named profile. It fails if none of them defines it.
+func (w *ValidatedServiceConfig) applyProfile(name string) error {
+	err := w.yaml.ApplyProfile(name)
+	for i := range w.services {
+		if w.services[i].ApplyProfile(name) == nil {
+			err = nil
+		}
+	}
+	return err
 }
 
 type ServiceConfigYAML struct {
-	Project    string            `yaml:"project,omitempty"`
-	Service    string            `yaml:"service,omitempty"`
+	Project string `yaml:"project,omitempty"`
+	Service string `yaml:"service,omitempty"`
+	// Path is the source directory of a service of a monorepo, relative to
+	// the file listing it.
+	Path       string            `yaml:"path,omitempty"`
 	Build      *BuildConfig      `yaml:"build"`
 	Deployment *DeploymentConfig `yaml:"deployment"`
+	// Services lists the services of a monorepo, which inherit the project
+	// of the file unless they name their own.
+	Services []ServiceConfigYAML `yaml:"services,omitempty"`
+	// Profiles overlay the configuration when selected. In a monorepo, the
+	// profiles of the file apply to all services before their own.
+	Profiles map[string]ProfileConfig `yaml:"profiles,omitempty"`
 
 	filePath string `yaml:"-"`
+	// dir is the source directory of a service of a monorepo.
+	dir string `yaml:"-"`
+	// inherited are the profiles of the file listing a service of a monorepo.
+	inherited map[string]ProfileConfig `yaml:"-"`
+}
+
+// ApplyProfile overlays the configuration with the named profile. It fails if
+// the profile is not defined.
+func (config *ServiceConfigYAML) ApplyProfile(name string) error {
+	inherited, inheritedOK := config.inherited[name]
+	profile, ok := config.Profiles[name]
+	if !ok && !inheritedOK {
+		return fmt.Errorf("profile %s is not defined in %s", name, config.filePath)
+	}
+	inherited.overlay(config)
+	profile.overlay(config)
+	return nil
+}
+
+// Dir returns the source directory of a service of a monorepo, or an empty
+// string if the file describes a single service.
+func (config *ServiceConfigYAML) Dir() string {
+	return config.dir
+}
+
+// ServiceEntries returns the services of a monorepo with their project and
+// source directory resolved.
+func (config *ServiceConfigYAML) ServiceEntries() []ServiceConfigYAML {
+	var entries []ServiceConfigYAML
+	for _, entry := range config.Services {
+		if entry.Project == "" {
+			entry.Project = config.Project
+		}
+		entry.filePath = config.filePath
+		entry.dir = filepath.Join(filepath.Dir(config.filePath), filepath.FromSlash(entry.Path))
+		entry.inherited = config.Profiles
+		entry.Services = nil
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// SelectService picks a service of a monorepo, either by name or, if name is
+// empty, as the one whose source directory contains the working directory.
+// Services not listed are assumed to belong to the project of the file.
+func (config *ServiceConfigYAML) SelectService(name string) ServiceConfigYAML {
+	wd, _ := os.Getwd()
+	var selected *ServiceConfigYAML
+	for _, entry := range config.ServiceEntries() {
+		if name != "" {
+			if entry.Service == name {
+				return entry
+			}
+			continue
+		}
+		rel, err := filepath.Rel(entry.dir, wd)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		// Prefer the innermost of nested services.
+		if selected == nil || len(entry.dir) > len(selected.dir) {
+			selected = &entry
+		}
+	}
+	if selected != nil {
+		return *selected
+	}
+	return ServiceConfigYAML{Project: config.Project, Service: name, filePath: config.filePath, inherited: config.Profiles}
 }
 
 func (config *ServiceConfigYAML) ReadFromFile(name string) error {
+	path, err := FindServiceConfig(name)
+	if err != nil {
+		return err
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config read: %w", err)
+	}
+	defer fd.Close()
+	decoder := yaml.NewDecoder(fd)
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("config read: %w", err)
+	}
+	config.filePath = path
+	config.setDir(filepath.Dir(path))
+	return nil
+}
+
+// FindServiceConfig looks for the named file in the working directory and its
+// parents and returns the path of the innermost one. It returns
+// os.ErrNotExist if there is none.
+func FindServiceConfig(name string) (string, error) {
 	// Do recursive discovery
 	cwd, err := os.Getwd()
 	if err != nil {
-		return fmt.Errorf("getwd: %w", err)
+		return "", fmt.Errorf("getwd: %w", err)
 	}
 	parent := ""
 	for cwd != parent {
 		parent = cwd
-		path := filepath.Join(filepath.Join(cwd, name))
-		fd, err := os.Open(path)
-		if err != nil {
-			cwd, _ = filepath.Split(filepath.Clean(cwd))
-			continue
+		path := filepath.Join(cwd, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
 		}
-		defer fd.Close()
-		decoder := yaml.NewDecoder(fd)
-		if err := decoder.Decode(config); err != nil {
-			return fmt.Errorf("config read: %w", err)
+		cwd = filepath.Dir(cwd)
+	}
+	return "", os.ErrNotExist
+}
+
+// setDir records the directory of the file on all variables, as their
+// sources are relative to it.
+func (config *ServiceConfigYAML) setDir(dir string) {
+	for _, env := range config.environments() {
+		for i := range env {
+			env[i].dir = dir
 		}
-		config.filePath = path
-		return nil
 	}
-	return os.ErrNotExist
+}
+
+// environments returns all lists of variables, including the ones of
+// profiles and of the services of a monorepo.
+func (config *ServiceConfigYAML) environments() [][]EnvironmentConfig {
+	var envs [][]EnvironmentConfig
+	if config.Build != nil {
+		envs = append(envs, config.Build.Environment)
+	}
+	if config.Deployment != nil {
+		envs = append(envs, config.Deployment.Environment)
+	}
+	for _, profile := range config.Profiles {
+		if profile.Build != nil {
+			envs = append(envs, profile.Build.Environment)
+		}
+		if profile.Deployment != nil {
+			envs = append(envs, profile.Deployment.Environment)
+		}
+	}
+	for i := range config.Services {
+		envs = append(envs, config.Services[i].environments()...)
+	}
+	return envs
 }
 
 func (config *ServiceConfigYAML) WriteToFile(path string) error {
@@ -184,6 +480,55 @@ func (config *ServiceConfigYAML) WriteToFile(path string) error {
 	return nil
 }
 
+// UpdateEnvironment changes the variables of the service in the file it has
+// been read from. The variables are the ones of the service as written,
+// without a profile applied, and in a monorepo the ones of its entry.
+func (w *ValidatedServiceConfig) UpdateEnvironment(build bool, update func(env []EnvironmentConfig) ([]EnvironmentConfig, error)) error {
+	path := w.yaml.filePath
+	if path == "" {
+		return fmt.Errorf("config write: no service configuration found")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config read: %w", err)
+	}
+	var file ServiceConfigYAML
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("config read: %w", err)
+	}
+	target := &file
+	if len(file.Services) > 0 {
+		i := slices.IndexFunc(file.Services, func(entry ServiceConfigYAML) bool {
+			return entry.Service == w.yaml.Service
+		})
+		if i < 0 {
+			return fmt.Errorf("service %s is not listed in %s", w.yaml.Service, path)
+		}
+		target = &file.Services[i]
+	}
+	var env *[]EnvironmentConfig
+	if build {
+		if target.Build == nil {
+			target.Build = &BuildConfig{}
+		}
+		env = &target.Build.Environment
+	} else {
+		if target.Deployment == nil {
+			target.Deployment = &DeploymentConfig{}
+		}
+		env = &target.Deployment.Environment
+	}
+	if *env, err = update(*env); err != nil {
+		return err
+	}
+	return file.WriteToFile(path)
+}
+
 func (config *ServiceConfigYAML) WriteBack() error {
 	return config.WriteToFile(config.filePath)
 }
+
+// FilePath returns the path the configuration has been read from.
+func (config *ServiceConfigYAML) FilePath() string {
+	return config.filePath
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestApplyProfile(t *testing.T) {
+	var config ServiceConfigYAML
+	err := yaml.Unmarshal([]byte(`project: acme
+service: web
+build:
+  constructor: go1.22
+  gitignore: true
+  environment:
+    - GOFLAGS=-mod=vendor
+deployment:
+  skip: true
+  environment:
+    - LEVEL=debug
+    - REGION=eu
+profiles:
+  prod:
+    project: acme-prod
+    build:
+      gitignore: false
+    deployment:
+      skip: false
+      environment:
+        - LEVEL=info
+  keep:
+    build:
+      constructor: go1.23
+`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keep := config
+	if err := keep.ApplyProfile("keep"); err != nil {
+		t.Fatal(err)
+	}
+	if !keep.Build.Gitignore || !keep.Deployment.Skip || keep.Build.Constructor != "go1.23" {
+		t.Errorf("profile without switches: build %+v, deployment %+v", keep.Build, keep.Deployment)
+	}
+
+	if err := config.ApplyProfile("prod"); err != nil {
+		t.Fatal(err)
+	}
+	if config.Project != "acme-prod" || config.Build.Constructor != "go1.22" {
+		t.Errorf("project %s, constructor %s, want acme-prod and go1.22", config.Project, config.Build.Constructor)
+	}
+	if config.Build.Gitignore {
+		t.Error("profile setting gitignore: false kept the gitignore of the build")
+	}
+	if config.Deployment.Skip {
+		t.Error("profile setting skip: false kept skipping the deployment")
+	}
+	env := config.Deployment.Environment
+	if len(env) != 2 || env[0].Key != "LEVEL" || env[0].Value != "info" || env[1].Key != "REGION" {
+		t.Errorf("deployment environment = %+v, want LEVEL=info and REGION=eu", env)
+	}
+
+	if err := config.ApplyProfile("staging"); err == nil {
+		t.Error("applying an undefined profile succeeded")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tt := range []struct {
+		size string
+		want int64
+		err  bool
+	}{
+		{size: "500", want: 500},
+		{size: "500MB", want: 500_000_000},
+		{size: "1GiB", want: 1 << 30},
+		{size: "1.5 KiB", want: 1536},
+		{size: "big", err: true},
+		{size: "", err: true},
+		{size: "-1MB", err: true},
+		{size: "10EiB", err: true},
+	} {
+		got, err := ParseSize(tt.size)
+		if tt.err {
+			if err == nil || !strings.Contains(err.Error(), "expected e.g. 500MB or 1GiB") {
+				t.Errorf("ParseSize(%q) = %d, %v, want an error", tt.size, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tt.size, got, err, tt.want)
+		}
+	}
+}
+
+func TestMergeEnvironment(t *testing.T) {
+	base := []EnvironmentConfig{{Key: "A", Value: "1"}, {Key: "B", Value: "2"}}
+	overlay := []EnvironmentConfig{{Key: "C", Value: "3"}, {Key: "A", Value: "encrypted:x", Secret: true}}
+	got := mergeEnvironment(base, overlay)
+	want := []EnvironmentConfig{{Key: "A", Value: "encrypted:x", Secret: true}, {Key: "B", Value: "2"}, {Key: "C", Value: "3"}}
+	if !slices.Equal(got, want) {
+		t.Errorf("mergeEnvironment = %+v, want %+v", got, want)
+	}
+	if base[0].Value != "1" {
+		t.Errorf("mergeEnvironment modified the base: %+v", base)
+	}
+	if got := mergeEnvironment(base, nil); !slices.Equal(got, base) {
+		t.Errorf("mergeEnvironment without overlay = %+v, want %+v", got, base)
+	}
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/valar/cli/api"
+	"github.com/valar/cli/util"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is a mistake found in a service configuration.
+type Problem struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Column == 0 {
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, p.Message)
+}
+
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// ValidateServiceConfig checks the content of the service configuration at
+// path strictly. Besides fields not matching the schema, it reports invalid
+// names, duplicate variables, builds without a constructor and malformed
+// ignore patterns.
+func ValidateServiceConfig(path string, data []byte) []Problem {
+	v := &validator{file: path}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		line := 0
+		message := err.Error()
+		if m := yamlErrorLine.FindStringSubmatch(message); m != nil {
+			line, _ = strconv.Atoi(m[1])
+			message = strings.TrimPrefix(message, m[0])
+		}
+		return []Problem{{File: path, Line: line, Message: message}}
+	}
+	if doc.Kind == 0 || len(doc.Content) == 0 {
+		return []Problem{{File: path, Line: 1, Column: 1, Message: "the file is empty"}}
+	}
+	root := doc.Content[0]
+	schema := ServiceConfigSchema()
+	schema.validate(schema, root, v.report)
+	v.checkService(root, "", true)
+	slices.SortStableFunc(v.problems, func(a, b Problem) int {
+		return cmp.Or(cmp.Compare(a.Line, b.Line), cmp.Compare(a.Column, b.Column))
+	})
+	return v.problems
+}
+
+type validator struct {
+	file     string
+	problems []Problem
+}
+
+func (v *validator) report(node *yaml.Node, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{File: v.file, Line: node.Line, Column: node.Column, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkService checks a service, or a monorepo and its services if the node
+// lists them. Services of a monorepo inherit the project of the file.
+func (v *validator) checkService(node *yaml.Node, project string, top bool) {
+	if value := field(node, "project"); value != nil {
+		project = value.Value
+		if err := api.VerifyProjectName(project); err != nil {
+			v.report(value, "%v", err)
+		}
+	}
+	if value := field(node, "service"); value != nil {
+		if err := api.VerifyServiceName(value.Value); err != nil {
+			v.report(value, "%v", err)
+		}
+	} else if !top {
+		v.report(node, "missing field service")
+	}
+	if build := field(node, "build"); build != nil {
+		if constructor := field(build, "constructor"); constructor == nil || constructor.Value == "" {
+			v.report(build, "the build has no constructor")
+		}
+		v.checkBuild(build)
+	}
+	if deployment := field(node, "deployment"); deployment != nil {
+		v.checkEnvironment(field(deployment, "environment"))
+	}
+	if profiles := field(node, "profiles"); profiles != nil && profiles.Kind == yaml.MappingNode {
+		for i := 1; i < len(profiles.Content); i += 2 {
+			profile := profiles.Content[i]
+			if value := field(profile, "project"); value != nil {
+				if err := api.VerifyProjectName(value.Value); err != nil {
+					v.report(value, "%v", err)
+				}
+			}
+			if build := field(profile, "build"); build != nil {
+				v.checkBuild(build)
+			}
+			if deployment := field(profile, "deployment"); deployment != nil {
+				v.checkEnvironment(field(deployment, "environment"))
+			}
+		}
+	}
+	services := field(node, "services")
+	if services != nil && services.Kind != yaml.SequenceNode {
+		services = nil
+	}
+	if services != nil && top {
+		names := map[string]int{}
+		for _, entry := range services.Content {
+			v.checkService(entry, project, false)
+			if value := field(entry, "service"); value != nil {
+				if line, ok := names[value.Value]; ok {
+					v.report(value, "service %s is already listed on line %d", value.Value, line)
+				}
+				names[value.Value] = value.Line
+			}
+		}
+	} else if services != nil {
+		v.report(services, "services of a monorepo cannot list services")
+	}
+}
+
+func (v *validator) checkBuild(build *yaml.Node) {
+	v.checkEnvironment(field(build, "environment"))
+	if size := field(build, "maxSize"); size != nil && size.Kind == yaml.ScalarNode {
+		if _, err := ParseSize(size.Value); err != nil {
+			v.report(size, "%v", err)
+		}
+	}
+	if ignore := field(build, "ignore"); ignore != nil && ignore.Kind == yaml.SequenceNode {
+		for _, pattern := range ignore.Content {
+			if err := checkIgnorePattern(pattern.Value); err != nil {
+				v.report(pattern, "%v", err)
+			}
+		}
+	}
+}
+
+// checkEnvironment reports duplicate keys. Malformed sources of values are
+// reported by the schema.
+func (v *validator) checkEnvironment(env *yaml.Node) {
+	if env == nil || env.Kind != yaml.SequenceNode {
+		return
+	}
+	keys := map[string]int{}
+	for _, item := range env.Content {
+		var e EnvironmentConfig
+		// Malformed variables are reported by the schema.
+		if item.Decode(&e) != nil {
+			continue
+		}
+		if line, ok := keys[e.Key]; ok {
+			v.report(item, "variable %s is already defined on line %d", e.Key, line)
+		}
+		keys[e.Key] = item.Line
+	}
+}
+
+// checkIgnorePattern reports ignore patterns that are empty or malformed.
+func checkIgnorePattern(pattern string) error {
+	if strings.TrimSpace(pattern) == "" {
+		return fmt.Errorf("empty ignore pattern")
+	}
+	return util.CheckIgnorePattern(pattern)
+}
+
+// field returns the value of the named field of a mapping, or nil if it is
+// not set.
+func field(node *yaml.Node, name string) *yaml.Node {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == name {
+			value := node.Content[i+1]
+			if value.Kind == yaml.AliasNode {
+				value = value.Alias
+			}
+			if value.Kind == yaml.ScalarNode && value.ShortTag() == "!!null" {
+				return nil
+			}
+			return value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateServiceConfig(t *testing.T) {
+	for _, tt := range []struct {
+		name, config string
+		want         []string
+	}{
+		{
+			name:   "valid",
+			config: "project: acme\nservice: web\nbuild:\n  constructor: go1.22\n  environment:\n  - key: URL\n    value: ${HOST}\ndeployment: {}\n",
+		},
+		{
+			name:   "empty",
+			config: "",
+			want:   []string{"broken.yml:1:1: the file is empty"},
+		},
+		{
+			name:   "syntax",
+			config: "project: acme\n  service: web\n",
+			want:   []string{"broken.yml:2: mapping values are not allowed in this context"},
+		},
+		{
+			name: "mistakes",
+			config: `project: acme
+service: web-app
+build:
+  enviroment:
+  - A=1
+  ignore: ["[abc", "[[:word:]]"]
+  maxSize: big
+deployment:
+  skip: maybe
+  environment:
+  - A=1
+  - key: A
+    value: "2"
+  - key: B
+    valueFrom: env:B
+profiles:
+  prod:
+    build:
+      maxSize: 1GB
+      gitignore: "yes"
+`,
+			want: []string{
+				"broken.yml:2:10: invalid service name: may only contain alphanumerics",
+				"broken.yml:4:3: unknown field enviroment, did you mean environment?",
+				"broken.yml:4:3: the build has no constructor",
+				`broken.yml:6:12: invalid ignore pattern "[abc": unterminated character class`,
+				`broken.yml:6:20: invalid ignore pattern "[[:word:]]": unknown character class [:word:]`,
+				`broken.yml:7:12: invalid size "big", expected e.g. 500MB or 1GiB`,
+				`broken.yml:9:9: expected true or false, got "maybe"`,
+				"broken.yml:12:5: variable A is already defined on line 11",
+				`broken.yml:15:16: expected file:<path> or command:<command>, got "env:B"`,
+				`broken.yml:20:18: expected true or false, got "yes"`,
+			},
+		},
+	} {
+		var got []string
+		for _, problem := range ValidateServiceConfig("broken.yml", []byte(tt.config)) {
+			got = append(got, problem.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: ValidateServiceConfig =\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
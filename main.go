@@ -1,5 +1,7 @@
 package main
 
+//go:generate sh -c "go run . service schema > valar.schema.json"
+
 import "github.com/valar/cli/cmd"
 
 func main() {
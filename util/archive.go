@@ -0,0 +1,190 @@
+package util
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+)
+
+// archiveModTime is the modification time of all entries of an archive, so
+// that archives of the same files are identical regardless of when they
+// have been checked out.
+var archiveModTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// SourceFile is a file or directory of a source directory.
+type SourceFile struct {
+	// Path is the location of the file, Name its path relative to the
+	// source directory using slashes.
+	Path, Name string
+	Info       os.FileInfo
+}
+
+// ListSource returns the files and directories of the source directory not
+// excluded by ignore, sorted by name.
+func ListSource(sourcePath string, ignore *Ignorer) ([]SourceFile, error) {
+	var files []SourceFile
+	err := WalkSource(sourcePath, ignore, func(path, name string, info os.FileInfo) error {
+		files = append(files, SourceFile{Path: path, Name: filepath.ToSlash(name), Info: info})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// ArchiveSummary describes an archive written by WriteArchive.
+type ArchiveSummary struct {
+	// Files is the number of regular files, Size their total size.
+	Files int
+	Size  int64
+	// ArchiveSize is the size of the compressed archive.
+	ArchiveSize int64
+	// SHA256 is the digest of the archive.
+	SHA256 string
+	// SourceSHA256 is the digest of the names, modes and contents of the
+	// archived files, which does not depend on the compression.
+	SourceSHA256 string
+}
+
+// Encodings an archive can be compressed with.
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// newCompressor compresses what is written to w with the encoding. Gzip is
+// compressed in parallel blocks, whose size keeps the output independent of
+// the number of CPUs, and with a header carrying no name or time.
+func newCompressor(w io.Writer, encoding string) (io.WriteCloser, error) {
+	switch encoding {
+	case EncodingGzip, "":
+		return pgzip.NewWriterLevel(w, pgzip.DefaultCompression)
+	case EncodingZstd:
+		// Encoding on a single goroutine keeps the output deterministic.
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nil, fmt.Errorf("unknown archive encoding %q", encoding)
+}
+
+// WriteArchive writes the files as a tar compressed with the encoding to w.
+// The archive only depends on the names, contents and executable bits of
+// the files, as timestamps and ownership are normalized.
+func WriteArchive(w io.Writer, files []SourceFile, encoding string) (*ArchiveSummary, error) {
+	return writeArchive(w, files, encoding, io.Discard)
+}
+
+// writeArchive is WriteArchive, writing the contents of the files to
+// progress as well as they are archived.
+func writeArchive(w io.Writer, files []SourceFile, encoding string, progress io.Writer) (*ArchiveSummary, error) {
+	summary := &ArchiveSummary{}
+	archiveHash, sourceHash := sha256.New(), sha256.New()
+	archiveSize := &countingWriter{}
+	compressor, err := newCompressor(io.MultiWriter(w, archiveHash, archiveSize), encoding)
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (*ArchiveSummary, error) {
+		compressor.Close()
+		return nil, err
+	}
+	tw := tar.NewWriter(compressor)
+	for _, file := range files {
+		hdr := &tar.Header{
+			Name:    file.Name,
+			ModTime: archiveModTime,
+			Mode:    0644,
+			Format:  tar.FormatPAX,
+		}
+		if file.Info.Mode()&0111 != 0 {
+			hdr.Mode = 0755
+		}
+		switch {
+		case file.Info.IsDir():
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+			hdr.Mode = 0755
+		case file.Info.Mode().IsRegular():
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = file.Info.Size()
+		default:
+			// Devices, sockets and pipes have no content to build from.
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fail(fmt.Errorf("archive %s: %w", file.Name, err))
+		}
+		digest := "-"
+		if hdr.Typeflag == tar.TypeReg {
+			if digest, err = copyFile(io.MultiWriter(tw, progress), file.Path, hdr.Size); err != nil {
+				return fail(fmt.Errorf("archive %s: %w", file.Name, err))
+			}
+			summary.Files++
+			summary.Size += hdr.Size
+		}
+		fmt.Fprintf(sourceHash, "%o %s %s\x00", hdr.Mode, digest, hdr.Name)
+	}
+	if err := tw.Close(); err != nil {
+		return fail(fmt.Errorf("archive: %w", err))
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, fmt.Errorf("archive: %w", err)
+	}
+	summary.ArchiveSize = archiveSize.n.Load()
+	summary.SHA256 = hexDigest(archiveHash)
+	summary.SourceSHA256 = hexDigest(sourceHash)
+	return summary, nil
+}
+
+// copyFile copies the content of the file to w and returns its digest. The
+// file must not have changed its size since it has been listed, so that the
+// archive header, content and digest describe the same data.
+func copyFile(w io.Writer, path string, size int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), io.LimitReader(f, size))
+	if err != nil {
+		return "", err
+	}
+	if n != size {
+		return "", fmt.Errorf("file changed while archiving")
+	}
+	// Files that grew are detected by reading past the listed size.
+	var extra [1]byte
+	if n, err := f.Read(extra[:]); n > 0 {
+		return "", fmt.Errorf("file changed while archiving")
+	} else if err != nil && err != io.EOF {
+		return "", err
+	}
+	return hexDigest(h), nil
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n atomic.Int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func hexDigest(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSource creates the files in a new source directory.
+func writeSource(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// listSource lists the files of the source directory.
+func listSource(t *testing.T, root string) []SourceFile {
+	t.Helper()
+	ignore, err := NewIgnorer(root, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := ListSource(root, ignore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestWriteArchiveChangedFile(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		change string
+	}{
+		{"grown", "hello world, grown"},
+		{"shrunk", "hi"},
+	} {
+		root := writeSource(t, map[string]string{"a.txt": "hello world"})
+		files := listSource(t, root)
+		if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte(tt.change), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := WriteArchive(io.Discard, files, EncodingGzip)
+		if err == nil || !strings.Contains(err.Error(), "archive a.txt: file changed while archiving") {
+			t.Errorf("archiving a %s file = %v, want it to fail", tt.name, err)
+		}
+	}
+}
+
+func TestWriteArchiveIsReproducible(t *testing.T) {
+	root := writeSource(t, map[string]string{"main.go": "package main\n", "lib/lib.go": "package lib\n", "run.sh": "#!/bin/sh\n"})
+	if err := os.Chmod(filepath.Join(root, "run.sh"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	files := listSource(t, root)
+	summaries := map[string]*ArchiveSummary{}
+	for _, encoding := range []string{EncodingGzip, EncodingZstd} {
+		summary, err := WriteArchive(io.Discard, files, encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Files != 3 || summary.Size != int64(len("package main\n")+len("package lib\n")+len("#!/bin/sh\n")) {
+			t.Errorf("%s archive has %d files of %d bytes", encoding, summary.Files, summary.Size)
+		}
+		summaries[encoding] = summary
+	}
+	if gzip, zstd := summaries[EncodingGzip], summaries[EncodingZstd]; gzip.SourceSHA256 != zstd.SourceSHA256 || gzip.SHA256 == zstd.SHA256 {
+		t.Errorf("source digests %s and %s should match, archive digests %s and %s differ", gzip.SourceSHA256, zstd.SourceSHA256, gzip.SHA256, zstd.SHA256)
+	}
+
+	// Neither timestamps nor permissions beyond the executable bit matter.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "main.go"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(root, "run.sh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	files = listSource(t, root)
+	for encoding, first := range summaries {
+		second, err := WriteArchive(io.Discard, files, encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *second != *first {
+			t.Errorf("%s archives of the same files differ: %+v and %+v", encoding, first, second)
+		}
+	}
+}
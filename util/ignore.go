@@ -0,0 +1,400 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// IgnoreFile is the name of the files listing patterns of further files to
+// exclude from the pushed source, relative to the directory they are in.
+const IgnoreFile = ".valarignore"
+
+const gitignoreFile = ".gitignore"
+
+// Ignorer decides which files of a source directory are excluded from the
+// archive, following the semantics of gitignore. Patterns are taken from, in
+// increasing order of precedence, the .gitignore files of the repository
+// if enabled, the ignore list of the build and the .valarignore files, where
+// the files of a directory take precedence over those of its parents.
+type Ignorer struct {
+	root      string
+	gitignore bool
+
+	// outer are the rules of the .gitignore files of the directories
+	// between the repository and the source directory.
+	outer []ignoreRules
+	// dirs are the rules of the files of each directory loaded so far,
+	// by path relative to the source directory.
+	dirs map[string][]ignoreRules
+	// ignoredDirs caches the results for directories.
+	ignoredDirs map[string]bool
+}
+
+// ignoreRules are the rules of an ignore file or of the ignore list.
+type ignoreRules struct {
+	// dir is the directory relative to the source directory the patterns
+	// are relative to, prefix the source directory relative to the
+	// directory of a .gitignore file outside of it.
+	dir, prefix string
+	rules       []ignoreRule
+}
+
+type ignoreRule struct {
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// NewIgnorer creates an Ignorer for the source directory at root, excluding
+// files matching one of the patterns. If gitignore is set, the .gitignore
+// files of the source directory and of the repository it is in apply too.
+func NewIgnorer(root string, patterns []string, gitignore bool) (*Ignorer, error) {
+	ig := &Ignorer{
+		root:        root,
+		gitignore:   gitignore,
+		dirs:        map[string][]ignoreRules{},
+		ignoredDirs: map[string]bool{},
+	}
+	if gitignore {
+		if err := ig.loadOuter(); err != nil {
+			return nil, err
+		}
+	}
+	rules := ignoreRules{}
+	for _, pattern := range patterns {
+		rule, ok, err := compileIgnorePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rules.rules = append(rules.rules, rule)
+		}
+	}
+	var sets []ignoreRules
+	if gitignore {
+		gitRules, err := readIgnoreFile(filepath.Join(root, gitignoreFile))
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, ignoreRules{rules: gitRules})
+	}
+	sets = append(sets, rules)
+	valarRules, err := readIgnoreFile(filepath.Join(root, IgnoreFile))
+	if err != nil {
+		return nil, err
+	}
+	ig.dirs["."] = append(sets, ignoreRules{rules: valarRules})
+	return ig, nil
+}
+
+// loadOuter reads the .gitignore files of the parent directories of the
+// source directory up to the root of its repository, if it is in one.
+func (ig *Ignorer) loadOuter() error {
+	abs, err := filepath.Abs(ig.root)
+	if err != nil {
+		return err
+	}
+	var dirs []string
+	for dir := abs; ; {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			// Not in a repository.
+			return nil
+		}
+		dir = parent
+		dirs = append(dirs, dir)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rules, err := readIgnoreFile(filepath.Join(dirs[i], gitignoreFile))
+		if err != nil {
+			return err
+		}
+		prefix, err := filepath.Rel(dirs[i], abs)
+		if err != nil {
+			return err
+		}
+		ig.outer = append(ig.outer, ignoreRules{prefix: filepath.ToSlash(prefix), rules: rules})
+	}
+	return nil
+}
+
+// Ignored reports whether the file or directory with the given path relative
+// to the source directory is excluded. Like in git, files of excluded
+// directories are excluded regardless of the patterns matching them.
+func (ig *Ignorer) Ignored(name string, isDir bool) (bool, error) {
+	name = path.Clean(filepath.ToSlash(name))
+	if name == "." {
+		return false, nil
+	}
+	if ignored, ok := ig.ignoredDirs[name]; ok && isDir {
+		return ignored, nil
+	}
+	parent := path.Dir(name)
+	if parent != "." {
+		ignored, err := ig.Ignored(parent, true)
+		if err != nil || ignored {
+			return ignored, err
+		}
+	}
+	sets, err := ig.rules(parent)
+	if err != nil {
+		return false, err
+	}
+	ignored := false
+	for _, set := range slices.Concat(ig.outer, sets) {
+		rel := name
+		switch {
+		case set.prefix != "":
+			rel = set.prefix + "/" + name
+		case set.dir != "":
+			rel = strings.TrimPrefix(name, set.dir+"/")
+		}
+		for _, rule := range set.rules {
+			if (!rule.dirOnly || isDir) && rule.re.MatchString(rel) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	if isDir {
+		ig.ignoredDirs[name] = ignored
+	}
+	return ignored, nil
+}
+
+// rules returns the rules applying to the files of a directory, loading the
+// ignore files of the directory and its parents if necessary.
+func (ig *Ignorer) rules(dir string) ([]ignoreRules, error) {
+	if sets, ok := ig.dirs[dir]; ok {
+		return sets, nil
+	}
+	parent, err := ig.rules(path.Dir(dir))
+	if err != nil {
+		return nil, err
+	}
+	sets := append([]ignoreRules{}, parent...)
+	files := []string{IgnoreFile}
+	if ig.gitignore {
+		files = []string{gitignoreFile, IgnoreFile}
+	}
+	for _, file := range files {
+		rules, err := readIgnoreFile(filepath.Join(ig.root, filepath.FromSlash(dir), file))
+		if err != nil {
+			return nil, err
+		}
+		if len(rules) > 0 {
+			sets = append(sets, ignoreRules{dir: dir, rules: rules})
+		}
+	}
+	ig.dirs[dir] = sets
+	return sets, nil
+}
+
+// readIgnoreFile reads the patterns of an ignore file. Missing files have
+// no patterns.
+func readIgnoreFile(name string) ([]ignoreRule, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read ignore file: %w", err)
+	}
+	defer f.Close()
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		rule, ok, err := compileIgnorePattern(strings.TrimSuffix(scanner.Text(), "\r"))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read ignore file: %w", err)
+	}
+	return rules, nil
+}
+
+// CheckIgnorePattern reports whether pattern is a valid gitignore pattern.
+func CheckIgnorePattern(pattern string) error {
+	_, _, err := compileIgnorePattern(pattern)
+	return err
+}
+
+// compileIgnorePattern translates a gitignore pattern to a regular expression
+// matching the paths it applies to. Blank lines and comments are no rules.
+func compileIgnorePattern(pattern string) (ignoreRule, bool, error) {
+	var rule ignoreRule
+	// Trailing spaces are ignored unless escaped.
+	for strings.HasSuffix(pattern, " ") && !strings.HasSuffix(pattern, `\ `) {
+		pattern = pattern[:len(pattern)-1]
+	}
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return rule, false, nil
+	}
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return rule, false, fmt.Errorf("invalid ignore pattern: matches nothing")
+	}
+	// Patterns without a slash but at the end match at any depth, others
+	// relative to the directory of the pattern.
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var b strings.Builder
+	b.WriteString("^")
+	if !anchored {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); {
+		atSegment := i == 0 || pattern[i-1] == '/'
+		switch {
+		case atSegment && strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 3
+		case atSegment && pattern[i:] == "**":
+			b.WriteString(".*")
+			i += 2
+		case pattern[i] == '*':
+			b.WriteString("[^/]*")
+			i++
+		case pattern[i] == '?':
+			b.WriteString("[^/]")
+			i++
+		case pattern[i] == '[':
+			class, next, err := translateClass(pattern, i)
+			if err != nil {
+				return rule, false, err
+			}
+			b.WriteString(class)
+			i = next
+		case pattern[i] == '\\':
+			if i+1 == len(pattern) {
+				return rule, false, fmt.Errorf("invalid ignore pattern %q: trailing backslash", pattern)
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i+1 : i+2]))
+			i += 2
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			i++
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return rule, false, fmt.Errorf("invalid ignore pattern %q: %w", pattern, err)
+	}
+	rule.re = re
+	return rule, true, nil
+}
+
+// posixClasses are the character classes that may be named as [:name:] in a
+// bracket expression.
+var posixClasses = []string{"alnum", "alpha", "blank", "cntrl", "digit", "graph", "lower", "print", "punct", "space", "upper", "xdigit"}
+
+// translateClass translates the bracket expression starting at pattern[i] to
+// a regular expression and returns the index following it. As in gitignore,
+// backslashes escape the next character and classes never match a slash.
+func translateClass(pattern string, i int) (string, int, error) {
+	j := i + 1
+	negate := j < len(pattern) && (pattern[j] == '!' || pattern[j] == '^')
+	if negate {
+		j++
+	}
+	var b strings.Builder
+	for first := true; ; first = false {
+		if j >= len(pattern) {
+			return "", 0, fmt.Errorf("invalid ignore pattern %q: unterminated character class", pattern)
+		}
+		if pattern[j] == ']' && !first {
+			j++
+			break
+		}
+		if strings.HasPrefix(pattern[j:], "[:") {
+			if end := strings.Index(pattern[j+2:], ":]"); end >= 0 {
+				name := pattern[j+2 : j+2+end]
+				if !slices.Contains(posixClasses, name) {
+					return "", 0, fmt.Errorf("invalid ignore pattern %q: unknown character class [:%s:]", pattern, name)
+				}
+				b.WriteString("[:" + name + ":]")
+				j += end + 4
+				continue
+			}
+		}
+		lo, n, err := classChar(pattern, j)
+		if err != nil {
+			return "", 0, err
+		}
+		j += n
+		hi := lo
+		if j+1 < len(pattern) && pattern[j] == '-' && pattern[j+1] != ']' {
+			if hi, n, err = classChar(pattern, j+1); err != nil {
+				return "", 0, err
+			}
+			if hi < lo {
+				return "", 0, fmt.Errorf("invalid ignore pattern %q: invalid range %c-%c", pattern, lo, hi)
+			}
+			j += 1 + n
+		}
+		// Leave out the slash, which separates the segments of the path.
+		if lo < '/' {
+			writeClassRange(&b, lo, min(hi, '/'-1))
+		}
+		if hi > '/' {
+			writeClassRange(&b, max(lo, '/'+1), hi)
+		}
+	}
+	switch {
+	case negate:
+		return "[^/" + b.String() + "]", j, nil
+	case b.Len() == 0:
+		// A class of only slashes matches nothing.
+		return `[^\x00-\x{10FFFF}]`, j, nil
+	}
+	return "[" + b.String() + "]", j, nil
+}
+
+// classChar returns the possibly escaped character at pattern[j] and its
+// length.
+func classChar(pattern string, j int) (rune, int, error) {
+	escaped := pattern[j] == '\\'
+	if escaped {
+		if j+1 == len(pattern) {
+			return 0, 0, fmt.Errorf("invalid ignore pattern %q: unterminated character class", pattern)
+		}
+		j++
+	}
+	r, n := utf8.DecodeRuneInString(pattern[j:])
+	if escaped {
+		n++
+	}
+	return r, n, nil
+}
+
+// writeClassRange writes the range of characters from lo to hi to a bracket
+// expression, escaping them so that none has a special meaning.
+func writeClassRange(b *strings.Builder, lo, hi rune) {
+	fmt.Fprintf(b, `\x{%x}`, lo)
+	if hi > lo {
+		fmt.Fprintf(b, `-\x{%x}`, hi)
+	}
+}
@@ -0,0 +1,150 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIgnorer(t *testing.T) {
+	for _, tt := range []struct {
+		patterns []string
+		name     string
+		isDir    bool
+		ignored  bool
+	}{
+		// Patterns without a slash match at any depth.
+		{[]string{"*.log"}, "debug.log", false, true},
+		{[]string{"*.log"}, "logs/debug.log", false, true},
+		{[]string{"*.log"}, "debug.txt", false, false},
+		{[]string{"*"}, "a/b", false, true},
+		{[]string{"?.go"}, "a.go", false, true},
+		{[]string{"?.go"}, "ab.go", false, false},
+		// A leading or inner slash anchors the pattern.
+		{[]string{"/build"}, "build", true, true},
+		{[]string{"/build"}, "src/build", true, false},
+		{[]string{"src/*.go"}, "src/main.go", false, true},
+		{[]string{"src/*.go"}, "lib/src/main.go", false, false},
+		{[]string{"src/*.go"}, "src/cmd/main.go", false, false},
+		// A trailing slash only matches directories.
+		{[]string{"tmp/"}, "tmp", true, true},
+		{[]string{"tmp/"}, "tmp", false, false},
+		{[]string{"tmp/"}, "src/tmp", true, true},
+		// ** matches any number of directories.
+		{[]string{"**/fixtures"}, "fixtures", true, true},
+		{[]string{"**/fixtures"}, "a/b/fixtures", true, true},
+		{[]string{"docs/**"}, "docs/a/b.md", false, true},
+		{[]string{"docs/**"}, "docs", true, false},
+		{[]string{"a/**/b"}, "a/b", false, true},
+		{[]string{"a/**/b"}, "a/x/y/b", false, true},
+		{[]string{"a/**/b"}, "a/xb", false, false},
+		// Negation re-includes what earlier patterns exclude, unless a
+		// parent directory is excluded.
+		{[]string{"*.log", "!keep.log"}, "keep.log", false, false},
+		{[]string{"*.log", "!keep.log"}, "drop.log", false, true},
+		{[]string{"logs/", "!logs/keep.log"}, "logs/keep.log", false, true},
+		{[]string{"!keep.log", "*.log"}, "keep.log", false, true},
+		// Character classes.
+		{[]string{"file[0-9].txt"}, "file5.txt", false, true},
+		{[]string{"file[0-9].txt"}, "filex.txt", false, false},
+		{[]string{"file[!0-9].txt"}, "filex.txt", false, true},
+		{[]string{"file[^0-9].txt"}, "file5.txt", false, false},
+		{[]string{"file[]x].txt"}, "file].txt", false, true},
+		{[]string{"file[a-].txt"}, "file-.txt", false, true},
+		{[]string{`file[\d].txt`}, "filed.txt", false, true},
+		{[]string{`file[\d].txt`}, "file5.txt", false, false},
+		{[]string{`file[\]].txt`}, "file].txt", false, true},
+		{[]string{"file[.^$].txt"}, "file^.txt", false, true},
+		{[]string{"file[.^$].txt"}, "filex.txt", false, false},
+		{[]string{"[[:alpha:]]*.txt"}, "notes.txt", false, true},
+		{[]string{"[[:alpha:]]*.txt"}, "1.txt", false, false},
+		{[]string{"[![:digit:]]*.txt"}, "notes.txt", false, true},
+		{[]string{"a[!x]b"}, "a/b", false, false},
+		{[]string{"a[+-0]b"}, "a/b", false, false},
+		{[]string{"a[+-0]b"}, "a.b", false, true},
+		// Escaped characters are taken literally.
+		{[]string{`\!important`}, "!important", false, true},
+		{[]string{`\#notes`}, "#notes", false, true},
+		{[]string{`a\*b`}, "a*b", false, true},
+		{[]string{`a\*b`}, "axb", false, false},
+		{[]string{`trailing\ `}, "trailing ", false, true},
+		{[]string{"trailing "}, "trailing", false, true},
+		{[]string{"a+b(c).txt"}, "a+b(c).txt", false, true},
+		// Blank lines and comments are no patterns.
+		{[]string{"", "# *.go"}, "main.go", false, false},
+	} {
+		ig, err := NewIgnorer(t.TempDir(), tt.patterns, false)
+		if err != nil {
+			t.Errorf("NewIgnorer(%q): %v", tt.patterns, err)
+			continue
+		}
+		ignored, err := ig.Ignored(tt.name, tt.isDir)
+		if err != nil || ignored != tt.ignored {
+			t.Errorf("%q: Ignored(%s, %v) = %v, %v, want %v", tt.patterns, tt.name, tt.isDir, ignored, err, tt.ignored)
+		}
+	}
+}
+
+func TestCheckIgnorePattern(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, err string
+	}{
+		{"*.go", ""},
+		{"[[:alpha:]]", ""},
+		{"[", "unterminated character class"},
+		{"[a-", "unterminated character class"},
+		{`[a\`, "unterminated character class"},
+		{"[z-a]", "invalid range"},
+		{"[[:word:]]", "unknown character class [:word:]"},
+		{`end\`, "trailing backslash"},
+		{"/", "matches nothing"},
+	} {
+		err := CheckIgnorePattern(tt.pattern)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("CheckIgnorePattern(%q) = %v, want %q", tt.pattern, err, tt.err)
+		}
+	}
+}
+
+func TestIgnoreFiles(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		name = filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".gitignore", "*.tmp\n")
+	write(IgnoreFile, "*.bin\n")
+	write("sub/"+IgnoreFile, "!keep.bin\n/local\n")
+	for _, gitignore := range []bool{false, true} {
+		ig, err := NewIgnorer(root, []string{"*.out"}, gitignore)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range []struct {
+			name    string
+			isDir   bool
+			ignored bool
+		}{
+			{"a.tmp", false, gitignore},
+			{"a.out", false, true},
+			{"a.bin", false, true},
+			{"sub/a.bin", false, true},
+			{"sub/keep.bin", false, false},
+			{"keep.bin", false, true},
+			{"sub/local", true, true},
+			{"local", true, false},
+		} {
+			ignored, err := ig.Ignored(tt.name, tt.isDir)
+			if err != nil || ignored != tt.ignored {
+				t.Errorf("gitignore %v: Ignored(%s) = %v, %v, want %v", gitignore, tt.name, ignored, err, tt.ignored)
+			}
+		}
+	}
+}
@@ -6,61 +6,115 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/facebookgo/symwalk"
-	"github.com/mholt/archiver/v3"
 )
 
-func CompressDir(sourcePath string, ignores []string) (string, error) {
+// CompressDir archives the files in a temporary file, compressed with the
+// encoding, and returns it positioned at its start. The caller has to close
+// and remove the file.
+func CompressDir(files []SourceFile, encoding string) (*os.File, *ArchiveSummary, error) {
 	// Generate source pkg
 	tmpfile, err := ioutil.TempFile("", "valar")
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
-	defer tmpfile.Close()
+	summary, err := WriteArchive(tmpfile, files, encoding)
+	if err == nil {
+		_, err = tmpfile.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmpfile.Close()
+		os.Remove(tmpfile.Name())
+		return nil, nil, err
+	}
+	return tmpfile, summary, nil
+}
+
+// ArchiveReader streams an archive as it is written in the background, so
+// that it can be uploaded while the files are still being compressed.
+type ArchiveReader struct {
+	pr       *io.PipeReader
+	done     chan struct{}
+	archived countingWriter
+	summary  *ArchiveSummary
+	err      error
+}
+
+// NewArchiveReader starts archiving the files, compressed with the encoding.
+// The reader has to be closed to stop archiving if it is not read to the end.
+func NewArchiveReader(files []SourceFile, encoding string) *ArchiveReader {
+	pr, pw := io.Pipe()
+	r := &ArchiveReader{pr: pr, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		r.summary, r.err = writeArchive(pw, files, encoding, &r.archived)
+		pw.CloseWithError(r.err)
+	}()
+	return r
+}
+
+func (r *ArchiveReader) Read(p []byte) (int, error) {
+	return r.pr.Read(p)
+}
+
+// Close stops archiving and waits for it to end.
+func (r *ArchiveReader) Close() error {
+	r.pr.Close()
+	<-r.done
+	return nil
+}
+
+// Archived returns how many bytes of the contents of the files have been
+// archived so far.
+func (r *ArchiveReader) Archived() int64 {
+	return r.archived.n.Load()
+}
+
+// Summary waits for the archive to be written and describes it. It fails
+// with io.ErrClosedPipe if the reader has been closed before.
+func (r *ArchiveReader) Summary() (*ArchiveSummary, error) {
+	<-r.done
+	return r.summary, r.err
+}
+
+// WalkSource calls fn for every file and directory of the source directory
+// that is not excluded by ignore, following symbolic links. Excluded
+// directories are skipped as a whole. The name passed to fn is the path
+// relative to the source directory.
+func WalkSource(sourcePath string, ignore *Ignorer, fn func(path, name string, info os.FileInfo) error) error {
 	sourceInfo, err := os.Stat(sourcePath)
 	if err != nil {
-		return "", err
+		return err
 	}
 	if !sourceInfo.IsDir() {
-		return "", fmt.Errorf("expected directory")
+		return fmt.Errorf("expected directory")
 	}
-	tgz := archiver.NewTarGz()
-	tgz.Create(tmpfile)
-	defer tgz.Close()
-	err = symwalk.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+	return symwalk.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		name, err := filepath.Rel(sourcePath, path)
-		if err != nil {
+		if err != nil || name == "." {
 			return err
 		}
-		for _, prefix := range ignores {
-			if strings.HasPrefix(name, prefix) {
-				return nil
+		// Links to directories are walked, links to files archived as the
+		// files they point to.
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(path); err != nil {
+				return err
 			}
 		}
-		var file io.ReadCloser
-		if info.Mode().IsRegular() {
-			file, err = os.Open(path)
-			if err != nil {
-				return err
+		ignored, err := ignore.Ignored(name, info.IsDir())
+		if err != nil {
+			return err
+		}
+		if ignored {
+			if info.IsDir() {
+				return filepath.SkipDir
 			}
-			defer file.Close()
+			return nil
 		}
-		return tgz.Write(archiver.File{
-			FileInfo: archiver.FileInfo{
-				FileInfo:   info,
-				CustomName: name,
-			},
-			ReadCloser: file,
-		})
+		return fn(path, name, info)
 	})
-	if err != nil {
-		return "", err
-	}
-
-	return tmpfile.Name(), nil
 }